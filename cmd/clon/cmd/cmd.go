@@ -70,3 +70,14 @@ func rangeArgs(min, max int) func(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 }
+
+// dashArgs requires n arguments before '--' and
+// at least one argument after it.
+func dashArgs(n int) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if dash := cmd.ArgsLenAtDash(); dash != n || len(args) <= n {
+			return argsError{errors.Errorf("requires %d arg(s) followed by -- and command", n), cmd}
+		}
+		return nil
+	}
+}
@@ -1,20 +1,26 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 
 	"github.com/spirius/clon/pkg/cfn"
 	"github.com/spirius/clon/pkg/clon"
 
+	"github.com/Masterminds/sprig"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/fatih/color"
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 func newOutput(in interface{}) output {
@@ -84,6 +90,38 @@ func (o *outputCommon) Output(w io.Writer) {
 		err = outputStackEvent(w, data, o.typ)
 	case *clon.Plan:
 		err = outputPlan(w, data, o.typ)
+	case *clon.DestroyPlan:
+		err = outputDestroyPlan(w, data, o.typ)
+	case []*clon.Variable:
+		err = outputVariables(w, data, o.typ)
+	case *clon.Config:
+		err = outputConfig(w, data, o.typ)
+	case []*clon.StackNode:
+		err = outputGraph(w, data, o.typ)
+	case *clon.Warning:
+		err = outputWarning(w, data, o.typ)
+	case *clon.SharedValue:
+		err = outputSharedValue(w, data, o.typ)
+	case []*clon.SharedValue:
+		err = outputSharedValues(w, data, o.typ)
+	case *clon.ParameterExport:
+		err = outputParameterExport(w, data, o.typ)
+	case *clon.BuildStatus:
+		err = outputBuildStatus(w, data, o.typ)
+	case *clon.BuildOutput:
+		err = outputBuildOutput(w, data, o.typ)
+	case *envOutput:
+		err = outputEnv(w, data, o.typ)
+	case *clon.Hook:
+		err = outputHook(w, data, o.typ)
+	case *clon.HookOutput:
+		err = outputHookOutput(w, data, o.typ)
+	case *clon.BucketEmptied:
+		err = outputBucketEmptied(w, data, o.typ)
+	case *clon.DeploymentRecord:
+		err = outputDeploymentRecord(w, data, o.typ)
+	case []*clon.DeploymentRecord:
+		err = outputDeploymentRecords(w, data, o.typ)
 	default:
 		err = errors.Errorf("unknown data: %#+v", o.data)
 	}
@@ -107,6 +145,56 @@ func outputStringMap(w io.Writer, name string, m map[string]string) {
 	}
 }
 
+func outputConfig(w io.Writer, config *clon.Config, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for config is not implemented", typ)
+	}
+	out, err := yaml.Marshal(config.Map())
+	if err != nil {
+		return errors.Annotatef(err, "cannot encode config")
+	}
+	_, err = w.Write(out)
+	return errors.Trace(err)
+}
+
+func outputVariables(w io.Writer, vars []*clon.Variable, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for variables is not implemented", typ)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	defer tw.Flush()
+	for _, v := range vars {
+		var value string
+		if str, ok := v.Value.(string); ok {
+			value = strconv.Quote(str)
+		} else {
+			b, err := json.Marshal(v.Value)
+			if err != nil {
+				return errors.Annotatef(err, "cannot encode variable '%s'", v.Name)
+			}
+			value = string(b)
+		}
+		fmt.Fprintf(tw, "%s:\t%s\t%s\n", formatName(v.Name), value, color.HiBlackString("(%s)", v.Origin))
+	}
+	return nil
+}
+
+func outputGraph(w io.Writer, nodes []*clon.StackNode, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for graph is not implemented", typ)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	defer tw.Flush()
+	for _, n := range nodes {
+		deps := color.HiBlackString("-")
+		if len(n.DependsOn) > 0 {
+			deps = strings.Join(n.DependsOn, ", ")
+		}
+		fmt.Fprintf(tw, "%s:\t%s\n", formatName(n.Name), deps)
+	}
+	return nil
+}
+
 func outputStack(w io.Writer, stack *clon.StackData, typ int) error {
 	if typ == outputTypeStatusLine {
 		log.Infof("stack status - %s [%s] %s",
@@ -239,6 +327,38 @@ func outputPlan(w io.Writer, plan *clon.Plan, typ int) error {
 		}
 	}
 
+	if plan.SSMParameters.HasChange() {
+		fmt.Fprintf(tw, "\n%s:\n", cw("SSMParameters"))
+		names := make([]string, 0, len(plan.SSMParameters))
+		for name := range plan.SSMParameters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			param := plan.SSMParameters[name]
+			if param.IsEqual() {
+				continue
+			}
+			fmt.Fprintf(tw, "  %s:\t%s\n", cw(name), color.YellowString(param.String()))
+		}
+	}
+
+	if len(plan.Policies) > 0 {
+		fmt.Fprintf(tw, "\n%s:\n", cw("Policies"))
+		for _, r := range plan.Policies {
+			var effect string
+			switch r.Effect {
+			case clon.PolicyDeny:
+				effect = color.RedString("[deny]")
+			case clon.PolicyConfirm:
+				effect = color.HiRedString("[confirm]")
+			default:
+				effect = color.YellowString("[%s]", r.Effect)
+			}
+			fmt.Fprintf(tw, "  %s %s\n", effect, r.String())
+		}
+	}
+
 	if len(plan.ChangeSet.Changes) > 0 {
 		fmt.Fprintf(tw, "\n%s:\n", cw("ResourceChanges"))
 		for _, res := range plan.ChangeSet.Changes {
@@ -273,6 +393,61 @@ func outputPlan(w io.Writer, plan *clon.Plan, typ int) error {
 	return nil
 }
 
+func outputDestroyPlan(w io.Writer, plan *clon.DestroyPlan, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for destroy plan is not implemented", typ)
+	}
+	if err := outputStack(w, plan.Stack, outputTypeShort); err != nil {
+		return errors.Trace(err)
+	}
+
+	var cw = color.HiWhiteString
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	defer tw.Flush()
+	if plan.Protected {
+		fmt.Fprintf(tw, "%s:\t%s\n", cw("Protected"), color.RedString("true"))
+	}
+
+	if len(plan.Resources) > 0 {
+		fmt.Fprintf(tw, "\n%s:\n", cw("Resources"))
+		for _, r := range plan.Resources {
+			var res string
+			switch r.Action {
+			case clon.ResourceRetain:
+				res = color.GreenString("[R] %s", r.LogicalID)
+			case clon.ResourceUnknown:
+				res = color.YellowString("[?] %s", r.LogicalID)
+			default:
+				res = color.RedString("[-] %s", r.LogicalID)
+			}
+			policy := r.DeletionPolicy
+			if policy == "" && r.Action == clon.ResourceUnknown {
+				policy = "conditional"
+			} else if policy == "" {
+				policy = "default"
+			}
+			fmt.Fprintf(tw, "%s (%s)\t%s\t%s: %s\n", res, r.ResourceType, r.PhysicalID, cw("DeletionPolicy"), policy)
+		}
+	}
+
+	if len(plan.Dependents) > 0 {
+		fmt.Fprintf(tw, "\n%s:\n", cw("DependentStacks"))
+		for _, d := range plan.Dependents {
+			fmt.Fprintf(tw, "  %s\t%s\n", color.RedString(d.Stack), d.Reason)
+		}
+	}
+
+	if len(plan.Buckets) > 0 {
+		fmt.Fprintf(tw, "\n%s:\n", cw("EmptiedBuckets"))
+		for _, b := range plan.Buckets {
+			fmt.Fprintf(tw, "  %s (%s)\t%d object versions, %d delete markers\n",
+				color.RedString(b.Bucket), b.LogicalID, b.Versions, b.DeleteMarkers)
+		}
+	}
+	return nil
+}
+
 func outputChangeSet(_ io.Writer, cs *cfn.ChangeSetData, typ int) error {
 	if typ != outputTypeStatusLine {
 		return errors.Errorf("output type %d for change set is not implemented", typ)
@@ -298,3 +473,202 @@ func outputStackEvent(_ io.Writer, cs *cfn.StackEventData, typ int) error {
 	)
 	return nil
 }
+
+func outputWarning(_ io.Writer, warning *clon.Warning, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for warning is not implemented", typ)
+	}
+	log.Warnf("%s - %s", formatName(warning.Stack), warning.Message)
+	return nil
+}
+
+func outputSharedValue(w io.Writer, v *clon.SharedValue, typ int) error {
+	switch typ {
+	case outputTypeStatusLine:
+		log.Infof("published shared value - %s = %q (version %s)", formatName(v.Key), v.Value, v.VersionID)
+	case outputTypeLong:
+		fmt.Fprintln(w, v.Value)
+	default:
+		return errors.Errorf("output type %d for shared value is not implemented", typ)
+	}
+	return nil
+}
+
+func outputSharedValues(w io.Writer, values []*clon.SharedValue, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for shared values is not implemented", typ)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	defer tw.Flush()
+	for _, v := range values {
+		fmt.Fprintf(tw, "%s:\t%q\t%s\n", formatName(v.Key), v.Value,
+			color.HiBlackString("(%s.%s, version %s)", v.Stack, v.Output, v.VersionID))
+	}
+	return nil
+}
+
+func outputParameterExport(_ io.Writer, p *clon.ParameterExport, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for parameter export is not implemented", typ)
+	}
+	if p.Deleted {
+		log.Infof("deleted SSM parameter - %s", formatName(p.Name))
+	} else {
+		log.Infof("exported SSM parameter - %s (%s.%s)", formatName(p.Name), p.Stack, p.Output)
+	}
+	return nil
+}
+
+func outputBuildStatus(_ io.Writer, b *clon.BuildStatus, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for build status is not implemented", typ)
+	}
+	switch b.Status {
+	case clon.BuildFailed:
+		log.Errorf("build %s - %s (exit code %d, %s)", formatName(b.Name), b.Status, b.ExitCode, b.Duration.Round(time.Millisecond))
+	case clon.BuildSucceeded:
+		log.Infof("build %s - %s (%s)", formatName(b.Name), b.Status, b.Duration.Round(time.Millisecond))
+	default:
+		log.Infof("build %s - %s", formatName(b.Name), b.Status)
+	}
+	return nil
+}
+
+func outputBuildOutput(_ io.Writer, b *clon.BuildOutput, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for build output is not implemented", typ)
+	}
+	log.Infof("%s | %s", b.Name, b.Line)
+	return nil
+}
+
+func outputHook(_ io.Writer, h *clon.Hook, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for hook is not implemented", typ)
+	}
+	switch h.Status {
+	case clon.HookFailed:
+		log.Errorf("%s - %s[%d] hook %s (exit code %d, %s)", formatName(h.Stack), h.Kind, h.Index, h.Status, h.ExitCode, h.Duration.Round(time.Millisecond))
+	case clon.HookSucceeded:
+		log.Infof("%s - %s[%d] hook %s (%s)", formatName(h.Stack), h.Kind, h.Index, h.Status, h.Duration.Round(time.Millisecond))
+	default:
+		log.Infof("%s - %s[%d] hook %s", formatName(h.Stack), h.Kind, h.Index, h.Status)
+	}
+	return nil
+}
+
+func outputHookOutput(_ io.Writer, h *clon.HookOutput, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for hook output is not implemented", typ)
+	}
+	log.Infof("%s %s | %s", h.Stack, h.Kind, h.Line)
+	return nil
+}
+
+func outputBucketEmptied(_ io.Writer, b *clon.BucketEmptied, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for bucket is not implemented", typ)
+	}
+	log.Infof("bucket emptied - %s [%s] %d objects deleted", formatName(b.Bucket), b.Stack, b.Deleted)
+	return nil
+}
+
+// Output formats of environment variables.
+const (
+	envFormatDotenv   = "dotenv"
+	envFormatShell    = "shell"
+	envFormatJSON     = "json"
+	envFormatTemplate = "template"
+)
+
+// envOutput is the list of environment variables
+// in one of output formats.
+type envOutput struct {
+	vars     []*clon.EnvVar
+	format   string
+	template string
+}
+
+var dotenvReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+
+func outputEnv(w io.Writer, e *envOutput, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for environment variables is not implemented", typ)
+	}
+	switch e.format {
+	case envFormatDotenv:
+		for _, v := range e.vars {
+			fmt.Fprintf(w, "%s=\"%s\"\n", v.Name, dotenvReplacer.Replace(v.Value))
+		}
+	case envFormatShell:
+		for _, v := range e.vars {
+			fmt.Fprintf(w, "export %s='%s'\n", v.Name, strings.Replace(v.Value, "'", `'\''`, -1))
+		}
+	case envFormatJSON:
+		m := make(map[string]string, len(e.vars))
+		for _, v := range e.vars {
+			m[v.Name] = v.Value
+		}
+		content, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Fprintf(w, "%s\n", content)
+	case envFormatTemplate:
+		m := make(map[string]string, len(e.vars))
+		for _, v := range e.vars {
+			m[v.Name] = v.Value
+		}
+		tpl, err := template.New("outputs").Funcs(sprig.TxtFuncMap()).Parse(e.template)
+		if err != nil {
+			return errors.Annotatef(err, "invalid template")
+		}
+		if err = tpl.Execute(w, m); err != nil {
+			return errors.Annotatef(err, "cannot execute template")
+		}
+	default:
+		return errors.Errorf("unknown format '%s'", e.format)
+	}
+	return nil
+}
+
+func outputDeploymentRecord(_ io.Writer, r *clon.DeploymentRecord, typ int) error {
+	if typ != outputTypeStatusLine {
+		return errors.Errorf("output type %d for deployment record is not implemented", typ)
+	}
+	log.Infof("recorded deployment #%d of stack %s", r.Number, formatName(r.Stack))
+	return nil
+}
+
+func outputDeploymentRecords(w io.Writer, records []*clon.DeploymentRecord, typ int) error {
+	if typ != outputTypeLong {
+		return errors.Errorf("output type %d for deployment records is not implemented", typ)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		formatName("#"), formatName("Time"), formatName("Template"),
+		formatName("Commit"), formatName("Clon"), formatName("By"))
+	for _, r := range records {
+		commit := r.GitCommit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		by := r.DeployedBy
+		if r.RollbackOf > 0 {
+			by += color.YellowString(" (rollback to #%d)", r.RollbackOf)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", r.Number,
+			r.Time.Local().Format("2006-01-02 15:04:05"),
+			emptyDash(r.TemplateVersion), emptyDash(commit), emptyDash(r.ClonVersion), by)
+	}
+	return nil
+}
+
+// emptyDash returns "-" for empty string.
+func emptyDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spirius/clon/pkg/clon"
 
@@ -14,7 +15,6 @@ import (
 	"github.com/fatih/color"
 	"github.com/google/go-github/github"
 	"github.com/juju/errors"
-	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
@@ -47,6 +47,12 @@ var configFlags struct {
 	// Automatically approve changes
 	autoApprove bool
 
+	// Approval provider specification.
+	approval string
+
+	// Timeout of token and http approval providers.
+	approvalTimeout time.Duration
+
 	// Enables debug logging
 	debug bool
 
@@ -60,9 +66,54 @@ var configFlags struct {
 	config         string
 	configOverride string
 
+	// Name of the environment to apply.
+	env string
+
+	// Variables set by --var flags in key=value format.
+	vars []string
+
+	// Variable files set by --var-file flags.
+	varFiles []string
+
 	ignoreNestedUpdates bool
 
 	verifyParentStacks bool
+
+	// Maximum number of files synced concurrently.
+	syncConcurrency int
+
+	// Path of local cache of file hashes.
+	fileCache string
+
+	// Path of local cache of build input hashes.
+	buildCache string
+
+	// Prefix of environment variable names of outputs.
+	outputsPrefix string
+
+	// Name mangling style of environment variable names of outputs.
+	outputsMangle string
+
+	// Include outputs of dependencies.
+	outputsDependencies bool
+
+	// Output format of outputs command.
+	outputsFormat string
+
+	// Go template used by template output format.
+	outputsTemplate string
+
+	// Allow destroying protected stacks.
+	allowProtected bool
+
+	// Destroy all stacks.
+	destroyAll bool
+
+	// Destroy bootstrap stack with all stacks.
+	destroyBootstrap bool
+
+	// Number of deployment to roll back to.
+	rollbackTo int
 }
 
 // use wrapped stdout and stderr, so that
@@ -72,21 +123,71 @@ var stderr = color.Error
 
 var stackHandler *stackCmdHandler
 
-func decodeConfig(config *clon.Config, r io.Reader) error {
-	var err error
-	config.IgnoreNestedUpdates = configFlags.ignoreNestedUpdates
-	m := make(map[string]interface{})
-	if err = yaml.NewDecoder(r).Decode(m); err != nil {
-		return errors.Annotatef(err, "syntax error")
+// loadConfig reads the config file, merges the config override
+// and environment on top of it, validates the result and reads
+// the variable sources.
+func loadConfig() error {
+	c, err := clon.LoadConfig(configFlags.config)
+	if err != nil {
+		return errors.Trace(err)
 	}
-
-	if err = mapstructure.WeakDecode(m, &config); err != nil {
-		return errors.Annotatef(err, "cannot parse config")
+	if configFlags.configOverride != "" {
+		o, err := clon.LoadConfig(configFlags.configOverride)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = c.Merge(o); err != nil {
+			return errors.Annotatef(err, "cannot merge config override")
+		}
 	}
-
+	if configFlags.env != "" {
+		if c, err = c.Environment(configFlags.env); err != nil {
+			return errors.Annotatef(err, "cannot apply environment")
+		}
+	}
+	if err = c.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	c.IgnoreNestedUpdates = configFlags.ignoreNestedUpdates
+	if c.VariableSources, err = variableSources(); err != nil {
+		return errors.Annotatef(err, "cannot read variables")
+	}
+	config = *c
 	return nil
 }
 
+// variableSources reads the variables from variable files and
+// command line. Variable files are applied in order of flags,
+// command line variables override all of them.
+func variableSources() ([]clon.VariableSource, error) {
+	sources := make([]clon.VariableSource, 0, len(configFlags.varFiles)+1)
+	for _, path := range configFlags.varFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot open variable file")
+		}
+		vars := make(map[string]interface{})
+		err = yaml.NewDecoder(f).Decode(&vars)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return nil, errors.Annotatef(err, "cannot parse variable file '%s'", path)
+		}
+		sources = append(sources, clon.VariableSource{Origin: "file:" + path, Variables: vars})
+	}
+	if len(configFlags.vars) > 0 {
+		vars := make(map[string]interface{}, len(configFlags.vars))
+		for _, v := range configFlags.vars {
+			kv := strings.SplitN(v, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, errors.Errorf("invalid variable '%s', expecting key=value", v)
+			}
+			vars[kv[0]] = kv[1]
+		}
+		sources = append(sources, clon.VariableSource{Origin: "cli", Variables: vars})
+	}
+	return sources, nil
+}
+
 var rootCmd = &cobra.Command{
 	Use:                   "clon",
 	Short:                 "clon is a CLoudFormatiON stack management tool",
@@ -118,21 +219,8 @@ var rootCmd = &cobra.Command{
 			}
 		}
 
-		c, err := os.Open(configFlags.config)
-		if err != nil {
-			return errors.Annotatef(err, "cannot open config")
-		}
-		if err = decodeConfig(&config, c); err != nil {
-			return errors.Annotatef(err, "cannot parse config")
-		}
-		if configFlags.configOverride != "" {
-			c, err = os.Open(configFlags.configOverride)
-			if err != nil {
-				errors.Annotatef(err, "cannot open config")
-			}
-			if err = decodeConfig(&config, c); err != nil {
-				return errors.Annotatef(err, "cannot parse config")
-			}
+		if err = loadConfig(); err != nil {
+			return errors.Trace(err)
 		}
 
 		if stackHandler, err = newStackCmdHandler(config); err != nil {
@@ -145,6 +233,21 @@ var rootCmd = &cobra.Command{
 
 func flagAutoApprove(cmd *cobra.Command) {
 	cmd.PersistentFlags().BoolVarP(&configFlags.autoApprove, "auto-approve", "a", false, "Auto-approve changes")
+	cmd.PersistentFlags().StringVarP(&configFlags.approval, "approval", "", "terminal", "Approval provider: terminal, command:<command>, token:<file> or http:<address>")
+	cmd.PersistentFlags().DurationVarP(&configFlags.approvalTimeout, "approval-timeout", "", 30*time.Minute, "Timeout of token and http approval providers")
+}
+
+func flagAllowProtected(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&configFlags.allowProtected, "allow-protected", "", false, "Allow destroying stacks marked as protected")
+}
+
+func flagDestroyAll(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&configFlags.destroyAll, "all", "", false, "Destroy all stacks in reverse dependency order")
+	cmd.PersistentFlags().BoolVarP(&configFlags.destroyBootstrap, "include-bootstrap", "", false, "Destroy bootstrap stack after all stacks, requires --all")
+}
+
+func flagRollbackTo(cmd *cobra.Command) {
+	cmd.PersistentFlags().IntVarP(&configFlags.rollbackTo, "to", "", 0, "Number of deployment from history to roll back to")
 }
 
 func flagIgnoreNestedUpdates(cmd *cobra.Command) {
@@ -166,6 +269,15 @@ func flagVerifyParentStacks(cmd *cobra.Command) {
 		"Check, if parent stacks are up-to-date. Try to deploy if needed.",
 	)
 }
+func flagOutputsEnv(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&configFlags.outputsPrefix, "prefix", "", "", "Prefix of environment variable names")
+	cmd.PersistentFlags().StringVarP(&configFlags.outputsMangle, "mangle", "", clon.MangleNone, "Name mangling of outputs: none, upper or snake (VpcId to VPC_ID)")
+	cmd.PersistentFlags().BoolVarP(&configFlags.outputsDependencies, "dependencies", "", false, "Include outputs of stacks, which the stack depends on")
+}
+func flagOutputsFormat(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&configFlags.outputsFormat, "format", "f", "dotenv", "Output format: dotenv, json, shell or template")
+	cmd.PersistentFlags().StringVarP(&configFlags.outputsTemplate, "template", "", "", "Go template for template format")
+}
 
 func init() {
 	log.SetFormatter(&logFormatter{})
@@ -177,6 +289,12 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&configFlags.input, "input", "i", terminal.IsTerminal(int(os.Stdin.Fd())), "User input availability. If not specified, value is identified from terminal.")
 	rootCmd.PersistentFlags().StringVarP(&configFlags.config, "config", "c", "clon.yml", "Config file")
 	rootCmd.PersistentFlags().StringVarP(&configFlags.configOverride, "config-override", "e", "", "Override config file")
+	rootCmd.PersistentFlags().StringVarP(&configFlags.env, "env", "", "", "Environment name from Environments section of config")
+	rootCmd.PersistentFlags().StringArrayVarP(&configFlags.vars, "var", "", nil, "Set variable in key=value format, can be specified multiple times")
+	rootCmd.PersistentFlags().StringArrayVarP(&configFlags.varFiles, "var-file", "", nil, "Read variables from YAML or JSON file, can be specified multiple times")
+	rootCmd.PersistentFlags().IntVarP(&configFlags.syncConcurrency, "sync-concurrency", "", 8, "Maximum number of files synced concurrently")
+	rootCmd.PersistentFlags().StringVarP(&configFlags.fileCache, "file-cache", "", ".clon/file-cache.json", "Local cache of file hashes, empty value disables the cache file")
+	rootCmd.PersistentFlags().StringVarP(&configFlags.buildCache, "build-cache", "", ".clon/build-cache.json", "Local cache of build input hashes, empty value disables the cache file")
 
 	// list
 	newCmd(rootCmd, &cobra.Command{
@@ -188,6 +306,19 @@ func init() {
 		return stackHandler.list()
 	})
 
+	// graph
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "graph",
+		Short: "Show stack dependencies",
+		Long: `Show stacks and their dependencies.
+
+Dependencies are DependsOn of stacks and 'stack' function calls with
+constant stack names in RoleARN, Parameters and Tags of stacks.`,
+		Args: exactArgs(0),
+	}, func(_ *cobra.Command, _ []string) (interface{}, error) {
+		return stackHandler.graph()
+	})
+
 	// status
 	newCmd(rootCmd, &cobra.Command{
 		Use:   "status stack-name",
@@ -240,15 +371,35 @@ If plan-id is specified, displays previously planned change.
 
 	// destroy
 	newCmd(rootCmd, &cobra.Command{
-		Use:   "destroy stack-name",
+		Use:   "destroy {stack-name | --all}",
 		Short: "Destroy stack",
 		Long: `Destroy AWS CloudFormation stack.
 
+Shows the resources of the stack, which are deleted or retained,
+and the stacks, which depend on it. Protected stacks can be destroyed
+only with --allow-protected flag.
+
+With --all flag all stacks are destroyed in reverse dependency order,
+the bootstrap stack is destroyed last, if --include-bootstrap is set.
+
+If deletion of stack fails, deletion can be retried retaining
+the resources, which failed to delete.
+
 This command requires interactive shell or -a flag to be specified.`,
-		Args: exactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if configFlags.destroyAll {
+				return exactArgs(0)(cmd, args)
+			} else if configFlags.destroyBootstrap {
+				return argsError{errors.Errorf("--include-bootstrap requires --all"), cmd}
+			}
+			return exactArgs(1)(cmd, args)
+		},
 	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		if configFlags.destroyAll {
+			return stackHandler.destroyAll(configFlags.destroyBootstrap)
+		}
 		return stackHandler.destroy(args[0])
-	}, flagAutoApprove)
+	}, flagAutoApprove, flagAllowProtected, flagDestroyAll)
 
 	// deploy
 	newCmd(rootCmd, &cobra.Command{
@@ -262,6 +413,175 @@ This command requires interactive shell or -a flag to be specified.`,
 		return stackHandler.deploy(args[0])
 	}, flagAutoApprove, flagIgnoreNestedUpdates, flagVerifyParentStacks)
 
+	// history
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "history stack-name",
+		Short: "Show deployment history",
+		Long: `Show the deployment history of stack.
+
+Each successful execution of plan is recorded in bootstrap bucket
+with rendered parameters, template version, file versions, clon
+version and git commit of config.`,
+		Args: exactArgs(1),
+	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		return stackHandler.history(args[0])
+	})
+
+	// rollback
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "rollback stack-name --to number",
+		Short: "Roll back stack to previous deployment",
+		Long: `Roll back stack to the deployment from history.
+
+Plans the change set from template version and rendered inputs
+recorded with deployment, and executes it after approval. Config
+and variables are not rendered again.
+
+This command requires interactive shell or -a flag to be specified.`,
+		Args: exactArgs(1),
+	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		return stackHandler.rollback(args[0], configFlags.rollbackTo)
+	}, flagAutoApprove, flagRollbackTo)
+
+	// outputs
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "outputs stack-name",
+		Short: "Show stack outputs",
+		Long: `Show the outputs of stack as environment variables.
+
+Formats are following:
+  dotenv   - NAME="value" lines
+  shell    - export NAME='value' lines, can be evaluated by shell
+  json     - JSON object of names and values
+  template - Go template set by --template, executed with
+             the map of names and values
+`,
+		Args: exactArgs(1),
+	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		return stackHandler.outputs(args[0])
+	}, flagOutputsEnv, flagOutputsFormat)
+
+	// exec
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "exec stack-name -- command [args...]",
+		Short: "Run command with stack outputs",
+		Long: `Run the command with outputs of stack set as environment variables.
+
+Names of variables are composed of --prefix and output names mangled
+by --mangle. With --dependencies, the outputs of stacks, which the stack
+depends on, are set as well, prefixed with the stack name.
+
+The exit code of clon is the exit code of command.`,
+		Args: dashArgs(1),
+	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		return nil, stackHandler.exec(args[0], args[1:])
+	}, flagOutputsEnv)
+
+	// render
+	newCmd(rootCmd, &cobra.Command{
+		Use:   "render",
+		Short: "Render variables",
+		Long: `Render variables and show their values and origins.
+
+Variables are applied in following order, later ones override the earlier:
+  config     - Variables section of config file
+  env:name   - Variables section of environment selected by --env
+  --var-file - variable files in order of flags
+  --var      - command line variables
+`,
+		Args: exactArgs(0),
+	}, func(_ *cobra.Command, _ []string) (interface{}, error) {
+		return stackHandler.render()
+	})
+
+	// shared
+	sharedCmd := &cobra.Command{
+		Use:   "shared",
+		Short: "Shared values",
+		Long: `Read the values published by stacks into shared value store.
+
+Values are published from stack outputs listed in Publish section
+of stack config and stored in bootstrap bucket.`,
+	}
+	rootCmd.AddCommand(sharedCmd)
+
+	// shared list
+	newCmd(sharedCmd, &cobra.Command{
+		Use:   "list",
+		Short: "List shared values",
+		Long:  `List all shared values with their publishing stacks and versions.`,
+		Args:  exactArgs(0),
+	}, func(_ *cobra.Command, _ []string) (interface{}, error) {
+		return stackHandler.sharedList()
+	})
+
+	// shared get
+	newCmd(sharedCmd, &cobra.Command{
+		Use:   "get key",
+		Short: "Get shared value",
+		Long:  `Print the shared value.`,
+		Args:  exactArgs(1),
+	}, func(_ *cobra.Command, args []string) (interface{}, error) {
+		return stackHandler.sharedGet(args[0])
+	})
+
+	// config
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Config management",
+		Long:  `Config management commands.`,
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
+			if configFlags.debug {
+				log.SetLevel(log.DebugLevel)
+			}
+			return errors.Trace(loadConfig())
+		},
+	}
+	rootCmd.AddCommand(configCmd)
+
+	// config show
+	newCmd(configCmd, &cobra.Command{
+		Use:   "show",
+		Short: "Show config",
+		Long:  `Show the config with config override and environment merged.`,
+		Args:  exactArgs(0),
+	}, func(_ *cobra.Command, _ []string) (interface{}, error) {
+		return newOutput(&config), nil
+	})
+
+	// config validate
+	newCmd(configCmd, &cobra.Command{
+		Use:   "validate",
+		Short: "Validate config",
+		Long: `Validate the config file, config override and environment.
+
+Unknown fields, values of wrong types, duplicate stack names and
+missing required fields are reported with their locations.`,
+		Args: exactArgs(0),
+	}, func(_ *cobra.Command, _ []string) (interface{}, error) {
+		log.Infof("config '%s' is valid", configFlags.config)
+		return nil, nil
+	})
+
+	// config schema
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Print JSON Schema of config",
+		Long: `Print JSON Schema of config file.
+
+The schema can be used by editors for completion and validation.`,
+		Args:              exactArgs(0),
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error { return nil },
+		RunE: func(_ *cobra.Command, _ []string) error {
+			schema, err := clon.JSONSchema()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			_, err = stdout.Write(schema)
+			return errors.Trace(err)
+		},
+	})
+
 	// version
 	rootCmd.AddCommand(&cobra.Command{
 		Use:               "version",
@@ -1,6 +1,12 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spirius/clon/pkg/clon"
@@ -24,6 +30,12 @@ func newStackCmdHandler(config clon.Config) (*stackCmdHandler, error) {
 	}
 	sm.SetEventHandler(s.eventHandler)
 	sm.SetVerify(s.verifyStack)
+	sm.SetSyncConcurrency(configFlags.syncConcurrency)
+	sm.SetFileCache(configFlags.fileCache)
+	sm.SetBuildCache(configFlags.buildCache)
+	sm.SetAllowProtected(configFlags.allowProtected)
+	sm.SetRetainResources(s.retainResources)
+	sm.SetVersion(Version)
 	s.sm = sm
 	return s, nil
 }
@@ -74,8 +86,86 @@ func (s *stackCmdHandler) status(name string) (output, error) {
 	return newOutput(stack), nil
 }
 
+func (s *stackCmdHandler) graph() (output, error) {
+	nodes, err := s.sm.Graph()
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot build stack graph")
+	}
+	return newOutput(nodes), nil
+}
+
+func (s *stackCmdHandler) sharedList() (output, error) {
+	values, err := s.sm.ListShared()
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot list shared values")
+	}
+	return newOutput(values), nil
+}
+
+func (s *stackCmdHandler) sharedGet(key string) (output, error) {
+	value, err := s.sm.GetShared(key)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get shared value")
+	}
+	return newOutput(value), nil
+}
+
+func (s *stackCmdHandler) outputsEnv(name string) ([]*clon.EnvVar, error) {
+	outputs, err := s.sm.Outputs(name, configFlags.outputsDependencies)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read outputs")
+	}
+	return clon.OutputsEnv(outputs, configFlags.outputsPrefix, configFlags.outputsMangle)
+}
+
+func (s *stackCmdHandler) outputs(name string) (output, error) {
+	switch configFlags.outputsFormat {
+	case envFormatDotenv, envFormatShell, envFormatJSON:
+	case envFormatTemplate:
+		if configFlags.outputsTemplate == "" {
+			return nil, errors.Errorf("--template is required for template format")
+		}
+	default:
+		return nil, errors.Errorf("unknown format '%s'", configFlags.outputsFormat)
+	}
+	vars, err := s.outputsEnv(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newOutput(&envOutput{
+		vars:     vars,
+		format:   configFlags.outputsFormat,
+		template: configFlags.outputsTemplate,
+	}), nil
+}
+
+func (s *stackCmdHandler) exec(name string, args []string) error {
+	vars, err := s.outputsEnv(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	for _, v := range vars {
+		cmd.Env = append(cmd.Env, v.Name+"="+v.Value)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				return &errorCode{nil, status.ExitStatus()}
+			}
+		}
+		return errors.Annotatef(err, "cannot run '%s'", args[0])
+	}
+	return nil
+}
+
+func (s *stackCmdHandler) render() (output, error) {
+	return newOutput(s.sm.Variables()), nil
+}
+
 func (s *stackCmdHandler) deployStack(name string) (*clon.StackData, bool, error) {
-	log := log.WithFields(log.Fields{"stack": name})
 	plan, err := s.sm.Plan(name)
 	if err != nil {
 		return nil, false, errors.Annotatef(err, "cannot plan stack '%s'", name)
@@ -83,18 +173,54 @@ func (s *stackCmdHandler) deployStack(name string) (*clon.StackData, bool, error
 	stack := plan.Stack
 	if plan.HasChange {
 		newOutput(plan).Output(stderr)
-		if err = askForConfirmation("Do you want to apply these changes on stack?"); err != nil {
-			return nil, false, errors.Annotatef(err, "changes are not approved")
+		if stack, err = s.applyPlan(name, plan, "Do you want to apply these changes on stack?"); err != nil {
+			return nil, false, errors.Trace(err)
 		}
-		log.Infof("changes approved, starting plan execution for stack %s", name)
-		stack, err = s.sm.Execute(name, plan.ID)
+	} else if plan.SSMParameters.HasChange() {
+		newOutput(plan).Output(stderr)
+		err = approve(&clon.ApprovalRequest{
+			Action:  clon.ApprovalExportParameters,
+			Stack:   plan.Stack,
+			Message: "Do you want to update SSM parameters of stack?",
+			Plan:    plan,
+		})
 		if err != nil {
-			return nil, false, errors.Annotatef(err, "execution of stack '%s' failed", name)
+			return nil, false, errors.Annotatef(err, "changes are not approved")
+		}
+		if err = s.sm.ExportParameters(name); err != nil {
+			return nil, false, errors.Annotatef(err, "cannot update SSM parameters of stack '%s'", name)
 		}
 	}
 	return stack, plan.HasChange, nil
 }
 
+// applyPlan checks the policies of plan, asks for approval
+// and executes the plan.
+func (s *stackCmdHandler) applyPlan(name string, plan *clon.Plan, msg string) (*clon.StackData, error) {
+	log := log.WithFields(log.Fields{"stack": name})
+	if err := plan.CheckPolicies(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	err := approve(&clon.ApprovalRequest{
+		Action:  clon.ApprovalExecute,
+		Stack:   plan.Stack,
+		Message: msg,
+		Plan:    plan,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "changes are not approved")
+	}
+	if err = confirmPolicies(plan); err != nil {
+		return nil, errors.Annotatef(err, "changes are not confirmed")
+	}
+	log.Infof("changes approved, starting plan execution for stack %s", name)
+	stack, err := s.sm.Execute(name, plan.ID)
+	if err != nil {
+		return nil, errors.Annotatef(err, "execution of stack '%s' failed", name)
+	}
+	return stack, nil
+}
+
 func (s *stackCmdHandler) deploy(name string) (output, error) {
 	if name != bootstrapStackName {
 		_, err := s.init()
@@ -135,6 +261,43 @@ func (s *stackCmdHandler) init() (output, error) {
 	return newOutput(stack), nil
 }
 
+func (s *stackCmdHandler) history(name string) (output, error) {
+	records, err := s.sm.History(name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read deployment history")
+	}
+	return newOutput(records), nil
+}
+
+// rollback plans the change of stack back to the recorded
+// deployment and executes it after approval.
+func (s *stackCmdHandler) rollback(name string, number int) (output, error) {
+	if number <= 0 {
+		return nil, errors.Errorf("deployment number is required, use --to flag")
+	}
+	if err := s.verifyStackName(name); err != nil {
+		return nil, errors.Annotatef(err, "cannot get stack")
+	}
+	if _, err := s.init(); err != nil {
+		return nil, errors.Annotatef(err, "cannot rollback stack, init failed")
+	}
+	plan, err := s.sm.Rollback(name, number)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot plan rollback of stack '%s'", name)
+	}
+	newOutput(plan).Output(stderr)
+	if !plan.HasChange {
+		log.Infof("stack %s does not differ from deployment #%d", name, number)
+		return newOutput(plan.Stack), nil
+	}
+	msg := fmt.Sprintf("Do you want to roll back stack to deployment #%d?", number)
+	stack, err := s.applyPlan(name, plan, msg)
+	if err != nil {
+		return nil, errors.Annotatef(err, "rollback of stack '%s' failed", name)
+	}
+	return newOutput(stack), nil
+}
+
 func (s *stackCmdHandler) verifyStackName(name string) error {
 	_, err := s.sm.Get(name)
 	return err
@@ -160,7 +323,7 @@ func (s *stackCmdHandler) plan(name string) (output, error) {
 	}
 	newOutput(plan).Output(stderr)
 	code := 0
-	if plan.HasChange {
+	if plan.HasChange || plan.SSMParameters.HasChange() {
 		code = 2
 	}
 
@@ -178,20 +341,33 @@ func (s *stackCmdHandler) planStatus(name, planID string) (output, error) {
 	}
 	newOutput(plan).Output(stderr)
 	code := 0
-	if plan.HasChange {
+	if plan.HasChange || plan.SSMParameters.HasChange() {
 		code = 2
 	}
 	return newOutput(plan).Short(), &errorCode{nil, code}
 }
 
 func (s *stackCmdHandler) destroy(name string) (output, error) {
-	stackStatus, err := s.status(name)
+	plan, err := s.sm.DestroyPlan(name)
 	if err != nil {
-		return nil, errors.Annotatef(err, "cannot get stack '%s'", name)
+		return nil, errors.Annotatef(err, "cannot plan destroy of stack '%s'", name)
+	}
+	newOutput(plan).Output(stderr)
+	if plan.Protected && !configFlags.allowProtected {
+		return nil, errors.Errorf("stack '%s' is protected, use --allow-protected to destroy it", name)
 	}
-	stackStatus.Output(stderr)
 
-	if err = askForConfirmation("Are you sure you want to destroy this stack?"); err != nil {
+	msg := "Are you sure you want to destroy this stack?"
+	if len(plan.Buckets) > 0 {
+		msg = "Are you sure you want to destroy this stack and permanently delete all objects in its buckets?"
+	}
+	err = approve(&clon.ApprovalRequest{
+		Action:  clon.ApprovalDestroy,
+		Stack:   plan.Stack,
+		Message: msg,
+		Destroy: plan,
+	})
+	if err != nil {
 		return nil, errors.Annotatef(err, "changes are not approved")
 	}
 
@@ -203,12 +379,80 @@ func (s *stackCmdHandler) destroy(name string) (output, error) {
 	return newOutput(stack), nil
 }
 
+func (s *stackCmdHandler) destroyAll(includeBootstrap bool) ([]output, error) {
+	names, err := s.sm.DestroyOrder(includeBootstrap)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot resolve destroy order")
+	}
+	if len(names) == 0 {
+		log.Info("there are no stacks to destroy")
+		return nil, nil
+	}
+	var protected, buckets []string
+	for _, name := range names {
+		plan, err := s.sm.DestroyPlan(name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot plan destroy of stack '%s'", name)
+		}
+		newOutput(plan).Output(stderr)
+		fmt.Fprintln(stderr)
+		if plan.Protected {
+			protected = append(protected, name)
+		}
+		for _, b := range plan.Buckets {
+			buckets = append(buckets, b.Bucket)
+		}
+	}
+	if len(protected) > 0 && !configFlags.allowProtected {
+		return nil, errors.Errorf("stacks %s are protected, use --allow-protected to destroy them", strings.Join(protected, ", "))
+	}
+
+	msg := fmt.Sprintf("Are you sure you want to destroy %d stacks in order: %s?", len(names), strings.Join(names, ", "))
+	if len(buckets) > 0 {
+		msg = fmt.Sprintf("All objects in buckets %s will be permanently deleted.\n%s", strings.Join(buckets, ", "), msg)
+	}
+	err = approve(&clon.ApprovalRequest{
+		Action:  clon.ApprovalDestroyAll,
+		Message: msg,
+		Stacks:  names,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "changes are not approved")
+	}
+
+	stacks, err := s.sm.DestroyAll(includeBootstrap)
+	res := make([]output, 0, len(stacks))
+	for _, stack := range stacks {
+		res = append(res, newOutput(stack).Short())
+	}
+	if err != nil {
+		return res, errors.Annotatef(err, "cannot destroy all stacks")
+	}
+	return res, nil
+}
+
+// retainResources asks to retry deletion of stack
+// retaining the resources, which failed to delete. The
+// approval is requested even if auto-approve flag is set.
+func (s *stackCmdHandler) retainResources(stack *clon.StackData, resources []string) error {
+	log.Warnf("deletion of stack '%s' failed, resources %s were not deleted", stack.ConfigName, strings.Join(resources, ", "))
+	return errors.Trace(requestApproval(&clon.ApprovalRequest{
+		Action:    clon.ApprovalRetainResources,
+		Stack:     stack,
+		Message:   "Do you want to retry deletion retaining these resources?",
+		Resources: resources,
+	}))
+}
+
 func (s *stackCmdHandler) execute(name, planID string) (output, error) {
 	plan, err := s.sm.GetPlan(name, planID)
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot get plan '%s' for stack '%s'", planID, name)
 	}
 	newOutput(plan).Output(stderr)
+	if err = confirmPolicies(plan); err != nil {
+		return nil, errors.Annotatef(err, "changes are not confirmed")
+	}
 	stack, err := s.sm.Execute(name, planID)
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot execute plan '%s' on stack '%s'", planID, name)
@@ -2,32 +2,94 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/clon"
 )
 
-func askForConfirmation(msg string) error {
+// approver is the approver created from --approval flag.
+var approver clon.Approver
+
+// newApprover creates the approver from --approval flag. Supported
+// values are terminal, command:<shell command>, token:<file path>
+// and http:<listen address>.
+func newApprover() (clon.Approver, error) {
+	spec := configFlags.approval
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "", "terminal":
+		if !configFlags.input {
+			return nil, errors.Errorf("cannot confirm change, neither auto-approve nor input flags are set")
+		}
+		return &clon.TerminalApprover{In: os.Stdin, Out: stderr, Prompt: func(s string) string {
+			return color.RedString("%s", s)
+		}}, nil
+	case "command":
+		if arg == "" {
+			return nil, errors.Errorf("approval command is empty")
+		}
+		return &clon.CommandApprover{Command: arg, Output: stderr}, nil
+	case "token":
+		if arg == "" {
+			return nil, errors.Errorf("approval token file is empty")
+		}
+		return &clon.TokenFileApprover{Path: arg, Timeout: configFlags.approvalTimeout, Output: stderr}, nil
+	case "http":
+		if arg == "" {
+			arg = "127.0.0.1:0"
+		}
+		return &clon.HTTPApprover{Addr: arg, Timeout: configFlags.approvalTimeout, Output: stderr}, nil
+	}
+	return nil, errors.Errorf("unknown approval provider '%s', allowed values are terminal, command:<command>, token:<file> and http:<address>", spec)
+}
+
+// approve requests the approval of operation,
+// unless auto-approve flag is set.
+func approve(req *clon.ApprovalRequest) error {
 	if configFlags.autoApprove {
 		return nil
 	}
-	if !configFlags.input {
-		return errors.Errorf("cannot confirm change, neither auto-approve nor input flags are set")
-	}
-	for {
-		var res string
-		fmt.Fprintf(stderr, "\n%s", color.RedString("%s [yes/no]: ", msg))
-		_, err := fmt.Scanln(&res)
-		if err != nil {
-			if err.Error() == "unexpected newline" {
-				continue
-			}
-			return errors.Annotatef(err, "cannot read from stdin")
-		}
-		if res == "yes" {
-			return nil
-		} else if res == "no" || res == "n" {
-			return errors.Errorf("not confirmed")
+	return errors.Trace(requestApproval(req))
+}
+
+// requestApproval requests the approval of operation
+// from approver.
+func requestApproval(req *clon.ApprovalRequest) error {
+	if approver == nil {
+		var err error
+		if approver, err = newApprover(); err != nil {
+			return errors.Trace(err)
 		}
 	}
+	return errors.Trace(approver.Approve(req))
+}
+
+// confirmPolicies fails, if the plan contains changes denied by
+// policies, and requests the confirmation of changes matched by
+// confirm policies. The confirmation is requested even if
+// auto-approve flag is set.
+func confirmPolicies(plan *clon.Plan) error {
+	if err := plan.CheckPolicies(); err != nil {
+		return errors.Trace(err)
+	}
+	results := plan.PolicyResults(clon.PolicyConfirm)
+	if len(results) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(results))
+	for _, r := range results {
+		msgs = append(msgs, r.String())
+	}
+	return errors.Trace(requestApproval(&clon.ApprovalRequest{
+		Action:  clon.ApprovalPolicies,
+		Stack:   plan.Stack,
+		Message: fmt.Sprintf("Following changes require confirmation:\n  %s\nDo you confirm these changes?", strings.Join(msgs, "\n  ")),
+		Plan:    plan,
+	}))
 }
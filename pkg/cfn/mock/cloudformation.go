@@ -26,6 +26,12 @@ type MockCloudFormationAPI struct {
 	changeSetsLock sync.Mutex
 	changeSets     map[string]map[string]*cloudformation.DescribeChangeSetOutput
 
+	resourcesLock  sync.Mutex
+	stackResources map[string][]*cloudformation.StackResource
+	templates      map[string]string
+	processed      map[string]string
+	imports        map[string][]string
+
 	// PageSize is the page size for returned data.
 	PageSize int
 
@@ -38,6 +44,9 @@ type MockCloudFormationAPI struct {
 	// MockDescribeChangeSet can be used to mock the call to DescribeChangeSet API.
 	MockDescribeChangeSet func(*cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error)
 
+	// MockExecuteChangeSet can be used to mock the call to ExecuteChangeSet API.
+	MockExecuteChangeSet func(*cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error)
+
 	// MockDeleteStack can be used to mock the call to DeleteStack API.
 	MockDeleteStack func(*cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error)
 }
@@ -48,6 +57,11 @@ func NewMockCloudFormationAPI() *MockCloudFormationAPI {
 		PageSize:   10,
 		stacks:     make(map[string]*cloudformation.Stack),
 		changeSets: make(map[string]map[string]*cloudformation.DescribeChangeSetOutput),
+
+		stackResources: make(map[string][]*cloudformation.StackResource),
+		templates:      make(map[string]string),
+		processed:      make(map[string]string),
+		imports:        make(map[string][]string),
 	}
 }
 
@@ -170,7 +184,9 @@ func (c *MockCloudFormationAPI) getStack(name string) *cloudformation.Stack {
 }
 
 // DeleteStack invokes mocked method if it is not nil,
-// otherwise the mocked implementation is invoked.
+// otherwise the mocked implementation is invoked. If the stack
+// has resources in DELETE_FAILED status, which are not retained,
+// the stack gets DELETE_FAILED status instead of being deleted.
 func (c *MockCloudFormationAPI) DeleteStack(in *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
 	if c.MockDeleteStack != nil {
 		return c.MockDeleteStack(in)
@@ -181,6 +197,12 @@ func (c *MockCloudFormationAPI) DeleteStack(in *cloudformation.DeleteStackInput)
 	if stack == nil {
 		return nil, nil
 	}
+	if c.hasFailedResources(aws.StringValue(stack.StackName), aws.StringValueSlice(in.RetainResources)) {
+		failed := *stack
+		failed.StackStatus = aws.String(cloudformation.StackStatusDeleteFailed)
+		c.stacks[aws.StringValue(stack.StackName)] = &failed
+		return &cloudformation.DeleteStackOutput{}, nil
+	}
 	delete(c.stacks, aws.StringValue(stack.StackName))
 	return &cloudformation.DeleteStackOutput{}, nil
 }
@@ -211,6 +233,9 @@ func (c *MockCloudFormationAPI) DescribeChangeSet(in *cloudformation.DescribeCha
 	c.changeSetsLock.Lock()
 	defer c.changeSetsLock.Unlock()
 
+	if stackName == "" {
+		stackName = c.changeSetStackName(csName)
+	}
 	css, ok := c.changeSets[stackName]
 	if !ok {
 		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack [%s] does not exist", stackName), nil)
@@ -233,3 +258,145 @@ func (c *MockCloudFormationAPI) DescribeChangeSet(in *cloudformation.DescribeCha
 	}
 	return &out, nil
 }
+
+// changeSetStackName returns the name of stack of change set, when
+// change set is identified only by its ARN.
+func (c *MockCloudFormationAPI) changeSetStackName(csName string) string {
+	for stackName, css := range c.changeSets {
+		if _, ok := css[csName]; ok {
+			return stackName
+		}
+	}
+	return ""
+}
+
+// ExecuteChangeSet invokes mocked method if it is not nil,
+// otherwise the status of the stack of change set added by
+// AddChangeSets is set to UPDATE_COMPLETE.
+func (c *MockCloudFormationAPI) ExecuteChangeSet(in *cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error) {
+	if c.MockExecuteChangeSet != nil {
+		return c.MockExecuteChangeSet(in)
+	}
+	csName := normalizeChangeSetName(aws.StringValue(in.ChangeSetName))
+	stackName := normalizeStackName(aws.StringValue(in.StackName))
+	c.changeSetsLock.Lock()
+	if stackName == "" {
+		stackName = c.changeSetStackName(csName)
+	}
+	c.changeSetsLock.Unlock()
+	c.stacksLock.Lock()
+	defer c.stacksLock.Unlock()
+	stack := c.getStack(stackName)
+	if stack == nil {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack [%s] does not exist", stackName), nil)
+	}
+	updated := *stack
+	updated.StackStatus = aws.String(cloudformation.StackStatusUpdateComplete)
+	c.stacks[aws.StringValue(stack.StackName)] = &updated
+	return &cloudformation.ExecuteChangeSetOutput{}, nil
+}
+
+// AddStackResources adds resources and template body of
+// the stack to default mock implementation.
+func (c *MockCloudFormationAPI) AddStackResources(stackName, templateBody string, resources []*cloudformation.StackResource) {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	c.stackResources[stackName] = resources
+	c.templates[stackName] = templateBody
+}
+
+// AddProcessedTemplate sets the processed template body of the
+// stack, which is returned instead of original template for
+// Processed template stage, like for templates with transforms.
+func (c *MockCloudFormationAPI) AddProcessedTemplate(stackName, templateBody string) {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	c.processed[stackName] = templateBody
+}
+
+// hasFailedResources reports whether the stack has resources
+// in DELETE_FAILED status, which are not retained.
+func (c *MockCloudFormationAPI) hasFailedResources(stackName string, retain []string) bool {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	for _, r := range c.stackResources[stackName] {
+		if aws.StringValue(r.ResourceStatus) != cloudformation.ResourceStatusDeleteFailed {
+			continue
+		}
+		retained := false
+		for _, id := range retain {
+			retained = retained || id == aws.StringValue(r.LogicalResourceId)
+		}
+		if !retained {
+			return true
+		}
+	}
+	return false
+}
+
+// AddImports adds the names of stacks, which import the export.
+func (c *MockCloudFormationAPI) AddImports(exportName string, stackNames ...string) {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	c.imports[exportName] = append(c.imports[exportName], stackNames...)
+}
+
+// DescribeStackResources returns the resources of the stack.
+func (c *MockCloudFormationAPI) DescribeStackResources(in *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	stackName := normalizeStackName(aws.StringValue(in.StackName))
+	resources, ok := c.stackResources[stackName]
+	if !ok {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", stackName), nil)
+	}
+	return &cloudformation.DescribeStackResourcesOutput{StackResources: resources}, nil
+}
+
+// GetTemplate returns the template body of the stack.
+func (c *MockCloudFormationAPI) GetTemplate(in *cloudformation.GetTemplateInput) (*cloudformation.GetTemplateOutput, error) {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	stackName := normalizeStackName(aws.StringValue(in.StackName))
+	body, ok := c.templates[stackName]
+	if !ok {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", stackName), nil)
+	}
+	if processed, ok := c.processed[stackName]; ok && aws.StringValue(in.TemplateStage) == cloudformation.TemplateStageProcessed {
+		body = processed
+	}
+	return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(body)}, nil
+}
+
+// ListExportsPages returns the exports from outputs of stacks.
+func (c *MockCloudFormationAPI) ListExportsPages(in *cloudformation.ListExportsInput, fn func(*cloudformation.ListExportsOutput, bool) bool) error {
+	c.stacksLock.Lock()
+	defer c.stacksLock.Unlock()
+	out := &cloudformation.ListExportsOutput{}
+	for _, s := range c.stacks {
+		for _, o := range s.Outputs {
+			if o.ExportName != nil {
+				out.Exports = append(out.Exports, &cloudformation.Export{
+					ExportingStackId: s.StackId,
+					Name:             o.ExportName,
+					Value:            o.OutputValue,
+				})
+			}
+		}
+	}
+	fn(out, true)
+	return nil
+}
+
+// ListImportsPages returns the names of stacks, which import the export.
+func (c *MockCloudFormationAPI) ListImportsPages(in *cloudformation.ListImportsInput, fn func(*cloudformation.ListImportsOutput, bool) bool) error {
+	c.resourcesLock.Lock()
+	defer c.resourcesLock.Unlock()
+	name := aws.StringValue(in.ExportName)
+	imports, ok := c.imports[name]
+	if !ok {
+		return awserr.New("ValidationError", fmt.Sprintf("Export '%s' is not imported by any stack.", name), nil)
+	}
+	fn(&cloudformation.ListImportsOutput{Imports: aws.StringSlice(imports)}, true)
+	return nil
+}
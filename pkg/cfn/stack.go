@@ -135,10 +135,16 @@ func (s *Stack) Data() *StackData {
 	return s.data
 }
 
-// Destroy invokes AWS CloudFormation DeleteStack API.
-func (s *Stack) Destroy() error {
-	_, err := s.cfnconn.DeleteStack(&cloudformation.DeleteStackInput{
+// Destroy invokes AWS CloudFormation DeleteStack API. The
+// retainResources are logical IDs of resources, which are
+// retained. They can be set only for stacks in DELETE_FAILED state.
+func (s *Stack) Destroy(retainResources ...string) error {
+	in := &cloudformation.DeleteStackInput{
 		StackName: aws.String(s.Name),
-	})
+	}
+	if len(retainResources) > 0 {
+		in.RetainResources = aws.StringSlice(retainResources)
+	}
+	_, err := s.cfnconn.DeleteStack(in)
 	return errors.Annotatef(err, "DeleteStack failed for stack '%s'", s.Name)
 }
@@ -0,0 +1,382 @@
+package clon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Actions of approval requests.
+const (
+	ApprovalExecute          = "execute"
+	ApprovalExportParameters = "export-parameters"
+	ApprovalDestroy          = "destroy"
+	ApprovalDestroyAll       = "destroy-all"
+
+	// ApprovalRetainResources is the approval of retaining the
+	// resources, which failed to delete.
+	ApprovalRetainResources = "retain-resources"
+
+	// ApprovalPolicies is the additional confirmation of
+	// changes matched by confirm policies.
+	ApprovalPolicies = "confirm-policies"
+)
+
+// ApprovalRequest is the request to approve the operation on stack.
+type ApprovalRequest struct {
+	// Action is the approved action.
+	Action string
+
+	// Stack is the current data of the stack.
+	Stack *StackData
+
+	// Message is the question shown to the user.
+	Message string
+
+	// Plan is the plan of changes. Nil for destroy.
+	Plan *Plan
+
+	// Destroy is the preview of destruction. Set only for destroy.
+	Destroy *DestroyPlan
+
+	// Stacks is the list of destroyed stacks. Set only for
+	// destroy-all.
+	Stacks []string
+
+	// Resources is the list of logical IDs of retained resources.
+	// Set only for retain-resources.
+	Resources []string
+}
+
+// Approver approves the operations on stacks. Approve returns
+// nil, if the operation is approved.
+type Approver interface {
+	Approve(req *ApprovalRequest) error
+}
+
+// approvalDocument is the JSON representation of approval request.
+type approvalDocument struct {
+	Action    string
+	Stack     string
+	Message   string
+	Hash      string
+	Plan      *Plan        `json:",omitempty"`
+	Destroy   *DestroyPlan `json:",omitempty"`
+	Stacks    []string     `json:",omitempty"`
+	Resources []string     `json:",omitempty"`
+}
+
+// approvalHashData is the data of approval request, which
+// is covered by hash. Unlike the plan ID, it does not change,
+// if the same changes are planned again.
+type approvalHashData struct {
+	Action        string
+	Stack         string
+	StackID       string
+	RoleARN       string            `json:",omitempty"`
+	Parameters    map[string]string `json:",omitempty"`
+	Tags          map[string]string `json:",omitempty"`
+	Capabilities  []string          `json:",omitempty"`
+	TemplateURL   string            `json:",omitempty"`
+	TemplateBody  string            `json:",omitempty"`
+	Changes       interface{}       `json:",omitempty"`
+	SSMParameters map[string]string `json:",omitempty"`
+	Stacks        []string          `json:",omitempty"`
+	Resources     []string          `json:",omitempty"`
+
+	// fields of destroy plan, the bucket contents are not
+	// covered, since they change with each written object
+	Protected        bool                `json:",omitempty"`
+	DestroyResources []*DestroyResource  `json:",omitempty"`
+	Dependents       []*DestroyDependent `json:",omitempty"`
+	Buckets          []string            `json:",omitempty"`
+}
+
+// Hash returns the hash of approval request. The hash covers the
+// action, the stack and the planned changes or destroyed resources,
+// so that the approval token is valid only for the same changes.
+func (r *ApprovalRequest) Hash() string {
+	d := approvalHashData{Action: r.Action, Stacks: r.Stacks, Resources: r.Resources}
+	if r.Stack != nil {
+		d.Stack, d.StackID = r.Stack.Name, r.Stack.ID
+	}
+	if p := r.Plan; p != nil {
+		if cs := p.ChangeSet; cs != nil {
+			if sd := cs.StackData; sd != nil {
+				d.RoleARN, d.Parameters, d.Tags = sd.RoleARN, sd.Parameters, sd.Tags
+				d.Capabilities, d.TemplateURL, d.TemplateBody = sd.Capabilities, sd.TemplateURL, sd.TemplateBody
+			}
+			d.Changes = cs.Changes
+		}
+		if len(p.SSMParameters) > 0 {
+			d.SSMParameters = make(map[string]string, len(p.SSMParameters))
+			for k, v := range p.SSMParameters {
+				d.SSMParameters[k] = v.new
+			}
+		}
+	}
+	if p := r.Destroy; p != nil {
+		d.Protected, d.DestroyResources, d.Dependents = p.Protected, p.Resources, p.Dependents
+		for _, b := range p.Buckets {
+			d.Buckets = append(d.Buckets, b.Bucket)
+		}
+	}
+	content, _ := json.Marshal(d)
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+// MarshalJSON encodes the request with its hash.
+func (r *ApprovalRequest) MarshalJSON() ([]byte, error) {
+	d := approvalDocument{
+		Action:    r.Action,
+		Message:   r.Message,
+		Hash:      r.Hash(),
+		Plan:      r.Plan,
+		Destroy:   r.Destroy,
+		Stacks:    r.Stacks,
+		Resources: r.Resources,
+	}
+	if r.Stack != nil {
+		d.Stack = r.Stack.ConfigName
+	}
+	return json.Marshal(d)
+}
+
+// TerminalApprover asks the user to type 'yes'.
+type TerminalApprover struct {
+	In  io.Reader
+	Out io.Writer
+
+	// Prompt formats the prompt. If nil, the prompt is not formatted.
+	Prompt func(string) string
+
+	reader *bufio.Reader
+}
+
+// Approve implements Approver.
+func (a *TerminalApprover) Approve(req *ApprovalRequest) error {
+	prompt := fmt.Sprintf("%s [yes/no]: ", req.Message)
+	if a.Prompt != nil {
+		prompt = a.Prompt(prompt)
+	}
+	if a.reader == nil {
+		a.reader = bufio.NewReader(a.In)
+	}
+	for {
+		fmt.Fprintf(a.Out, "\n%s", prompt)
+		line, err := a.reader.ReadString('\n')
+		res := strings.TrimSpace(line)
+		if res == "yes" {
+			return nil
+		} else if res == "no" || res == "n" {
+			return errors.Errorf("not confirmed")
+		}
+		if err != nil {
+			return errors.Annotatef(err, "cannot read input")
+		}
+	}
+}
+
+// CommandApprover runs the shell command, which decides on
+// approval by its exit code. The request is passed to the command
+// on stdin as JSON and in CLON_APPROVAL_* environment variables.
+type CommandApprover struct {
+	Command string
+
+	// Output receives the stdout and stderr of command.
+	Output io.Writer
+}
+
+// Approve implements Approver.
+func (a *CommandApprover) Approve(req *ApprovalRequest) error {
+	content, err := json.Marshal(req)
+	if err != nil {
+		return errors.Annotatef(err, "cannot encode approval request")
+	}
+	cmd := shellCommand(a.Command)
+	cmd.Env = append(os.Environ(),
+		"CLON_APPROVAL_ACTION="+req.Action,
+		"CLON_APPROVAL_HASH="+req.Hash(),
+		"CLON_APPROVAL_MESSAGE="+req.Message,
+	)
+	if req.Stack != nil {
+		cmd.Env = append(cmd.Env, "CLON_APPROVAL_STACK="+req.Stack.ConfigName)
+	}
+	if req.Plan != nil {
+		cmd.Env = append(cmd.Env, "CLON_APPROVAL_PLAN_ID="+req.Plan.ID)
+	}
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout, cmd.Stderr = a.Output, a.Output
+	if err = cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return errors.Errorf("not approved by approval command, exit code %d", exitCode(err))
+		}
+		return errors.Annotatef(err, "cannot run approval command")
+	}
+	return nil
+}
+
+// defaultTokenPollInterval is the default interval of
+// checking the approval token file.
+const defaultTokenPollInterval = 2 * time.Second
+
+// TokenFileApprover approves the request, if the file contains
+// the hash of request on separate line. The file is checked until
+// Timeout is reached.
+type TokenFileApprover struct {
+	Path    string
+	Timeout time.Duration
+
+	// PollInterval is the interval of checking the file.
+	// Defaults to 2 seconds.
+	PollInterval time.Duration
+
+	// Output receives the instructions.
+	Output io.Writer
+}
+
+// Approve implements Approver.
+func (a *TokenFileApprover) Approve(req *ApprovalRequest) error {
+	hash := req.Hash()
+	interval := a.PollInterval
+	if interval == 0 {
+		interval = defaultTokenPollInterval
+	}
+	deadline := time.Now().Add(a.Timeout)
+	if a.Output != nil {
+		fmt.Fprintf(a.Output, "%s\nwaiting for approval token %s in '%s'\n", req.Message, hash, a.Path)
+	}
+	for {
+		ok, err := a.hasToken(hash)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			return errors.Errorf("approval token %s not found in '%s'", hash, a.Path)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// hasToken checks if the file contains the token.
+func (a *TokenFileApprover) hasToken(token string) (bool, error) {
+	content, err := ioutil.ReadFile(a.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Annotatef(err, "cannot read approval token file")
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HTTPApprover serves the request on local HTTP server and waits
+// for the callback. GET / returns the request as JSON, POST to
+// /approve with 'hash' form value of request hash approves the
+// request, POST to /reject with the same value rejects it.
+type HTTPApprover struct {
+	// Addr is the listen address, like 127.0.0.1:8080.
+	Addr    string
+	Timeout time.Duration
+
+	// Output receives the instructions.
+	Output io.Writer
+
+	// listening is called with the listen address, once the
+	// server is started.
+	listening func(addr string)
+}
+
+// Approve implements Approver.
+func (a *HTTPApprover) Approve(req *ApprovalRequest) error {
+	l, err := net.Listen("tcp", a.Addr)
+	if err != nil {
+		return errors.Annotatef(err, "cannot start approval server")
+	}
+	hash := req.Hash()
+	result := make(chan error, 1)
+	decide := func(err error) {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	})
+	mux.HandleFunc("/approve", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.FormValue("hash") != hash {
+			http.Error(w, "hash does not match the request", http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "approved")
+		decide(nil)
+	})
+	mux.HandleFunc("/reject", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.FormValue("hash") != hash {
+			http.Error(w, "hash does not match the request", http.StatusConflict)
+			return
+		}
+		fmt.Fprintln(w, "rejected")
+		decide(errors.Errorf("rejected by approval callback"))
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	defer srv.Shutdown(context.Background())
+
+	if a.Output != nil {
+		fmt.Fprintf(a.Output, "%s\nwaiting for approval at http://%s/ (hash %s)\n", req.Message, l.Addr(), hash)
+	}
+	if a.listening != nil {
+		a.listening(l.Addr().String())
+	}
+	var timeout <-chan time.Time
+	if a.Timeout > 0 {
+		timer := time.NewTimer(a.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err = <-result:
+		return err
+	case <-timeout:
+		return errors.Errorf("approval timed out after %s", a.Timeout)
+	}
+}
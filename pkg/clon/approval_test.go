@@ -0,0 +1,181 @@
+package clon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/spirius/clon/pkg/cfn"
+)
+
+func newTestApprovalRequest(value string) *ApprovalRequest {
+	stack := &StackData{StackData: cfn.StackData{ID: "arn:stack/test-app/1", Name: "test-app"}, ConfigName: "app"}
+	return &ApprovalRequest{
+		Action:  ApprovalExecute,
+		Stack:   stack,
+		Message: "Apply?",
+		Plan: &Plan{
+			ID:    "cs-1",
+			Stack: stack,
+			ChangeSet: &cfn.ChangeSetData{
+				ID:        "arn:changeSet/cs-1",
+				StackData: &cfn.StackData{Name: "test-app", Parameters: map[string]string{"Value": value}},
+				Changes: []*cloudformation.ResourceChange{
+					{Action: aws.String("Modify"), LogicalResourceId: aws.String("Bucket")},
+				},
+			},
+			Parameters: DiffStringMap{"Value": {old: "a", new: value}},
+		},
+	}
+}
+
+func TestApprovalRequest(t *testing.T) {
+	require := require.New(t)
+
+	req := newTestApprovalRequest("b")
+	hash := req.Hash()
+	require.Len(hash, 64)
+
+	// hash does not depend on change set ID
+	other := newTestApprovalRequest("b")
+	other.Plan.ID, other.Plan.ChangeSet.ID = "cs-2", "arn:changeSet/cs-2"
+	require.Equal(hash, other.Hash())
+
+	// hash depends on changes and action
+	require.NotEqual(hash, newTestApprovalRequest("c").Hash())
+	other.Action = ApprovalExportParameters
+	require.NotEqual(hash, other.Hash())
+
+	// hash of destroy depends on destroyed resources
+	destroy := &ApprovalRequest{
+		Action: ApprovalDestroy,
+		Stack:  req.Stack,
+		Destroy: &DestroyPlan{
+			Stack: req.Stack,
+			Resources: []*DestroyResource{
+				{LogicalID: "Bucket", ResourceType: "AWS::S3::Bucket", Action: ResourceDelete},
+			},
+		},
+	}
+	destroyHash := destroy.Hash()
+	destroy.Destroy.Resources[0].Action = ResourceRetain
+	require.NotEqual(destroyHash, destroy.Hash())
+	destroy.Destroy.Dependents = []*DestroyDependent{{Stack: "web", Reason: "imports export"}}
+	require.NotEqual(destroyHash, destroy.Hash())
+
+	content, err := json.Marshal(req)
+	require.Nil(err)
+	var doc map[string]interface{}
+	require.Nil(json.Unmarshal(content, &doc))
+	require.Equal("app", doc["Stack"])
+	require.Equal(hash, doc["Hash"])
+	require.Equal(map[string]interface{}{"Old": "a", "New": "b"}, doc["Plan"].(map[string]interface{})["Parameters"].(map[string]interface{})["Value"])
+}
+
+func TestTerminalApprover(t *testing.T) {
+	require := require.New(t)
+
+	var out bytes.Buffer
+	a := &TerminalApprover{In: strings.NewReader("\nmaybe\nyes\nno\n"), Out: &out}
+	require.Nil(a.Approve(newTestApprovalRequest("b")))
+	require.Equal("\nApply? [yes/no]: \nApply? [yes/no]: \nApply? [yes/no]: ", out.String())
+	require.NotNil(a.Approve(newTestApprovalRequest("b")))
+	require.NotNil(a.Approve(newTestApprovalRequest("b")))
+}
+
+func TestCommandApprover(t *testing.T) {
+	require := require.New(t)
+
+	req := newTestApprovalRequest("b")
+	var out bytes.Buffer
+	a := &CommandApprover{
+		Command: `grep -q '"Action":"execute"' && echo "$CLON_APPROVAL_STACK $CLON_APPROVAL_PLAN_ID $CLON_APPROVAL_HASH"`,
+		Output:  &out,
+	}
+	require.Nil(a.Approve(req))
+	require.Equal("app cs-1 "+req.Hash()+"\n", out.String())
+
+	a.Command = "exit 1"
+	err := a.Approve(req)
+	require.NotNil(err)
+	require.Contains(err.Error(), "exit code 1")
+}
+
+func TestTokenFileApprover(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "clon")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "approved")
+
+	req := newTestApprovalRequest("b")
+	a := &TokenFileApprover{Path: path}
+	err = a.Approve(req)
+	require.NotNil(err)
+	require.Contains(err.Error(), "approval token "+req.Hash()+" not found")
+
+	// token of other plan does not approve
+	require.Nil(ioutil.WriteFile(path, []byte(newTestApprovalRequest("c").Hash()+"\n"), 0644))
+	require.NotNil(a.Approve(req))
+
+	// token written while waiting approves
+	a.Timeout, a.PollInterval = 10*time.Second, 10*time.Millisecond
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(path, []byte("other\n  "+req.Hash()+"\n"), 0644)
+	}()
+	require.Nil(a.Approve(req))
+}
+
+func TestHTTPApprover(t *testing.T) {
+	require := require.New(t)
+
+	req := newTestApprovalRequest("b")
+	respond := func(path string, values url.Values) func(addr string) {
+		return func(addr string) {
+			go func() {
+				resp, err := http.Get("http://" + addr + "/")
+				require.Nil(err)
+				var doc map[string]interface{}
+				require.Nil(json.NewDecoder(resp.Body).Decode(&doc))
+				resp.Body.Close()
+				require.Equal(req.Hash(), doc["Hash"])
+
+				resp, err = http.PostForm("http://"+addr+path, url.Values{"hash": {"wrong"}})
+				require.Nil(err)
+				resp.Body.Close()
+				require.Equal(http.StatusConflict, resp.StatusCode)
+
+				resp, err = http.PostForm("http://"+addr+path, values)
+				require.Nil(err)
+				resp.Body.Close()
+			}()
+		}
+	}
+
+	a := &HTTPApprover{Addr: "127.0.0.1:0", Timeout: 10 * time.Second}
+	a.listening = respond("/approve", url.Values{"hash": {req.Hash()}})
+	require.Nil(a.Approve(req))
+
+	a.listening = respond("/reject", url.Values{"hash": {req.Hash()}})
+	err := a.Approve(req)
+	require.NotNil(err)
+	require.Contains(err.Error(), "rejected")
+
+	a.Timeout, a.listening = 10*time.Millisecond, nil
+	err = a.Approve(req)
+	require.NotNil(err)
+	require.Contains(err.Error(), "timed out")
+}
@@ -15,6 +15,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
@@ -23,20 +27,29 @@ type awsClient struct {
 
 	s3conn  s3iface.S3API
 	cfnconn cloudformationiface.CloudFormationAPI
+	ssmconn ssmiface.SSMAPI
+	smconn  secretsmanageriface.SecretsManagerAPI
 
 	accountID   string
 	region      string
 	sessionName string
 }
 
-func newAWSClient() (a *awsClient, err error) {
+func newAWSClient(region string) (a *awsClient, err error) {
 	a = &awsClient{}
 
-	if a.sess, err = session.NewSession(); err != nil {
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+
+	if a.sess, err = session.NewSession(config); err != nil {
 		return nil, errors.Annotatef(err, "cannot create awsClient")
 	}
 
 	a.s3conn = s3.New(a.sess)
+	a.ssmconn = ssm.New(a.sess)
+	a.smconn = secretsmanager.New(a.sess)
 
 	cfnconn := cloudformation.New(a.sess)
 	cfnconn.Handlers.Retry.PushBack(func(r *request.Request) {
@@ -0,0 +1,124 @@
+package clon
+
+import (
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Values of EmptyBucketsOnDestroy.
+const (
+	// EmptyBucketsBefore empties the buckets before
+	// the stack is deleted.
+	EmptyBucketsBefore = "before"
+
+	// EmptyBucketsOnFailure empties the buckets after the first
+	// attempt to delete the stack fails, and retries the deletion.
+	EmptyBucketsOnFailure = "on-failure"
+)
+
+const awsS3Bucket = "AWS::S3::Bucket"
+
+// BucketContents is the S3 bucket of stack, which is
+// emptied when the stack is destroyed.
+type BucketContents struct {
+	LogicalID string
+	Bucket    string
+
+	// Versions is the number of object versions.
+	Versions int
+
+	// DeleteMarkers is the number of delete markers.
+	DeleteMarkers int
+}
+
+// BucketEmptied is the event emitted, when the
+// bucket of destroyed stack is emptied.
+type BucketEmptied struct {
+	// Stack is the config name of the stack.
+	Stack string
+
+	// Bucket is the name of emptied bucket.
+	Bucket string
+
+	// Deleted is the number of deleted object versions
+	// and delete markers.
+	Deleted int
+}
+
+// isDeletedBucket reports whether the resource is a bucket,
+// which is deleted with the stack.
+func isDeletedBucket(r *DestroyResource) bool {
+	return r.ResourceType == awsS3Bucket && r.Action == ResourceDelete && r.PhysicalID != ""
+}
+
+// listBucketObjects returns all object versions and delete markers
+// of bucket. Not existing bucket is considered empty.
+func (sm *StackManager) listBucketObjects(bucket string) (versions, markers []*s3.ObjectIdentifier, err error) {
+	err = sm.awsClient.s3conn.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	}, func(out *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, v := range out.Versions {
+			versions = append(versions, &s3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range out.DeleteMarkers {
+			markers = append(markers, &s3.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+		return true
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == s3.ErrCodeNoSuchBucket {
+			return nil, nil, nil
+		}
+		return nil, nil, errors.Annotatef(err, "cannot list objects of bucket '%s'", bucket)
+	}
+	return versions, markers, nil
+}
+
+// bucketContents returns the contents of buckets, which are deleted
+// with the stack. Retained buckets are not included.
+func (sm *StackManager) bucketContents(resources []*DestroyResource) ([]*BucketContents, error) {
+	var res []*BucketContents
+	for _, r := range resources {
+		if !isDeletedBucket(r) {
+			continue
+		}
+		versions, markers, err := sm.listBucketObjects(r.PhysicalID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res = append(res, &BucketContents{
+			LogicalID:     r.LogicalID,
+			Bucket:        r.PhysicalID,
+			Versions:      len(versions),
+			DeleteMarkers: len(markers),
+		})
+	}
+	return res, nil
+}
+
+// emptyBuckets permanently deletes all object versions and delete
+// markers in buckets of stack, which are deleted with the stack.
+func (sm *StackManager) emptyBuckets(s *stack) error {
+	resources, err := sm.destroyResources(s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, r := range resources {
+		if !isDeletedBucket(r) {
+			continue
+		}
+		versions, markers, err := sm.listBucketObjects(r.PhysicalID)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		objects := append(versions, markers...)
+		if err = sm.deleteObjects(r.PhysicalID, objects); err != nil {
+			return errors.Annotatef(err, "cannot empty bucket '%s'", r.PhysicalID)
+		}
+		sm.emit(&BucketEmptied{Stack: s.configName, Bucket: r.PhysicalID, Deleted: len(objects)})
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package clon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spirius/clon/pkg/clon/mock"
+)
+
+const testBucketsTemplate = `
+Resources:
+  Assets:
+    Type: AWS::S3::Bucket
+  Backups:
+    Type: AWS::S3::Bucket
+    DeletionPolicy: Retain
+`
+
+func newTestBucketResources(status string) []*cloudformation.StackResource {
+	return []*cloudformation.StackResource{
+		{LogicalResourceId: aws.String("Assets"), PhysicalResourceId: aws.String("assets"),
+			ResourceType: aws.String(awsS3Bucket), ResourceStatus: aws.String(status)},
+		{LogicalResourceId: aws.String("Backups"), PhysicalResourceId: aws.String("backups"),
+			ResourceType: aws.String(awsS3Bucket), ResourceStatus: aws.String(cloudformation.ResourceStatusCreateComplete)},
+	}
+}
+
+func TestStackManager_emptyBucketsBefore(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "data", EmptyBucketsOnDestroy: EmptyBucketsBefore})
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("data", nil)})
+	cfnconn.AddStackResources("test-data", testBucketsTemplate,
+		newTestBucketResources(cloudformation.ResourceStatusCreateComplete))
+
+	objects := map[string]string{"backups/db.dump": "dump"}
+	for i := 0; i < 1001; i++ {
+		objects[fmt.Sprintf("assets/file-%d", i)] = "v1"
+	}
+	s3conn.AddObjects(objects)
+	s3conn.AddObjects(map[string]string{"assets/file-0": "v2"})
+	_, err := s3conn.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String("assets"),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("file-1")}}},
+	})
+	require.Nil(err)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var events []*BucketEmptied
+	sm.SetEventHandler(func(e interface{}) {
+		if b, ok := e.(*BucketEmptied); ok {
+			events = append(events, b)
+		}
+	})
+
+	plan, err := sm.DestroyPlan("data")
+	require.Nil(err)
+	require.Equal([]*BucketContents{{LogicalID: "Assets", Bucket: "assets", Versions: 1002, DeleteMarkers: 1}}, plan.Buckets)
+
+	_, err = sm.Destroy("data")
+	require.Nil(err)
+	require.Equal([]*BucketEmptied{{Stack: "data", Bucket: "assets", Deleted: 1003}}, events)
+	require.Empty(s3conn.Versions("assets", "file-0"))
+	require.Empty(s3conn.Versions("assets", "file-1"))
+	require.Equal("dump", string(s3conn.Object("backups", "db.dump").Body))
+}
+
+func TestStackManager_emptyBucketsOnFailure(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "data", EmptyBucketsOnDestroy: EmptyBucketsOnFailure})
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("data", nil)})
+	cfnconn.AddStackResources("test-data", testBucketsTemplate,
+		newTestBucketResources(cloudformation.ResourceStatusDeleteFailed))
+	s3conn.AddObjects(map[string]string{"assets/index.html": "index"})
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var events []*BucketEmptied
+	sm.SetEventHandler(func(e interface{}) {
+		if b, ok := e.(*BucketEmptied); ok {
+			events = append(events, b)
+			// deletion of emptied bucket succeeds
+			cfnconn.AddStackResources("test-data", testBucketsTemplate,
+				newTestBucketResources(cloudformation.ResourceStatusDeleteInProgress))
+		}
+	})
+
+	stack, err := sm.Destroy("data")
+	require.Nil(err)
+	require.False(stack.Exists())
+	require.Equal([]*BucketEmptied{{Stack: "data", Bucket: "assets", Deleted: 1}}, events)
+	require.Empty(s3conn.Versions("assets", "index.html"))
+}
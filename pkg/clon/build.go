@@ -0,0 +1,312 @@
+package clon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Build statuses reported by BuildStatus event.
+const (
+	BuildStarted   = "started"
+	BuildSkipped   = "skipped"
+	BuildSucceeded = "succeeded"
+	BuildFailed    = "failed"
+)
+
+// BuildStatus is the event emitted, when build command is
+// started, skipped or finished.
+type BuildStatus struct {
+	// Name is the name of build, 'Builds.name' for global
+	// builds and 'Files.name' for file builds.
+	Name string
+
+	// Status is the status of build.
+	Status string
+
+	// ExitCode is the exit code of finished command.
+	ExitCode int
+
+	// Duration is the duration of finished command.
+	Duration time.Duration
+}
+
+// BuildOutput is the event emitted for each line of
+// output of build command.
+type BuildOutput struct {
+	// Name is the name of build.
+	Name string
+
+	// Line is the line of combined stdout and stderr of command.
+	Line string
+}
+
+// buildCache is the local cache of input hashes of
+// successful builds keyed by build name.
+type buildCache struct {
+	path    string
+	lock    sync.Mutex
+	hashes  map[string]string
+	changed bool
+}
+
+// loadBuildCache loads the cache from path. Missing or invalid
+// cache file results in empty cache. If path is empty, cache is
+// kept only in memory.
+func loadBuildCache(path string) *buildCache {
+	c := &buildCache{path: path, hashes: make(map[string]string)}
+	if path != "" && !readJSONCache(path, "build", &c.hashes) {
+		c.hashes = make(map[string]string)
+	}
+	return c
+}
+
+// set records the input hash of build. Empty hash
+// removes the build from cache.
+func (c *buildCache) set(name, hash string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.hashes[name] == hash {
+		return
+	}
+	if hash == "" {
+		delete(c.hashes, name)
+	} else {
+		c.hashes[name] = hash
+	}
+	c.changed = true
+}
+
+// get returns the recorded input hash of build.
+func (c *buildCache) get(name string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.hashes[name]
+}
+
+// save writes the cache into file, if it was changed.
+func (c *buildCache) save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.path == "" || !c.changed {
+		return nil
+	}
+	if err := writeJSONCache(c.path, "build", c.hashes); err != nil {
+		return errors.Trace(err)
+	}
+	c.changed = false
+	return nil
+}
+
+// build is the rendered build command.
+type build struct {
+	name    string
+	command string
+	dir     string
+	env     []string
+	inputs  []string
+	exclude []string
+	outputs []string
+}
+
+// SetBuildCache sets the path of local cache of build input
+// hashes. If path is empty, cache is kept only in memory.
+func (sm *StackManager) SetBuildCache(path string) {
+	sm.buildCachePath = path
+	sm.buildCache = nil
+}
+
+// Build runs the global build commands in order, followed by
+// build commands of files sorted by file name. Builds with
+// inputs are skipped, if nothing is changed since last
+// successful build.
+func (sm *StackManager) Build() error {
+	if sm.buildCache == nil {
+		sm.buildCache = loadBuildCache(sm.buildCachePath)
+	}
+	var builds []*build
+	for _, b := range sm.config.Builds {
+		res, err := sm.renderBuild("Builds."+b.Name, b, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		builds = append(builds, res)
+	}
+	names := make([]string, 0, len(sm.fileConfigs))
+	for k, f := range sm.fileConfigs {
+		if f.Build != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		f := sm.fileConfigs[k]
+		b := *f.Build
+		if b.pos.File == "" {
+			b.pos = f.pos
+		}
+		var outputs []string
+		if len(b.Outputs) == 0 && f.Src != "" {
+			src, err := sm.renderAt(nil, f.pos, f.Src)
+			if err != nil {
+				return errors.Annotatef(err, "cannot render Src of file '%s'", k)
+			}
+			src = f.pos.resolvePath(src)
+			if hasGlobMeta(src) {
+				src = globBase(src)
+			}
+			outputs = []string{src}
+		}
+		res, err := sm.renderBuild("Files."+k, b, outputs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		builds = append(builds, res)
+	}
+	for _, b := range builds {
+		err := sm.runBuild(b)
+		if saveErr := sm.buildCache.save(); saveErr != nil {
+			log.Warnf("%s", saveErr)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// renderBuild renders the build config. If outputs are
+// set, they are used instead of Outputs of config.
+func (sm *StackManager) renderBuild(name string, b BuildConfig, outputs []string) (*build, error) {
+	res := &build{name: name, inputs: b.Inputs, exclude: b.Exclude}
+	var err error
+	if res.command, err = sm.renderAt(nil, b.pos, b.Command); err != nil {
+		return nil, errors.Annotatef(err, "cannot render Command of build '%s'", name)
+	}
+	dir := b.Dir
+	if dir != "" {
+		if dir, err = sm.renderAt(nil, b.pos, dir); err != nil {
+			return nil, errors.Annotatef(err, "cannot render Dir of build '%s'", name)
+		}
+	}
+	if res.dir = b.pos.resolvePath(dir); res.dir == "" {
+		res.dir = b.pos.dir()
+	}
+	keys := make([]string, 0, len(b.Env))
+	for k := range b.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := sm.renderAt(nil, b.pos, b.Env[k])
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot render Env.%s of build '%s'", k, name)
+		}
+		res.env = append(res.env, k+"="+v)
+	}
+	if outputs == nil {
+		for _, o := range b.Outputs {
+			outputs = append(outputs, resolveIn(res.dir, o))
+		}
+	}
+	res.outputs = outputs
+	return res, nil
+}
+
+// resolveIn resolves the relative path against dir.
+func resolveIn(dir, path string) string {
+	if filepath.IsAbs(path) || dir == "" {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// inputsHash returns the combined hash of command, environment and
+// contents of inputs of build. If build has no inputs, empty
+// string is returned.
+func (b *build) inputsHash() (string, error) {
+	if len(b.inputs) == 0 {
+		return "", nil
+	}
+	dir := b.dir
+	if dir == "" {
+		dir = "."
+	}
+	files, err := listFileSet(dir, b.inputs, b.exclude)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot list inputs")
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", b.command)
+	for _, e := range b.env {
+		fmt.Fprintf(h, "%s\x00", e)
+	}
+	for _, name := range names {
+		fh := sha256.New()
+		f, err := os.Open(files[name])
+		if err != nil {
+			return "", errors.Annotatef(err, "cannot read input '%s'", files[name])
+		}
+		_, err = io.Copy(fh, f)
+		f.Close()
+		if err != nil {
+			return "", errors.Annotatef(err, "cannot read input '%s'", files[name])
+		}
+		fmt.Fprintf(h, "\n%s\x00%x", name, fh.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// outputsExist checks if all outputs of build exist.
+func (b *build) outputsExist() bool {
+	for _, o := range b.outputs {
+		if _, err := os.Stat(o); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// runBuild runs the build command, unless inputs of
+// build are not changed.
+func (sm *StackManager) runBuild(b *build) error {
+	hash, err := b.inputsHash()
+	if err != nil {
+		return errors.Annotatef(err, "cannot build '%s'", b.name)
+	}
+	if hash != "" && hash == sm.buildCache.get(b.name) && b.outputsExist() {
+		sm.emit(&BuildStatus{Name: b.name, Status: BuildSkipped})
+		return nil
+	}
+	sm.buildCache.set(b.name, "")
+
+	sm.emit(&BuildStatus{Name: b.name, Status: BuildStarted})
+	exitCode, duration, err := runCommand(b.command, b.dir, b.env, func(line string) {
+		sm.emit(&BuildOutput{Name: b.name, Line: line})
+	})
+	status := &BuildStatus{Name: b.name, Status: BuildSucceeded, ExitCode: exitCode, Duration: duration}
+	if err != nil {
+		status.Status = BuildFailed
+		sm.emit(status)
+		return errors.Annotatef(err, "build '%s' failed", b.name)
+	}
+	sm.emit(status)
+	if hash != "" {
+		sm.buildCache.set(b.name, hash)
+	}
+	return nil
+}
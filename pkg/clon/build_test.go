@@ -0,0 +1,101 @@
+package clon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestStackManager_build(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"src/main.txt": "main",
+		"src/lib.txt":  "lib",
+	})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.Builds = []BuildConfig{
+		{Name: "prepare", Command: "mkdir -p dist && echo prepared", Dir: dir},
+	}
+	config.Files = map[string]FileConfig{
+		"app": {
+			Src: filepath.Join(dir, "dist", "app.txt"),
+			Build: &BuildConfig{
+				Command: "cat src/*.txt > dist/app.txt && echo $SUFFIX >> dist/app.txt && echo built",
+				Dir:     dir,
+				Env:     map[string]string{"SUFFIX": "{{ .Var.suffix }}"},
+				Inputs:  []string{"src/**"},
+			},
+		},
+	}
+	config.Variables = map[string]interface{}{"suffix": "end"}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	cachePath := filepath.Join(dir, ".clon", "build-cache.json")
+
+	var events []interface{}
+	newSM := func() *StackManager {
+		sm, err := newStackManager(config, awsClient)
+		require.Nil(err)
+		sm.SetBucket("bucket")
+		sm.SetBuildCache(cachePath)
+		sm.SetEventHandler(func(e interface{}) {
+			if s, ok := e.(*BuildStatus); ok {
+				s.Duration = 0
+			}
+			events = append(events, e)
+		})
+		return sm
+	}
+
+	sm := newSM()
+	require.Nil(sm.SyncFiles())
+	require.Equal([]interface{}{
+		&BuildStatus{Name: "Builds.prepare", Status: BuildStarted},
+		&BuildOutput{Name: "Builds.prepare", Line: "prepared"},
+		&BuildStatus{Name: "Builds.prepare", Status: BuildSucceeded},
+		&BuildStatus{Name: "Files.app", Status: BuildStarted},
+		&BuildOutput{Name: "Files.app", Line: "built"},
+		&BuildStatus{Name: "Files.app", Status: BuildSucceeded},
+	}, events)
+	require.Equal("libmainend\n", string(s3conn.Object("bucket", "app.txt").Body))
+
+	// unchanged inputs skip the build, builds without inputs always run
+	events = nil
+	sm = newSM()
+	require.Nil(sm.Build())
+	require.Equal(&BuildStatus{Name: "Files.app", Status: BuildSkipped}, events[len(events)-1])
+
+	// changed input triggers the build
+	require.Nil(ioutil.WriteFile(filepath.Join(dir, "src", "lib.txt"), []byte("LIB"), 0644))
+	events = nil
+	require.Nil(sm.Build())
+	require.Equal(&BuildStatus{Name: "Files.app", Status: BuildSucceeded}, events[len(events)-1])
+
+	// missing output triggers the build
+	require.Nil(os.Remove(filepath.Join(dir, "dist", "app.txt")))
+	events = nil
+	require.Nil(sm.Build())
+	require.Equal(&BuildStatus{Name: "Files.app", Status: BuildSucceeded}, events[len(events)-1])
+
+	// failed build blocks the sync
+	config.Builds[0].Command = "echo failing; exit 3"
+	events = nil
+	sm = newSM()
+	err := sm.SyncFiles()
+	require.NotNil(err)
+	require.Contains(err.Error(), "build 'Builds.prepare' failed")
+	require.Equal([]interface{}{
+		&BuildStatus{Name: "Builds.prepare", Status: BuildStarted},
+		&BuildOutput{Name: "Builds.prepare", Line: "failing"},
+		&BuildStatus{Name: "Builds.prepare", Status: BuildFailed, ExitCode: 3},
+	}, events)
+}
@@ -0,0 +1,78 @@
+package clon
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// shellCommand returns the command running command line in the
+// shell of the platform, cmd /C on windows and sh -c elsewhere.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// exitCode returns the exit code of finished command from its
+// error. The exit code is -1, if command cannot be started or is
+// killed by signal.
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// runCommand runs the shell command in dir with additional
+// environment variables. Combined stdout and stderr of command
+// is passed to output line by line. The exit code is -1, if
+// command cannot be started or is killed by signal.
+func runCommand(command, dir string, env []string, output func(line string)) (int, time.Duration, error) {
+	cmd := shellCommand(command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out := &lineWriter{emit: output}
+	cmd.Stdout, cmd.Stderr = out, out
+	start := time.Now()
+	err := cmd.Run()
+	out.flush()
+	duration := time.Since(start)
+	if err != nil {
+		return exitCode(err), duration, err
+	}
+	return 0, duration, nil
+}
+
+// lineWriter splits the written output into lines.
+type lineWriter struct {
+	emit func(line string)
+	buf  bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf.Next(i + 1)
+		w.emit(string(bytes.TrimRight(line, "\r\n")))
+	}
+	return len(p), nil
+}
+
+// flush emits the last incomplete line.
+func (w *lineWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.String())
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,49 @@
+package clon
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineWriter(t *testing.T) {
+	require := require.New(t)
+
+	var lines []string
+	w := &lineWriter{emit: func(line string) { lines = append(lines, line) }}
+	w.Write([]byte("a\r\nb"))
+	w.Write([]byte("c\n\nd"))
+	w.flush()
+	require.Equal([]string{"a", "bc", "", "d"}, lines)
+}
+
+func TestShellCommand(t *testing.T) {
+	require := require.New(t)
+
+	cmd := shellCommand("echo a")
+	if runtime.GOOS == "windows" {
+		require.Equal([]string{"cmd", "/C", "echo a"}, cmd.Args)
+	} else {
+		require.Equal([]string{"sh", "-c", "echo a"}, cmd.Args)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	require := require.New(t)
+
+	var lines []string
+	output := func(line string) { lines = append(lines, line) }
+	code, _, err := runCommand("echo $A; echo err >&2; pwd", "/", []string{"A=a"}, output)
+	require.Nil(err)
+	require.Equal(0, code)
+	require.Equal([]string{"a", "err", "/"}, lines)
+
+	code, _, err = runCommand("exit 4", "", nil, output)
+	require.NotNil(err)
+	require.Equal(4, code)
+
+	code, _, err = runCommand("true", "/nonexistent", nil, output)
+	require.NotNil(err)
+	require.Equal(-1, code)
+}
@@ -5,6 +5,12 @@ type Config struct {
 	// Name of the deployment
 	Name string
 
+	// Include is the list of config files or glob patterns to
+	// include. Relative paths are resolved against the directory of
+	// including file. Included configs are merged into the config,
+	// stack and file names must be unique across all files.
+	Include []string
+
 	// AccountID is the target AWS account ID.
 	// If set, StackManager will verify, that
 	// current AWS credentials are from that account.
@@ -22,11 +28,49 @@ type Config struct {
 	// Files is the map of files to sync with S3 bucket.
 	Files map[string]FileConfig
 
-	// Variables is the map of variables.
-	Variables map[string]string
+	// Builds is the list of build commands, which are run in
+	// order before files are synced.
+	Builds []BuildConfig
+
+	// Policies is the list of rules checked against resource
+	// changes of plans of all stacks.
+	Policies []PolicyConfig
+
+	// KMSKeyID is the KMS key used for server-side encryption of
+	// objects written into bootstrap bucket: stack templates, shared
+	// values and files. Rendered as template.
+	KMSKeyID string
+
+	// Variables is the map of variables. Values can be
+	// strings, numbers, booleans, lists or maps. String values
+	// are rendered as templates and can refer to other variables.
+	Variables map[string]interface{}
+
+	// VariableSources are additional sources of variables, like
+	// variable files or command line. Sources are applied on top of
+	// Variables in order, later sources override the earlier ones.
+	VariableSources []VariableSource `mapstructure:"-"`
+
+	// Environments is the map of named environments.
+	// Environment config is deep-merged onto the base config.
+	Environments map[string]Config
 
 	IgnoreNestedUpdates bool
 	RootStack           string
+
+	// variableOrigins is the origin of variables, which are not
+	// defined in base config, set by Environment.
+	variableOrigins map[string]string
+
+	// variablePos is the location of variables in config files.
+	variablePos map[string]position
+
+	// explicit is the set of paths of values, which are set in
+	// config file. Explicit values are merged even if they are zero.
+	explicit map[string]bool
+
+	// pos is the location of config in config file.
+	pos position
 }
 
 // StackConfig is the configuration of single stack.
@@ -35,8 +79,55 @@ type StackConfig struct {
 	Template     string
 	RoleARN      string
 	Parameters   map[string]string
-	Capabilities []string
+	Capabilities []string `jsonschema:"enum=CAPABILITY_IAM|CAPABILITY_NAMED_IAM|CAPABILITY_AUTO_EXPAND"`
 	Tags         map[string]string
+
+	// DependsOn is the list of stacks, which must be deployed
+	// before this stack. Values are rendered as templates.
+	DependsOn []string
+
+	// Publish is the map of shared value keys to names of stack
+	// outputs. Outputs are published into shared value store in
+	// bootstrap bucket after successful execution.
+	Publish map[string]string
+
+	// ExportToSSM is the map of SSM parameter names to names of
+	// stack outputs. Parameter names are rendered as templates.
+	// Parameters are written after successful execution and
+	// deleted after the stack is destroyed.
+	ExportToSSM map[string]string
+
+	// Hooks are the local commands run around operations
+	// on the stack.
+	Hooks HooksConfig
+
+	// Policies is the list of rules checked against resource
+	// changes of plans of the stack, in addition to global policies.
+	Policies []PolicyConfig
+
+	// Protected stacks cannot be destroyed without
+	// explicit override.
+	Protected bool
+
+	// EmptyBucketsOnDestroy enables deletion of all objects in
+	// S3 buckets of the stack, which are deleted with the stack,
+	// either before the deletion or after the first failed attempt.
+	EmptyBucketsOnDestroy string `jsonschema:"enum=before|on-failure"`
+
+	// ForEach expands the stack into one stack per element. Map
+	// elements are exposed in templates as .Each.key, scalar
+	// elements as .Each.Value.
+	ForEach []interface{}
+
+	// Matrix expands the stack into one stack per combination
+	// of values. Values are exposed in templates as .Each.key.
+	Matrix map[string][]interface{}
+
+	// pos is the location of stack config in config file.
+	pos position
+
+	// each is the loop values of expanded stack.
+	each map[string]interface{}
 }
 
 // FileConfig is the configuration of single file.
@@ -44,4 +135,168 @@ type FileConfig struct {
 	Src    string
 	Bucket string
 	Key    string
+
+	// Prefix is the common key prefix of files synced from
+	// directory or glob pattern in Src.
+	Prefix string
+
+	// Include is the list of patterns of relative paths of files
+	// synced from directory or glob. All files are included if empty.
+	Include []string
+
+	// Exclude is the list of patterns of relative paths of files,
+	// which are excluded from sync.
+	Exclude []string
+
+	// Delete enables deletion of remote objects under Prefix,
+	// which are not present locally.
+	Delete bool
+
+	// Render enables rendering of file contents as templates
+	// before upload.
+	Render bool
+
+	// ContentType is the content type of uploaded objects. If not
+	// set, it is detected from file extension or content.
+	ContentType string
+
+	// CacheControl is the Cache-Control header of uploaded objects.
+	CacheControl string
+
+	// ContentEncoding is the Content-Encoding header of uploaded objects.
+	ContentEncoding string
+
+	// ACL is the canned ACL of uploaded objects.
+	ACL string `jsonschema:"enum=private|public-read|public-read-write|authenticated-read|aws-exec-read|bucket-owner-read|bucket-owner-full-control"`
+
+	// ServerSideEncryption is the server-side encryption algorithm
+	// of uploaded objects. Defaults to aws:kms if KMSKeyID is set.
+	ServerSideEncryption string `jsonschema:"enum=AES256|aws:kms"`
+
+	// KMSKeyID is the KMS key used for encryption of uploaded
+	// objects. Rendered as template. Defaults to KMSKeyID of
+	// config for files synced to bootstrap bucket.
+	KMSKeyID string
+
+	// Tags is the map of tags of uploaded objects. Values
+	// are rendered as templates.
+	Tags map[string]string
+
+	// Metadata is the map of user metadata of uploaded objects.
+	// Values are rendered as templates.
+	Metadata map[string]string
+
+	// Build is the command, which produces the file. It is run
+	// after global builds, before files are synced.
+	Build *BuildConfig
+
+	// pos is the location of file config in config file.
+	pos position
+}
+
+// HooksConfig is the configuration of stack hooks. Hooks of
+// each kind are run in order, failed hook aborts the operation.
+type HooksConfig struct {
+	// PrePlan hooks are run before the change set is created.
+	PrePlan []HookConfig
+
+	// PreExecute hooks are run before the change set is executed.
+	PreExecute []HookConfig
+
+	// PostExecute hooks are run after successful execution.
+	PostExecute []HookConfig
+
+	// PreDestroy hooks are run before the stack is destroyed.
+	PreDestroy []HookConfig
+
+	// PostDestroy hooks are run after the stack is destroyed.
+	PostDestroy []HookConfig
+}
+
+// HookConfig is the configuration of single hook command.
+type HookConfig struct {
+	// Command is the shell command. Rendered as template.
+	Command string
+
+	// Dir is the working directory of command. Relative paths are
+	// resolved against the directory of config file. Rendered
+	// as template.
+	Dir string
+
+	// Env is the map of additional environment variables of
+	// command. Values are rendered as templates.
+	Env map[string]string
+}
+
+// PolicyConfig is the rule checked against resource changes of
+// plan. The rule matches the change, if all of set conditions
+// match, a condition matches, if any of its values matches.
+type PolicyConfig struct {
+	// Name of the policy.
+	Name string
+
+	// Effect is the effect of matched policy: deny fails the
+	// execution, warn shows the warning and confirm requires
+	// additional confirmation.
+	Effect string `jsonschema:"enum=deny|warn|confirm"`
+
+	// Message is the message shown for matched changes.
+	Message string
+
+	// ResourceTypes is the list of glob patterns of resource
+	// types, like AWS::RDS::*.
+	ResourceTypes []string
+
+	// LogicalIDs is the list of glob patterns of logical IDs.
+	LogicalIDs []string
+
+	// Actions is the list of change actions.
+	Actions []string `jsonschema:"enum=Add|Modify|Remove|Import|Dynamic"`
+
+	// Replacement is the list of replacement values of
+	// modified resources.
+	Replacement []string `jsonschema:"enum=True|False|Conditional"`
+
+	// Properties is the list of glob patterns of names of
+	// changed properties.
+	Properties []string
+
+	// pos is the location of policy config in config file.
+	pos position
+}
+
+// BuildConfig is the configuration of build command, which
+// produces local files before sync.
+type BuildConfig struct {
+	// Name of the build. Required for global builds.
+	Name string
+
+	// Command is the shell command. Rendered as template.
+	Command string
+
+	// Dir is the working directory of command. Relative paths are
+	// resolved against the directory of config file. Rendered
+	// as template.
+	Dir string
+
+	// Env is the map of additional environment variables of
+	// command. Values are rendered as templates.
+	Env map[string]string
+
+	// Inputs is the list of patterns of input files relative to
+	// Dir. If set, the build is skipped, when inputs, command and
+	// environment are not changed since last successful build and
+	// all outputs exist.
+	Inputs []string
+
+	// Exclude is the list of patterns of files relative to Dir,
+	// which are excluded from Inputs, like build outputs.
+	Exclude []string
+
+	// Outputs is the list of files or directories relative to Dir,
+	// produced by the build. Defaults to Src of file for file builds.
+	Outputs []string
+
+	// pos is the location of build config in config file.
+	pos position
 }
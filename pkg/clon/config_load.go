@@ -0,0 +1,729 @@
+package clon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v3"
+)
+
+// position is the location in config file.
+type position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// dir returns the directory of config file.
+func (p position) dir() string {
+	if p.File == "" {
+		return ""
+	}
+	return filepath.Dir(p.File)
+}
+
+// resolvePath resolves the relative path against the
+// directory of config file.
+func (p position) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(p.dir(), path)
+}
+
+func nodePosition(n *yaml.Node, file string) position {
+	return position{File: file, Line: n.Line, Column: n.Column}
+}
+
+// ConfigError is the error in config file.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func newConfigError(pos position, format string, args ...interface{}) *ConfigError {
+	return &ConfigError{
+		File:    pos.File,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *ConfigError) Error() string {
+	pos := position{e.File, e.Line, e.Column}.String()
+	if pos == "" {
+		return e.Message
+	}
+	return pos + ": " + e.Message
+}
+
+// ConfigErrors is the list of config errors.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadConfig reads the config from file. Unknown keys, values
+// of wrong types, duplicate stack names and invalid values are
+// reported as ConfigErrors with their location in file.
+// Included config files are loaded and merged into config.
+func LoadConfig(path string) (*Config, error) {
+	return loadConfigFile(path, make(map[string]bool))
+}
+
+// loadConfigFile loads the config file and its includes. The loading
+// is the set of files currently being loaded, used for detecting
+// cyclic includes.
+func loadConfigFile(path string, loading map[string]bool) (*Config, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot resolve config path")
+	}
+	if loading[abs] {
+		return nil, errors.Errorf("cyclic include of config '%s'", path)
+	}
+	loading[abs] = true
+	defer delete(loading, abs)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot open config")
+	}
+	defer f.Close()
+	c, err := DecodeConfig(f, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = c.include(loading); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
+// include loads the configs listed in Include and merges
+// them into c. Glob patterns are expanded in lexical order.
+func (c *Config) include(loading map[string]bool) error {
+	var errs ConfigErrors
+	for name, env := range c.Environments {
+		if len(env.Include) > 0 {
+			errs = append(errs, newConfigError(env.pos, "Environments.%s: Include is not supported in environments", name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	for _, pattern := range c.Include {
+		path := c.pos.resolvePath(pattern)
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return newConfigError(c.pos, "Include: invalid pattern '%s'", pattern)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(path, "*?[") {
+			return newConfigError(c.pos, "Include: file '%s' not found", pattern)
+		}
+		for _, m := range matches {
+			inc, err := loadConfigFile(m, loading)
+			if err != nil {
+				return errors.Annotatef(err, "cannot include '%s'", m)
+			}
+			if err = c.mergeInclude(inc); err != nil {
+				return errors.Annotatef(err, "cannot include '%s'", m)
+			}
+		}
+	}
+	return nil
+}
+
+// setPolicyPositions sets the locations of policies
+// from decoded YAML list node.
+func setPolicyPositions(policies []PolicyConfig, n *yaml.Node, file string) {
+	for j, e := range n.Content {
+		if j < len(policies) {
+			policies[j].pos = nodePosition(resolveAlias(e), file)
+		}
+	}
+}
+
+// includeSettings are the top-level settings, which can be
+// defined only in the root config.
+var includeSettings = []string{"Name", "AccountID", "Region", "Bootstrap", "KMSKeyID", "IgnoreNestedUpdates", "RootStack"}
+
+// mergeInclude merges the included config o into c. Stacks, builds,
+// files and variables defined in both configs are reported as conflicts.
+func (c *Config) mergeInclude(o *Config) error {
+	var errs ConfigErrors
+	settings := o.Map()
+	for _, name := range includeSettings {
+		if _, ok := settings[name]; ok {
+			errs = append(errs, newConfigError(o.pos, "%s is not supported in included configs", name))
+		}
+	}
+	stacks := make(map[string]position, len(c.Stacks))
+	for _, s := range c.Stacks {
+		stacks[s.Name] = s.pos
+	}
+	for _, s := range o.Stacks {
+		if prev, ok := stacks[s.Name]; ok {
+			errs = append(errs, newConfigError(s.pos, "stack '%s' is already defined at %s", s.Name, prev))
+		}
+	}
+	builds := make(map[string]position, len(c.Builds))
+	for _, b := range c.Builds {
+		builds[b.Name] = b.pos
+	}
+	for _, b := range o.Builds {
+		if prev, ok := builds[b.Name]; ok {
+			errs = append(errs, newConfigError(b.pos, "build '%s' is already defined at %s", b.Name, prev))
+		}
+	}
+	names := make([]string, 0, len(o.Files))
+	for name := range o.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if prev, ok := c.Files[name]; ok {
+			errs = append(errs, newConfigError(o.Files[name].pos, "file '%s' is already defined at %s", name, prev.pos))
+		}
+	}
+	names = names[:0]
+	for name := range o.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := c.Variables[name]; ok {
+			errs = append(errs, newConfigError(o.variablePos[name], "variable '%s' is already defined at %s", name, c.variablePos[name]))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	o.Include = nil
+	if err := c.Merge(o); err != nil {
+		return errors.Trace(err)
+	}
+	if len(o.variablePos) > 0 && c.variablePos == nil {
+		c.variablePos = make(map[string]position, len(o.variablePos))
+	}
+	for name, pos := range o.variablePos {
+		c.variablePos[name] = pos
+	}
+	if len(o.explicit) > 0 && c.explicit == nil {
+		c.explicit = make(map[string]bool, len(o.explicit))
+	}
+	for path := range o.explicit {
+		c.explicit[path] = true
+	}
+	return nil
+}
+
+// DecodeConfig decodes the config from r. The name is used
+// as file name in error locations.
+func DecodeConfig(r io.Reader, name string) (*Config, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return &Config{pos: position{File: name}}, nil
+		}
+		return nil, errors.Annotatef(err, "syntax error in '%s'", name)
+	}
+	root := &doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	var errs ConfigErrors
+	validateNode(root, reflect.TypeOf(Config{}), name, "", &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var m interface{}
+	if err := root.Decode(&m); err != nil {
+		return nil, errors.Annotatef(err, "cannot decode '%s'", name)
+	}
+	c := &Config{}
+	if err := mapstructure.WeakDecode(m, c); err != nil {
+		return nil, errors.Annotatef(err, "cannot parse '%s'", name)
+	}
+	setConfigPositions(c, root, name)
+	return c, nil
+}
+
+// setConfigPositions sets the locations of config, stacks and
+// files, and the explicitly set values of config from decoded
+// YAML node.
+func setConfigPositions(c *Config, n *yaml.Node, file string) {
+	c.pos = nodePosition(n, file)
+	c.explicit = make(map[string]bool)
+	explicitPaths(n, reflect.TypeOf(Config{}), "", c.explicit)
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], resolveAlias(n.Content[i+1])
+		switch strings.ToLower(k.Value) {
+		case "bootstrap":
+			c.Bootstrap.pos = nodePosition(v, file)
+		case "variables":
+			c.variablePos = make(map[string]position, len(c.Variables))
+			forEachMapping(v, func(k, _ *yaml.Node) {
+				c.variablePos[k.Value] = nodePosition(k, file)
+			})
+		case "stacks":
+			for j, e := range v.Content {
+				if j < len(c.Stacks) {
+					e = resolveAlias(e)
+					c.Stacks[j].pos = nodePosition(e, file)
+					forEachMapping(e, func(k, v *yaml.Node) {
+						if strings.EqualFold(k.Value, "policies") {
+							setPolicyPositions(c.Stacks[j].Policies, v, file)
+						}
+					})
+				}
+			}
+		case "files":
+			forEachMapping(v, func(k, v *yaml.Node) {
+				if f, ok := c.Files[k.Value]; ok {
+					f.pos = nodePosition(v, file)
+					if f.Build != nil {
+						f.Build.pos = f.pos
+					}
+					c.Files[k.Value] = f
+				}
+			})
+		case "builds":
+			for j, e := range v.Content {
+				if j < len(c.Builds) {
+					c.Builds[j].pos = nodePosition(resolveAlias(e), file)
+				}
+			}
+		case "policies":
+			setPolicyPositions(c.Policies, v, file)
+		case "environments":
+			forEachMapping(v, func(k, v *yaml.Node) {
+				if env, ok := c.Environments[k.Value]; ok {
+					setConfigPositions(&env, v, file)
+					c.Environments[k.Value] = env
+				}
+			})
+		}
+	}
+}
+
+func forEachMapping(n *yaml.Node, fn func(k, v *yaml.Node)) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fn(n.Content[i], resolveAlias(n.Content[i+1]))
+	}
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func isNullNode(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func nodeKindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!int", "!!float":
+			return "number"
+		case "!!bool":
+			return "boolean"
+		}
+		return "string"
+	}
+	return "unknown"
+}
+
+// configFields returns the exported fields of struct, which
+// can be set in config file.
+func configFields(t reflect.Type) []reflect.StructField {
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// lookupConfigField finds the field by name. Like mapstructure,
+// name is matched case-insensitively.
+func lookupConfigField(fields []reflect.StructField, name string) (reflect.StructField, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// schemaEnum returns the list of allowed values of field, set
+// by enum option of jsonschema tag.
+func schemaEnum(f reflect.StructField) []string {
+	for _, opt := range strings.Split(f.Tag.Get("jsonschema"), ",") {
+		if strings.HasPrefix(opt, "enum=") {
+			return strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+		}
+	}
+	return nil
+}
+
+// suggestField returns the name of the field closest to name, if any.
+func suggestField(fields []reflect.StructField, name string) string {
+	best, bestDist := "", 3
+	for _, f := range fields {
+		if d := levenshtein(strings.ToLower(f.Name), strings.ToLower(name)); d < bestDist {
+			best, bestDist = f.Name, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func typeName(t reflect.Type) string {
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
+
+// validateNode validates the YAML node against type t and
+// appends found errors to errs.
+func validateNode(n *yaml.Node, t reflect.Type, file, path string, errs *ConfigErrors) {
+	n = resolveAlias(n)
+	if isNullNode(n) {
+		return
+	}
+	errorf := func(n *yaml.Node, format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if path != "" {
+			msg = path + ": " + msg
+		}
+		*errs = append(*errs, newConfigError(nodePosition(n, file), "%s", msg))
+	}
+	expect := func(kind yaml.Kind, name string) bool {
+		if n.Kind != kind {
+			errorf(n, "expecting %s, got %s", name, nodeKindName(n))
+			return false
+		}
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+	case reflect.Ptr:
+		validateNode(n, t.Elem(), file, path, errs)
+	case reflect.Struct:
+		if !expect(yaml.MappingNode, "mapping") {
+			return
+		}
+		fields := configFields(t)
+		seen := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Tag == "!!merge" {
+				validateNode(v, t, file, path, errs)
+				continue
+			}
+			f, ok := lookupConfigField(fields, k.Value)
+			if !ok {
+				msg := fmt.Sprintf("unknown field '%s' in %s", k.Value, typeName(t))
+				if s := suggestField(fields, k.Value); s != "" {
+					msg += fmt.Sprintf(", did you mean '%s'?", s)
+				}
+				errorf(k, "%s", msg)
+				continue
+			}
+			if prev, ok := seen[f.Name]; ok {
+				errorf(k, "field '%s' is already defined at line %d", k.Value, prev.Line)
+				continue
+			}
+			seen[f.Name] = k
+			validateNode(v, f.Type, file, joinPath(path, f.Name), errs)
+			if enum := schemaEnum(f); enum != nil {
+				validateEnum(v, enum, file, joinPath(path, f.Name), errs)
+			}
+		}
+	case reflect.Map:
+		if !expect(yaml.MappingNode, "mapping") {
+			return
+		}
+		seen := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if prev, ok := seen[k.Value]; ok {
+				errorf(k, "key '%s' is already defined at line %d", k.Value, prev.Line)
+				continue
+			}
+			seen[k.Value] = k
+			validateNode(v, t.Elem(), file, joinPath(path, k.Value), errs)
+		}
+	case reflect.Slice:
+		if !expect(yaml.SequenceNode, "list") {
+			return
+		}
+		for i, e := range n.Content {
+			validateNode(e, t.Elem(), file, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+		if t.Elem().Kind() == reflect.Struct {
+			validateUniqueNames(n, t.Elem(), file, path, errs)
+		}
+	case reflect.String:
+		expect(yaml.ScalarNode, "string")
+	case reflect.Bool:
+		if expect(yaml.ScalarNode, "boolean") && n.Tag != "!!bool" {
+			if _, err := strconv.ParseBool(n.Value); err != nil {
+				errorf(n, "expecting boolean, got '%s'", n.Value)
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if expect(yaml.ScalarNode, "integer") && n.Tag != "!!int" {
+			if _, err := strconv.ParseInt(n.Value, 0, 64); err != nil {
+				errorf(n, "expecting integer, got '%s'", n.Value)
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if expect(yaml.ScalarNode, "number") && n.Tag != "!!int" && n.Tag != "!!float" {
+			if _, err := strconv.ParseFloat(n.Value, 64); err != nil {
+				errorf(n, "expecting number, got '%s'", n.Value)
+			}
+		}
+	}
+}
+
+// explicitPaths adds the merge paths of values set in node n
+// of type t to res. Paths have the same form as in mergeValue:
+// field names, map keys and names of list elements.
+func explicitPaths(n *yaml.Node, t reflect.Type, path string, res map[string]bool) {
+	n = resolveAlias(n)
+	switch t.Kind() {
+	case reflect.Ptr:
+		explicitPaths(n, t.Elem(), path, res)
+	case reflect.Struct:
+		fields := configFields(t)
+		for i := 0; i+1 < len(n.Content) && n.Kind == yaml.MappingNode; i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Tag == "!!merge" {
+				explicitPaths(v, t, path, res)
+				continue
+			}
+			if f, ok := lookupConfigField(fields, k.Value); ok {
+				res[joinPath(path, f.Name)] = true
+				explicitPaths(v, f.Type, joinPath(path, f.Name), res)
+			}
+		}
+	case reflect.Map, reflect.Interface:
+		elem := t
+		if t.Kind() == reflect.Map {
+			elem = t.Elem()
+		}
+		forEachMapping(n, func(k, v *yaml.Node) {
+			res[joinPath(path, k.Value)] = true
+			explicitPaths(v, elem, joinPath(path, k.Value), res)
+		})
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Struct || n.Kind != yaml.SequenceNode {
+			return
+		}
+		if _, ok := t.Elem().FieldByName("Name"); !ok {
+			return
+		}
+		for _, e := range n.Content {
+			e = resolveAlias(e)
+			forEachMapping(e, func(k, v *yaml.Node) {
+				if strings.EqualFold(k.Value, "Name") && v.Value != "" {
+					explicitPaths(e, t.Elem(), joinPath(path, v.Value), res)
+				}
+			})
+		}
+	}
+}
+
+// validateEnum verifies that scalar values of n are one of enum.
+func validateEnum(n *yaml.Node, enum []string, file, path string, errs *ConfigErrors) {
+	n = resolveAlias(n)
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for i, e := range n.Content {
+			validateEnum(e, enum, file, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case yaml.ScalarNode:
+		if isNullNode(n) {
+			return
+		}
+		for _, v := range enum {
+			if n.Value == v {
+				return
+			}
+		}
+		*errs = append(*errs, newConfigError(nodePosition(n, file),
+			"%s: invalid value '%s', allowed values are %s", path, n.Value, strings.Join(enum, ", ")))
+	}
+}
+
+// validateUniqueNames verifies that elements of list have unique
+// Name fields, if struct t has Name field.
+func validateUniqueNames(n *yaml.Node, t reflect.Type, file, path string, errs *ConfigErrors) {
+	if _, ok := t.FieldByName("Name"); !ok {
+		return
+	}
+	seen := make(map[string]*yaml.Node)
+	for _, e := range n.Content {
+		forEachMapping(resolveAlias(e), func(k, v *yaml.Node) {
+			if !strings.EqualFold(k.Value, "Name") || v.Kind != yaml.ScalarNode || v.Value == "" {
+				return
+			}
+			if prev, ok := seen[v.Value]; ok {
+				*errs = append(*errs, newConfigError(nodePosition(v, file),
+					"%s: duplicate name '%s', previously defined at line %d", path, v.Value, prev.Line))
+				return
+			}
+			seen[v.Value] = v
+		})
+	}
+}
+
+// Validate verifies that required fields of config are set.
+// Validation should be done on final config, after all
+// overrides and environment are applied.
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+	orPos := func(p position) position {
+		if p.Line == 0 {
+			return c.pos
+		}
+		return p
+	}
+	if c.Name == "" {
+		errs = append(errs, newConfigError(c.pos, "Name is required"))
+	}
+	if c.Bootstrap.Template == "" {
+		errs = append(errs, newConfigError(orPos(c.Bootstrap.pos), "Bootstrap: Template is required"))
+	}
+	for i, s := range c.Stacks {
+		if s.Name == "" {
+			errs = append(errs, newConfigError(orPos(s.pos), "Stacks[%d]: Name is required", i))
+		}
+		if s.Template == "" {
+			errs = append(errs, newConfigError(orPos(s.pos), "Stacks[%d]: Template is required", i))
+		}
+		for j, p := range s.Policies {
+			for _, msg := range p.validate() {
+				errs = append(errs, newConfigError(orPos(p.pos), "Stacks[%d].Policies[%d]: %s", i, j, msg))
+			}
+		}
+	}
+	for i, p := range c.Policies {
+		for _, msg := range p.validate() {
+			errs = append(errs, newConfigError(orPos(p.pos), "Policies[%d]: %s", i, msg))
+		}
+	}
+	for i, b := range c.Builds {
+		if b.Name == "" {
+			errs = append(errs, newConfigError(orPos(b.pos), "Builds[%d]: Name is required", i))
+		}
+		if b.Command == "" {
+			errs = append(errs, newConfigError(orPos(b.pos), "Builds[%d]: Command is required", i))
+		}
+	}
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := c.Files[name]
+		if f.Src == "" && f.Key == "" {
+			errs = append(errs, newConfigError(orPos(f.pos), "Files.%s: either Src or Key is required", name))
+		}
+		if f.Src == "" && (f.Prefix != "" || len(f.Include) > 0 || len(f.Exclude) > 0 || f.Delete || f.Render) {
+			errs = append(errs, newConfigError(orPos(f.pos), "Files.%s: Prefix, Include, Exclude, Delete and Render require Src", name))
+		}
+		if f.Delete && f.Prefix == "" {
+			errs = append(errs, newConfigError(orPos(f.pos), "Files.%s: Delete requires Prefix", name))
+		}
+		if f.Build != nil && f.Build.Command == "" {
+			errs = append(errs, newConfigError(orPos(f.pos), "Files.%s: Build: Command is required", name))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
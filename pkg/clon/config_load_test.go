@@ -0,0 +1,308 @@
+package clon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	require := require.New(t)
+
+	c, err := DecodeConfig(strings.NewReader(`
+Name: app
+Bootstrap:
+  Template: bootstrap.yml
+Stacks:
+  - Name: network
+    Template: network.yml
+    Parameters:
+      Port: 8080
+    Capabilities: [CAPABILITY_IAM]
+files:
+  index:
+    Src: index.html
+Environments:
+  prod:
+    Stacks:
+      - Name: network
+        Template: network-prod.yml
+`), "clon.yml")
+	require.Nil(err)
+	require.Equal("app", c.Name)
+	require.Equal(map[string]string{"Port": "8080"}, c.Stacks[0].Parameters)
+	require.Equal(position{"clon.yml", 6, 5}, c.Stacks[0].pos)
+	require.Equal(position{"clon.yml", 13, 5}, c.Files["index"].pos)
+	require.Equal(position{"clon.yml", 17, 9}, c.Environments["prod"].Stacks[0].pos)
+	require.Nil(c.Validate())
+
+	// positions are kept by environment merge
+	env, err := c.Environment("prod")
+	require.Nil(err)
+	require.Equal(position{"clon.yml", 6, 5}, env.Stacks[0].pos)
+	require.Equal(position{"clon.yml", 2, 1}, env.pos)
+
+	c, err = DecodeConfig(strings.NewReader(""), "empty.yml")
+	require.Nil(err)
+	require.Equal(&Config{pos: position{File: "empty.yml"}}, c)
+}
+
+func TestDecodeConfig_errors(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		config string
+		errors []string
+	}{
+		{
+			"Name: app\nStaks: []\n",
+			[]string{"clon.yml:2:1: unknown field 'Staks' in Config, did you mean 'Stacks'?"},
+		},
+		{
+			"Name: app\nStacks:\n  - Name: a\n    Templte: a.yml\n    Unknown: 1\n",
+			[]string{
+				"clon.yml:4:5: Stacks[0]: unknown field 'Templte' in StackConfig, did you mean 'Template'?",
+				"clon.yml:5:5: Stacks[0]: unknown field 'Unknown' in StackConfig",
+			},
+		},
+		{
+			"Name: [a]\nStacks: {}\nIgnoreNestedUpdates: maybe\n",
+			[]string{
+				"clon.yml:1:7: Name: expecting string, got list",
+				"clon.yml:2:9: Stacks: expecting list, got mapping",
+				"clon.yml:3:22: IgnoreNestedUpdates: expecting boolean, got 'maybe'",
+			},
+		},
+		{
+			"Stacks:\n  - Name: a\n  - Name: b\n  - Name: a\n",
+			[]string{"clon.yml:4:11: Stacks: duplicate name 'a', previously defined at line 2"},
+		},
+		{
+			"Name: a\nname: b\n",
+			[]string{"clon.yml:2:1: field 'name' is already defined at line 1"},
+		},
+		{
+			"Stacks:\n  - Name: a\n    Capabilities: [CAPABILITY_IAM, CAPABILITY_FOO]\n",
+			[]string{"clon.yml:3:36: Stacks[0].Capabilities[1]: invalid value 'CAPABILITY_FOO', allowed values are CAPABILITY_IAM, CAPABILITY_NAMED_IAM, CAPABILITY_AUTO_EXPAND"},
+		},
+		{
+			"Environments:\n  prod:\n    Regon: eu-west-1\n",
+			[]string{"clon.yml:3:5: Environments.prod: unknown field 'Regon' in Config, did you mean 'Region'?"},
+		},
+	}
+	for _, input := range inputs {
+		_, err := DecodeConfig(strings.NewReader(input.config), "clon.yml")
+		require.NotNilf(err, "config: %s", input.config)
+		errs, ok := err.(ConfigErrors)
+		require.Truef(ok, "config: %s, error: %s", input.config, err)
+		msgs := make([]string, 0, len(errs))
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		require.Equal(input.errors, msgs)
+	}
+
+	_, err := DecodeConfig(strings.NewReader("Name: [a"), "clon.yml")
+	require.NotNil(err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	require := require.New(t)
+
+	c, err := DecodeConfig(strings.NewReader(`
+Bootstrap:
+  RoleARN: arn
+Stacks:
+  - Template: a.yml
+  - Name: b
+Files:
+  index: {}
+  assets: {Src: assets, Delete: true}
+  remote: {Key: remote.txt, Include: ["*.txt"]}
+Builds:
+  - Command: make
+`), "clon.yml")
+	require.Nil(err)
+	err = c.Validate()
+	require.NotNil(err)
+	require.Equal(`clon.yml:2:1: Name is required
+clon.yml:3:3: Bootstrap: Template is required
+clon.yml:5:5: Stacks[0]: Name is required
+clon.yml:6:5: Stacks[1]: Template is required
+clon.yml:12:5: Builds[0]: Name is required
+clon.yml:9:11: Files.assets: Delete requires Prefix
+clon.yml:8:10: Files.index: either Src or Key is required
+clon.yml:10:11: Files.remote: Prefix, Include, Exclude, Delete and Render require Src`, err.Error())
+
+	require.Nil((&Config{Name: "app", Bootstrap: StackConfig{Template: "b.yml"}}).Validate())
+}
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "clon")
+	require.Nil(t, err)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestLoadConfig_include(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"clon.yml": `
+Name: app
+Include:
+  - stacks/*.yml
+  - files.yml
+Bootstrap:
+  Template: bootstrap.yml
+Stacks:
+  - Name: base
+    Template: base.yml
+`,
+		"stacks/b.yml": `
+Stacks:
+  - Name: b
+    Template: templates/b.yml
+Variables:
+  b: 2
+  policy: '{{ file "policy.json" }}'
+`,
+		"stacks/policy.json": "{}",
+		"stacks/a.yml": `
+Stacks:
+  - Name: a
+    Template: /abs/a.yml
+`,
+		"files.yml": `
+Files:
+  index:
+    Src: html/index.html
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := LoadConfig(filepath.Join(dir, "clon.yml"))
+	require.Nil(err)
+	require.Nil(c.Validate())
+
+	names := make([]string, 0, len(c.Stacks))
+	for _, s := range c.Stacks {
+		names = append(names, s.Name)
+	}
+	require.Equal([]string{"base", "a", "b"}, names)
+	require.Equal(map[string]interface{}{"b": 2, "policy": `{{ file "policy.json" }}`}, c.Variables)
+
+	// paths are resolved against the directory of defining file
+	require.Equal(filepath.Join(dir, "base.yml"), c.Stacks[0].pos.resolvePath(c.Stacks[0].Template))
+	require.Equal("/abs/a.yml", c.Stacks[1].pos.resolvePath(c.Stacks[1].Template))
+	require.Equal(filepath.Join(dir, "stacks/templates/b.yml"), c.Stacks[2].pos.resolvePath(c.Stacks[2].Template))
+	require.Equal(filepath.Join(dir, "html/index.html"), c.Files["index"].pos.resolvePath(c.Files["index"].Src))
+
+	// variables are rendered relative to the defining file
+	awsClient, _ := newTestAWSClient()
+	sm, err := newStackManager(*c, awsClient)
+	require.Nil(err)
+	require.Equal("{}", sm.vars["policy"])
+}
+
+func TestLoadConfig_includeErrors(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		files map[string]string
+		err   string
+	}{
+		{
+			map[string]string{
+				"clon.yml": "Include: [a.yml]\nStacks:\n  - Name: a\n",
+				"a.yml":    "Stacks:\n  - Name: b\n  - Name: a\n",
+			},
+			"a.yml:3:5: stack 'a' is already defined at",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Include: [a.yml]\nFiles:\n  index:\n    Src: a\n",
+				"a.yml":    "Files:\n  index:\n    Src: b\n",
+			},
+			"a.yml:3:5: file 'index' is already defined at",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Include: [a.yml]\nVariables:\n  env: dev\n",
+				"a.yml":    "Variables:\n  env: prod\n",
+			},
+			"a.yml:2:3: variable 'env' is already defined at",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Name: app\nInclude: [a.yml]\n",
+				"a.yml":    "Name: other\nRegion: us-east-1\n",
+			},
+			"a.yml:1:1: Name is not supported in included configs",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Include: [missing.yml]\n",
+			},
+			"Include: file 'missing.yml' not found",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Include: [a.yml]\n",
+				"a.yml":    "Include: [clon.yml]\n",
+			},
+			"cyclic include of config",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Environments:\n  prod:\n    Include: [a.yml]\n",
+			},
+			"Environments.prod: Include is not supported in environments",
+		},
+		{
+			map[string]string{
+				"clon.yml": "Include: [a.yml]\n",
+				"a.yml":    "Stacks:\n  - Nme: a\n",
+			},
+			"a.yml:2:5: Stacks[0]: unknown field 'Nme'",
+		},
+	}
+	for _, input := range inputs {
+		dir := writeConfigFiles(t, input.files)
+		_, err := LoadConfig(filepath.Join(dir, "clon.yml"))
+		os.RemoveAll(dir)
+		require.NotNilf(err, "files: %v", input.files)
+		require.Contains(errors.Cause(err).Error(), input.err)
+	}
+
+	// glob without matches is not an error
+	dir := writeConfigFiles(t, map[string]string{"clon.yml": "Include: [stacks/*.yml]\n"})
+	defer os.RemoveAll(dir)
+	_, err := LoadConfig(filepath.Join(dir, "clon.yml"))
+	require.Nil(err)
+}
+
+func TestTplFuncFile(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{"conf/policy.json": "{}"})
+	defer os.RemoveAll(dir)
+
+	res, err := tplFuncFile(filepath.Join(dir, "conf"))("policy.json")
+	require.Nil(err)
+	require.Equal("{}", res)
+
+	_, err = tplFuncFile(dir)("/etc/passwd")
+	require.NotNil(err)
+	_, err = tplFuncFile(dir)("missing")
+	require.NotNil(err)
+}
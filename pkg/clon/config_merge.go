@@ -0,0 +1,251 @@
+package clon
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// Merge deep-merges the config o onto c. Maps are merged key
+// by key, stacks are matched by name, other lists and non-zero
+// values of o replace the values of c. Values explicitly set in
+// config file of o, including false, empty strings and empty
+// lists, replace the values of c as well.
+func (c *Config) Merge(o *Config) error {
+	return errors.Trace(mergeValue(reflect.ValueOf(c).Elem(), reflect.ValueOf(o).Elem(), "", o.explicit))
+}
+
+// Environment returns the copy of config with named environment
+// merged on top of it.
+func (c *Config) Environment(name string) (*Config, error) {
+	env, ok := c.Environments[name]
+	if !ok {
+		return nil, errors.NotFoundf("environment '%s'", name)
+	}
+	if len(env.Environments) > 0 {
+		return nil, errors.Errorf("environment '%s' cannot define nested environments", name)
+	}
+	res := &Config{}
+	if err := res.Merge(c); err != nil {
+		return nil, errors.Annotatef(err, "cannot copy config")
+	}
+	if err := res.Merge(&env); err != nil {
+		return nil, errors.Annotatef(err, "cannot merge environment '%s'", name)
+	}
+	res.Environments = nil
+	res.variableOrigins = make(map[string]string, len(env.Variables))
+	res.variablePos = make(map[string]position, len(c.variablePos)+len(env.variablePos))
+	for k, pos := range c.variablePos {
+		res.variablePos[k] = pos
+	}
+	for k := range env.Variables {
+		res.variableOrigins[k] = "env:" + name
+		if pos, ok := env.variablePos[k]; ok {
+			res.variablePos[k] = pos
+		}
+	}
+	return res, nil
+}
+
+// mergeValue merges src into dst. All maps and slices of src are copied,
+// so that dst does not share any data with src. The explicit is the set
+// of paths of src, which replace dst even if they have zero values.
+func mergeValue(dst, src reflect.Value, path string, explicit map[string]bool) error {
+	switch src.Kind() {
+	case reflect.Struct:
+		if isZero(dst) {
+			// keep unexported fields, like positions, of src
+			dst.Set(src)
+			for i := 0; i < dst.NumField(); i++ {
+				if dst.Type().Field(i).PkgPath == "" {
+					dst.Field(i).Set(reflect.Zero(dst.Field(i).Type()))
+				}
+			}
+		}
+		for i := 0; i < src.NumField(); i++ {
+			f := src.Type().Field(i)
+			if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" {
+				continue
+			}
+			if err := mergeValue(dst.Field(i), src.Field(i), joinPath(path, f.Name), explicit); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(src.Type()))
+		}
+		keys := src.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			if prev := dst.MapIndex(k); prev.IsValid() {
+				elem.Set(prev)
+			}
+			if err := mergeValue(elem, src.MapIndex(k), joinPath(path, k.String()), explicit); err != nil {
+				return errors.Trace(err)
+			}
+			dst.SetMapIndex(k, elem)
+		}
+	case reflect.Slice:
+		if elemType := src.Type().Elem(); elemType.Kind() == reflect.Struct {
+			if nameField, ok := elemType.FieldByName("Name"); ok {
+				if src.IsNil() {
+					return nil
+				}
+				return errors.Trace(mergeNamedSlice(dst, src, nameField, path, explicit))
+			}
+		}
+		if src.IsNil() {
+			if explicit[path] {
+				dst.Set(src)
+			}
+			return nil
+		}
+		res := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := mergeValue(res.Index(i), src.Index(i), path, nil); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		dst.Set(res)
+	case reflect.Ptr:
+		if src.IsNil() {
+			if explicit[path] {
+				dst.Set(src)
+			}
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+		}
+		return errors.Trace(mergeValue(dst.Elem(), src.Elem(), path, explicit))
+	case reflect.Interface:
+		if src.IsNil() {
+			if explicit[path] {
+				dst.Set(src)
+			}
+			return nil
+		}
+		s := normalizeValue(src.Interface())
+		if sm, ok := s.(map[string]interface{}); ok && !dst.IsNil() {
+			if dm, ok := normalizeValue(dst.Interface()).(map[string]interface{}); ok {
+				if err := mergeValue(reflect.ValueOf(dm), reflect.ValueOf(sm), path, explicit); err != nil {
+					return errors.Trace(err)
+				}
+				dst.Set(reflect.ValueOf(dm))
+				return nil
+			}
+		}
+		dst.Set(reflect.ValueOf(s))
+	default:
+		if !isZero(src) || explicit[path] {
+			dst.Set(src)
+		}
+	}
+	return nil
+}
+
+// mergeNamedSlice merges slices of structs, matching elements by Name field.
+// Elements of src which are not found in dst are appended.
+func mergeNamedSlice(dst, src reflect.Value, nameField reflect.StructField, path string, explicit map[string]bool) error {
+	res := reflect.MakeSlice(src.Type(), 0, dst.Len()+src.Len())
+	index := make(map[string]int, dst.Len())
+	for i := 0; i < dst.Len(); i++ {
+		elem := reflect.New(src.Type().Elem()).Elem()
+		if err := mergeValue(elem, dst.Index(i), path, nil); err != nil {
+			return errors.Trace(err)
+		}
+		index[elem.FieldByIndex(nameField.Index).String()] = res.Len()
+		res = reflect.Append(res, elem)
+	}
+	for i := 0; i < src.Len(); i++ {
+		name := src.Index(i).FieldByIndex(nameField.Index).String()
+		if name == "" {
+			return errors.Errorf("%s: element %d does not have name", path, i)
+		}
+		if j, ok := index[name]; ok {
+			if err := mergeValue(res.Index(j), src.Index(i), joinPath(path, name), explicit); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		elem := reflect.New(src.Type().Elem()).Elem()
+		if err := mergeValue(elem, src.Index(i), joinPath(path, name), explicit); err != nil {
+			return errors.Trace(err)
+		}
+		index[name] = res.Len()
+		res = reflect.Append(res, elem)
+	}
+	dst.Set(res)
+	return nil
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Map returns the config as map of plain values, suitable for
+// encoding. Fields with zero values are omitted.
+func (c *Config) Map() map[string]interface{} {
+	m, _ := plainValue(reflect.ValueOf(c).Elem()).(map[string]interface{})
+	return m
+}
+
+// plainValue converts structs to maps keyed by field names
+// and removes zero values.
+func plainValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		res := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" || isZero(v.Field(i)) {
+				continue
+			}
+			if e := plainValue(v.Field(i)); e != nil {
+				res[f.Name] = e
+			}
+		}
+		if len(res) == 0 {
+			return nil
+		}
+		return res
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+		res := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			res[k.String()] = plainValue(v.MapIndex(k))
+		}
+		return res
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		res := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			res = append(res, plainValue(v.Index(i)))
+		}
+		return res
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return plainValue(v.Elem())
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,180 @@
+package clon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Merge(t *testing.T) {
+	require := require.New(t)
+
+	c := &Config{
+		Name:      "app",
+		AccountID: "123456789012",
+		Stacks: []StackConfig{
+			{Name: "a", Template: "a.yml", Parameters: map[string]string{"P1": "1", "P2": "2"}},
+			{Name: "b", Template: "b.yml", Capabilities: []string{"CAPABILITY_IAM"}},
+		},
+		Variables: map[string]interface{}{
+			"plain": "x",
+			"nested": map[interface{}]interface{}{
+				"a": 1,
+				"b": map[interface{}]interface{}{"c": 2},
+			},
+		},
+	}
+	o := &Config{
+		Name: "app-staging",
+		Stacks: []StackConfig{
+			{Name: "b", Capabilities: []string{"CAPABILITY_NAMED_IAM"}},
+			{Name: "a", Parameters: map[string]string{"P2": "3"}},
+			{Name: "c", Template: "c.yml"},
+		},
+		Variables: map[string]interface{}{
+			"nested": map[string]interface{}{
+				"b": map[string]interface{}{"d": 3},
+			},
+		},
+	}
+	require.Nil(c.Merge(o))
+
+	require.Equal("app-staging", c.Name)
+	require.Equal("123456789012", c.AccountID)
+	require.Equal([]StackConfig{
+		{Name: "a", Template: "a.yml", Parameters: map[string]string{"P1": "1", "P2": "3"}},
+		{Name: "b", Template: "b.yml", Capabilities: []string{"CAPABILITY_NAMED_IAM"}},
+		{Name: "c", Template: "c.yml"},
+	}, c.Stacks)
+	require.Equal(map[string]interface{}{
+		"plain": "x",
+		"nested": map[string]interface{}{
+			"a": 1,
+			"b": map[string]interface{}{"c": 2, "d": 3},
+		},
+	}, c.Variables)
+
+	// merged data must not be shared with source
+	o.Stacks[2].Template = "changed.yml"
+	require.Equal("c.yml", c.Stacks[2].Template)
+
+	require.NotNil(c.Merge(&Config{Stacks: []StackConfig{{Template: "x.yml"}}}))
+}
+
+func TestConfig_Environment(t *testing.T) {
+	require := require.New(t)
+
+	c := &Config{
+		Name:   "app",
+		Region: "eu-central-1",
+		Bootstrap: StackConfig{
+			Template: "bootstrap.yml",
+			Tags:     map[string]string{"Team": "core"},
+		},
+		Variables: map[string]interface{}{"env": "dev", "size": 1},
+		Environments: map[string]Config{
+			"prod": {
+				Name:      "app-prod",
+				Region:    "eu-west-1",
+				Bootstrap: StackConfig{Tags: map[string]string{"Env": "prod"}},
+				Variables: map[string]interface{}{"env": "prod"},
+			},
+			"nested": {
+				Environments: map[string]Config{"x": {}},
+			},
+		},
+	}
+
+	env, err := c.Environment("prod")
+	require.Nil(err)
+	require.Equal("app-prod", env.Name)
+	require.Equal("eu-west-1", env.Region)
+	require.Equal(map[string]string{"Team": "core", "Env": "prod"}, env.Bootstrap.Tags)
+	require.Equal(map[string]interface{}{"env": "prod", "size": 1}, env.Variables)
+	require.Equal(map[string]string{"env": "env:prod"}, env.variableOrigins)
+	require.Nil(env.Environments)
+
+	// base config is not modified
+	require.Equal("app", c.Name)
+	require.Equal(map[string]string{"Team": "core"}, c.Bootstrap.Tags)
+	require.Equal("dev", c.Variables["env"])
+
+	_, err = c.Environment("missing")
+	require.NotNil(err)
+
+	_, err = c.Environment("nested")
+	require.NotNil(err)
+}
+
+func TestConfig_EnvironmentExplicit(t *testing.T) {
+	require := require.New(t)
+
+	c, err := DecodeConfig(strings.NewReader(`
+Name: app
+IgnoreNestedUpdates: true
+Stacks:
+  - Name: app
+    Template: app.yml
+    Protected: true
+    RoleARN: arn:aws:iam::123456789012:role/deploy
+    Capabilities: [CAPABILITY_IAM]
+    Parameters:
+      Size: large
+Files:
+  site:
+    Src: site
+    Delete: true
+Environments:
+  dev:
+    IgnoreNestedUpdates: false
+    Stacks:
+      - Name: app
+        Protected: false
+        RoleARN: ""
+        Capabilities: []
+    Files:
+      site:
+        Delete: false
+`), "clon.yml")
+	require.Nil(err)
+
+	env, err := c.Environment("dev")
+	require.Nil(err)
+	require.False(env.IgnoreNestedUpdates)
+	require.False(env.Stacks[0].Protected)
+	require.Equal("", env.Stacks[0].RoleARN)
+	require.Empty(env.Stacks[0].Capabilities)
+	require.False(env.Files["site"].Delete)
+
+	// values not set in environment are kept
+	require.Equal("app.yml", env.Stacks[0].Template)
+	require.Equal(map[string]string{"Size": "large"}, env.Stacks[0].Parameters)
+	require.Equal("site", env.Files["site"].Src)
+
+	// base config is not modified
+	require.True(c.Stacks[0].Protected)
+	require.True(c.Files["site"].Delete)
+}
+
+func TestConfig_Map(t *testing.T) {
+	require := require.New(t)
+
+	c := &Config{
+		Name:      "app",
+		Stacks:    []StackConfig{{Name: "a", Template: "a.yml"}},
+		Variables: map[string]interface{}{"list": []interface{}{1, 2}},
+		VariableSources: []VariableSource{
+			{Origin: "cli"},
+		},
+	}
+	require.Equal(map[string]interface{}{
+		"Name": "app",
+		"Stacks": []interface{}{
+			map[string]interface{}{"Name": "a", "Template": "a.yml"},
+		},
+		"Variables": map[string]interface{}{
+			"list": []interface{}{1, 2},
+		},
+	}, c.Map())
+}
@@ -0,0 +1,392 @@
+package clon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// Actions of resources of destroyed stack.
+const (
+	ResourceDelete  = "Delete"
+	ResourceRetain  = "Retain"
+	ResourceUnknown = "Unknown"
+)
+
+// DestroyResource is the resource of destroyed stack.
+type DestroyResource struct {
+	LogicalID    string
+	PhysicalID   string
+	ResourceType string
+
+	// DeletionPolicy is the DeletionPolicy of resource in
+	// template. Empty, if it is not set.
+	DeletionPolicy string
+
+	// Action is what happens with resource, when the stack is
+	// destroyed. Action is unknown, if the DeletionPolicy is set
+	// by intrinsic function.
+	Action string
+}
+
+// DestroyDependent is the stack, which breaks if the
+// destroyed stack is removed.
+type DestroyDependent struct {
+	// Stack is the config name of dependent stack, or the name
+	// of CloudFormation stack, if it is not managed by clon.
+	Stack string
+
+	// Reason describes the dependency.
+	Reason string
+}
+
+// DestroyPlan is the preview of stack destruction.
+type DestroyPlan struct {
+	Stack *StackData
+
+	// Protected indicates, that the stack is protected
+	// from destruction.
+	Protected bool
+
+	Resources  []*DestroyResource
+	Dependents []*DestroyDependent
+
+	// Buckets are the buckets, which are emptied, if
+	// EmptyBucketsOnDestroy is set.
+	Buckets []*BucketContents
+}
+
+// Retained returns the resources, which are retained
+// after the stack is destroyed.
+func (p *DestroyPlan) Retained() []*DestroyResource {
+	var res []*DestroyResource
+	for _, r := range p.Resources {
+		if r.Action == ResourceRetain {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// SetAllowProtected allows destroying the stacks,
+// which are marked as protected in config.
+func (sm *StackManager) SetAllowProtected(allow bool) {
+	sm.allowProtected = allow
+}
+
+// SetRetainResources sets the function, which is called when
+// deletion of stack fails. It receives the logical IDs of resources,
+// which failed to delete. If the function returns nil, deletion is
+// retried retaining those resources.
+func (sm *StackManager) SetRetainResources(fn func(stack *StackData, resources []string) error) {
+	sm.retainResources = fn
+}
+
+// checkProtected returns error, if the stack is protected
+// and destroying protected stacks is not allowed.
+func (sm *StackManager) checkProtected(name string, stackConfig *StackConfig) error {
+	if stackConfig.Protected && !sm.allowProtected {
+		return errors.Errorf("stack '%s' is protected, destroying it requires explicit override", name)
+	}
+	return nil
+}
+
+// DestroyPlan returns the preview of stack destruction with
+// resources of the stack and dependent stacks.
+func (sm *StackManager) DestroyPlan(name string) (*DestroyPlan, error) {
+	s, stackConfig, err := sm.getStack(name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get stack '%s'", name)
+	}
+	plan := &DestroyPlan{Stack: s.stackData(), Protected: stackConfig.Protected}
+	if !plan.Stack.Exists() {
+		return plan, nil
+	}
+	if plan.Resources, err = sm.destroyResources(s); err != nil {
+		return nil, errors.Annotatef(err, "cannot read resources of stack '%s'", name)
+	}
+	if plan.Dependents, err = sm.destroyDependents(s); err != nil {
+		return nil, errors.Annotatef(err, "cannot read dependents of stack '%s'", name)
+	}
+	if stackConfig.EmptyBucketsOnDestroy != "" {
+		if plan.Buckets, err = sm.bucketContents(plan.Resources); err != nil {
+			return nil, errors.Annotatef(err, "cannot read buckets of stack '%s'", name)
+		}
+	}
+	return plan, nil
+}
+
+// deletionPolicy is the DeletionPolicy of resource in template.
+type deletionPolicy struct {
+	DeletionPolicy yaml.Node `yaml:"DeletionPolicy"`
+}
+
+// deletionPolicies returns the resources defined in template of
+// stack with their DeletionPolicy and action. The processed template
+// is used, so that resources generated by transforms are included.
+// Templates in JSON format are parsed as YAML.
+func (sm *StackManager) deletionPolicies(s *stack) (map[string]*DestroyResource, error) {
+	var body string
+	for _, stage := range []string{cloudformation.TemplateStageProcessed, cloudformation.TemplateStageOriginal} {
+		out, err := sm.awsClient.cfnconn.GetTemplate(&cloudformation.GetTemplateInput{
+			StackName:     aws.String(s.name),
+			TemplateStage: aws.String(stage),
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot get template")
+		}
+		if body = aws.StringValue(out.TemplateBody); body != "" {
+			break
+		}
+	}
+	var tpl struct {
+		Resources map[string]deletionPolicy `yaml:"Resources"`
+	}
+	if err := yaml.Unmarshal([]byte(body), &tpl); err != nil {
+		return nil, errors.Annotatef(err, "cannot parse template")
+	}
+	res := make(map[string]*DestroyResource, len(tpl.Resources))
+	for id, r := range tpl.Resources {
+		n := r.DeletionPolicy
+		switch {
+		case n.Kind == 0:
+			res[id] = &DestroyResource{Action: ResourceDelete}
+		case n.Kind != yaml.ScalarNode || n.ShortTag() != "!!str":
+			res[id] = &DestroyResource{Action: ResourceUnknown}
+		case n.Value == "Retain" || n.Value == "RetainExceptOnCreate":
+			res[id] = &DestroyResource{DeletionPolicy: n.Value, Action: ResourceRetain}
+		default:
+			res[id] = &DestroyResource{DeletionPolicy: n.Value, Action: ResourceDelete}
+		}
+	}
+	return res, nil
+}
+
+// destroyResources returns the resources of stack with their
+// DeletionPolicy.
+func (sm *StackManager) destroyResources(s *stack) ([]*DestroyResource, error) {
+	policies, err := sm.deletionPolicies(s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	out, err := sm.awsClient.cfnconn.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(s.name),
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot describe stack resources")
+	}
+	res := make([]*DestroyResource, 0, len(out.StackResources))
+	for _, r := range out.StackResources {
+		if aws.StringValue(r.ResourceStatus) == cloudformation.ResourceStatusDeleteComplete {
+			continue
+		}
+		id := aws.StringValue(r.LogicalResourceId)
+		dr, ok := policies[id]
+		if !ok {
+			dr = &DestroyResource{Action: ResourceDelete}
+		}
+		dr.LogicalID = id
+		dr.PhysicalID = aws.StringValue(r.PhysicalResourceId)
+		dr.ResourceType = aws.StringValue(r.ResourceType)
+		res = append(res, dr)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LogicalID < res[j].LogicalID })
+	return res, nil
+}
+
+// destroyDependents returns the existing stacks, which depend on
+// stack in config, and the stacks, which import its exports.
+func (sm *StackManager) destroyDependents(s *stack) ([]*DestroyDependent, error) {
+	var res []*DestroyDependent
+	nodes, err := sm.Graph()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, n := range nodes {
+		for _, dep := range n.DependsOn {
+			if dep == s.configName && sm.stacks[n.Name].stackData().Exists() {
+				res = append(res, &DestroyDependent{Stack: n.Name, Reason: "depends on stack in config"})
+			}
+		}
+	}
+
+	var exports []string
+	err = sm.awsClient.cfnconn.ListExportsPages(&cloudformation.ListExportsInput{}, func(out *cloudformation.ListExportsOutput, _ bool) bool {
+		for _, e := range out.Exports {
+			if aws.StringValue(e.ExportingStackId) == s.stackData().ID {
+				exports = append(exports, aws.StringValue(e.Name))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot list exports")
+	}
+	sort.Strings(exports)
+	for _, export := range exports {
+		var imports []string
+		err = sm.awsClient.cfnconn.ListImportsPages(&cloudformation.ListImportsInput{
+			ExportName: aws.String(export),
+		}, func(out *cloudformation.ListImportsOutput, _ bool) bool {
+			imports = append(imports, aws.StringValueSlice(out.Imports)...)
+			return true
+		})
+		if err != nil {
+			if e, ok := err.(awserr.Error); ok && e.Code() == "ValidationError" && strings.Contains(e.Message(), "not imported") {
+				continue
+			}
+			return nil, errors.Annotatef(err, "cannot list imports of '%s'", export)
+		}
+		for _, name := range imports {
+			res = append(res, &DestroyDependent{Stack: sm.configName(name), Reason: fmt.Sprintf("imports '%s'", export)})
+		}
+	}
+	return res, nil
+}
+
+// configName returns the config name of CloudFormation stack,
+// or the stack name, if the stack is not managed by clon.
+func (sm *StackManager) configName(stackName string) string {
+	for _, name := range sm.stackOrder {
+		if sm.stacks[name].name == stackName {
+			return name
+		}
+	}
+	return stackName
+}
+
+// failedResources returns the logical IDs of resources of
+// stack, which failed to delete.
+func (sm *StackManager) failedResources(s *stack) ([]string, error) {
+	out, err := sm.awsClient.cfnconn.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(s.name),
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot describe stack resources")
+	}
+	var res []string
+	for _, r := range out.StackResources {
+		if aws.StringValue(r.ResourceStatus) == cloudformation.ResourceStatusDeleteFailed {
+			res = append(res, aws.StringValue(r.LogicalResourceId))
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// destroyStack deletes the stack. Buckets of the stack are emptied
+// according to EmptyBucketsOnDestroy. If deletion fails, the
+// resources, which failed to delete, are retained on retry,
+// if it is accepted by retainResources function.
+func (sm *StackManager) destroyStack(s *stack, stackConfig *StackConfig) error {
+	if stackConfig.EmptyBucketsOnDestroy == EmptyBucketsBefore {
+		if err := sm.emptyBuckets(s); err != nil {
+			return errors.Annotatef(err, "cannot empty buckets of stack '%s'", s.configName)
+		}
+	}
+	err := s.destroy()
+	if err != nil && stackConfig.EmptyBucketsOnDestroy == EmptyBucketsOnFailure &&
+		s.stackData().Status == cloudformation.StackStatusDeleteFailed {
+		if berr := sm.emptyBuckets(s); berr != nil {
+			return errors.Annotatef(berr, "cannot empty buckets of stack '%s' after failed deletion", s.configName)
+		}
+		err = s.destroy()
+	}
+	if err == nil || sm.retainResources == nil || s.stackData().Status != cloudformation.StackStatusDeleteFailed {
+		return errors.Trace(err)
+	}
+	failed, ferr := sm.failedResources(s)
+	if ferr != nil {
+		return errors.Annotatef(ferr, "cannot read resources of stack '%s' after failed deletion", s.configName)
+	}
+	if len(failed) == 0 {
+		return errors.Trace(err)
+	}
+	if rerr := sm.retainResources(s.stackData(), failed); rerr != nil {
+		return errors.Annotatef(err, "resources are not retained (%s)", rerr)
+	}
+	return errors.Trace(s.destroy(failed...))
+}
+
+// DestroyOrder returns the names of existing stacks in order of
+// destruction: stacks are destroyed before the stacks, which they
+// depend on. The root stack is destroyed last, if includeRoot is
+// set, otherwise it is not included.
+func (sm *StackManager) DestroyOrder(includeRoot bool) ([]string, error) {
+	nodes, err := sm.Graph()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	deps := make(map[string][]string, len(nodes))
+	for _, n := range nodes {
+		deps[n.Name] = n.DependsOn
+	}
+	// order is the deployment order, dependencies first
+	order := make([]string, 0, len(nodes))
+	state := make(map[string]int, len(nodes))
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		switch state[name] {
+		case 1:
+			return errors.Errorf("cyclic dependency between stacks: %s", strings.Join(append(chain, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(chain, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, n := range nodes {
+		if err = visit(n.Name, nil); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	res := make([]string, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		if name != sm.config.RootStack && sm.stacks[name].stackData().Exists() {
+			res = append(res, name)
+		}
+	}
+	if root, ok := sm.stacks[sm.config.RootStack]; includeRoot && ok && root.stackData().Exists() {
+		res = append(res, sm.config.RootStack)
+	}
+	return res, nil
+}
+
+// DestroyAll destroys all existing stacks in DestroyOrder. If
+// any of stacks is protected and destroying protected stacks is
+// not allowed, none of stacks is destroyed.
+func (sm *StackManager) DestroyAll(includeRoot bool) ([]*StackData, error) {
+	names, err := sm.DestroyOrder(includeRoot)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot resolve destroy order")
+	}
+	for _, name := range names {
+		if err = sm.checkProtected(name, sm.stackConfigs[name]); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	res := make([]*StackData, 0, len(names))
+	for _, name := range names {
+		stackData, err := sm.Destroy(name)
+		if err != nil {
+			return res, errors.Trace(err)
+		}
+		res = append(res, stackData)
+	}
+	return res, nil
+}
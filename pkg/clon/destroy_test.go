@@ -0,0 +1,212 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func newTestStack(name string, outputs map[string]string) *cloudformation.Stack {
+	s := &cloudformation.Stack{
+		StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-" + name + "/1"),
+		StackName:   aws.String("test-" + name),
+		StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+	}
+	for k, v := range outputs {
+		s.Outputs = append(s.Outputs, &cloudformation.Output{
+			OutputKey:   aws.String(k),
+			OutputValue: aws.String(v),
+			ExportName:  aws.String("test-" + name + "-" + k),
+		})
+	}
+	return s
+}
+
+func TestStackManager_DestroyPlan(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "db", Protected: true},
+		StackConfig{Name: "app", DependsOn: []string{"db"}},
+		StackConfig{Name: "worker", Parameters: map[string]string{"Db": `{{ (stack "db").Outputs.Endpoint }}`}},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		newTestStack("db", map[string]string{"Endpoint": "db.local", "Port": "5432"}),
+		newTestStack("app", nil),
+	})
+	cfnconn.AddStackResources("test-db", `
+Resources:
+  Database:
+    Type: AWS::RDS::DBInstance
+    DeletionPolicy: Snapshot
+  Backups:
+    Type: AWS::S3::Bucket
+    DeletionPolicy: Retain
+  Logs:
+    Type: AWS::Logs::LogGroup
+    DeletionPolicy: !If [IsProd, Retain, Delete]
+    Properties:
+      LogGroupName: !Sub "${AWS::StackName}-logs"
+  Role:
+    Type: AWS::IAM::Role
+`, []*cloudformation.StackResource{
+		{LogicalResourceId: aws.String("Role"), PhysicalResourceId: aws.String("test-db-role"), ResourceType: aws.String("AWS::IAM::Role")},
+		{LogicalResourceId: aws.String("Database"), PhysicalResourceId: aws.String("db-1"), ResourceType: aws.String("AWS::RDS::DBInstance")},
+		{LogicalResourceId: aws.String("Backups"), PhysicalResourceId: aws.String("test-backups"), ResourceType: aws.String("AWS::S3::Bucket")},
+		{LogicalResourceId: aws.String("Logs"), PhysicalResourceId: aws.String("test-db-logs"), ResourceType: aws.String("AWS::Logs::LogGroup")},
+	})
+	cfnconn.AddImports("test-db-Endpoint", "test-app", "legacy")
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	plan, err := sm.DestroyPlan("db")
+	require.Nil(err)
+	require.True(plan.Protected)
+	require.Equal([]*DestroyResource{
+		{LogicalID: "Backups", PhysicalID: "test-backups", ResourceType: "AWS::S3::Bucket", DeletionPolicy: "Retain", Action: ResourceRetain},
+		{LogicalID: "Database", PhysicalID: "db-1", ResourceType: "AWS::RDS::DBInstance", DeletionPolicy: "Snapshot", Action: ResourceDelete},
+		{LogicalID: "Logs", PhysicalID: "test-db-logs", ResourceType: "AWS::Logs::LogGroup", Action: ResourceUnknown},
+		{LogicalID: "Role", PhysicalID: "test-db-role", ResourceType: "AWS::IAM::Role", Action: ResourceDelete},
+	}, plan.Resources)
+	require.Equal(plan.Resources[:1], plan.Retained())
+	// worker does not exist, so it is not listed
+	require.Equal([]*DestroyDependent{
+		{Stack: "app", Reason: "depends on stack in config"},
+		{Stack: "app", Reason: "imports 'test-db-Endpoint'"},
+		{Stack: "legacy", Reason: "imports 'test-db-Endpoint'"},
+	}, plan.Dependents)
+
+	// plan of not existing stack is empty
+	plan, err = sm.DestroyPlan("worker")
+	require.Nil(err)
+	require.Empty(plan.Resources)
+	require.Empty(plan.Dependents)
+
+	// protected stack is not destroyed without override
+	_, err = sm.Destroy("db")
+	require.NotNil(err)
+	require.Equal("stack 'db' is protected, destroying it requires explicit override", err.Error())
+	sm.SetAllowProtected(true)
+	stack, err := sm.Destroy("db")
+	require.Nil(err)
+	require.False(stack.Exists())
+}
+
+func TestStackManager_DestroyPlanTransform(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "api"})
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("api", nil)})
+	cfnconn.AddStackResources("test-api", `
+Transform: AWS::Serverless-2016-10-31
+Resources:
+  Function:
+    Type: AWS::Serverless::Function
+  Table:
+    Type: AWS::Serverless::SimpleTable
+`, []*cloudformation.StackResource{
+		{LogicalResourceId: aws.String("Function"), PhysicalResourceId: aws.String("test-api-function"), ResourceType: aws.String("AWS::Lambda::Function")},
+		{LogicalResourceId: aws.String("FunctionRole"), PhysicalResourceId: aws.String("test-api-role"), ResourceType: aws.String("AWS::IAM::Role")},
+		{LogicalResourceId: aws.String("Table"), PhysicalResourceId: aws.String("test-api-table"), ResourceType: aws.String("AWS::DynamoDB::Table")},
+	})
+	cfnconn.AddProcessedTemplate("test-api", `
+Resources:
+  Function:
+    Type: AWS::Lambda::Function
+  FunctionRole:
+    Type: AWS::IAM::Role
+  Table:
+    Type: AWS::DynamoDB::Table
+    DeletionPolicy: Retain
+`)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	plan, err := sm.DestroyPlan("api")
+	require.Nil(err)
+	require.Equal([]*DestroyResource{
+		{LogicalID: "Function", PhysicalID: "test-api-function", ResourceType: "AWS::Lambda::Function", Action: ResourceDelete},
+		{LogicalID: "FunctionRole", PhysicalID: "test-api-role", ResourceType: "AWS::IAM::Role", Action: ResourceDelete},
+		{LogicalID: "Table", PhysicalID: "test-api-table", ResourceType: "AWS::DynamoDB::Table", DeletionPolicy: "Retain", Action: ResourceRetain},
+	}, plan.Resources)
+
+	// original template is used, if processed one is empty
+	cfnconn.AddProcessedTemplate("test-api", "")
+	plan, err = sm.DestroyPlan("api")
+	require.Nil(err)
+	require.Empty(plan.Retained())
+	require.Equal(ResourceDelete, plan.Resources[0].Action)
+}
+
+func TestStackManager_DestroyAll(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "network"},
+		StackConfig{Name: "app", Parameters: map[string]string{"Db": `{{ (stack "db").Outputs.Endpoint }}`}},
+		StackConfig{Name: "db", DependsOn: []string{"network"}, Protected: true},
+		StackConfig{Name: "worker", DependsOn: []string{"app"}},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		newTestStack("bootstrap", nil),
+		newTestStack("network", nil),
+		newTestStack("db", nil),
+		newTestStack("app", nil),
+	})
+	cfnconn.AddStackResources("test-network", "Resources: {}", []*cloudformation.StackResource{
+		{LogicalResourceId: aws.String("Vpc"), ResourceStatus: aws.String(cloudformation.ResourceStatusDeleteComplete)},
+		{LogicalResourceId: aws.String("Logs"), ResourceStatus: aws.String(cloudformation.ResourceStatusDeleteFailed)},
+	})
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	// worker does not exist
+	order, err := sm.DestroyOrder(false)
+	require.Nil(err)
+	require.Equal([]string{"app", "db", "network"}, order)
+	order, err = sm.DestroyOrder(true)
+	require.Nil(err)
+	require.Equal([]string{"app", "db", "network", "bootstrap"}, order)
+
+	// nothing is destroyed, if any stack is protected
+	_, err = sm.DestroyAll(false)
+	require.NotNil(err)
+	require.Equal("stack 'db' is protected, destroying it requires explicit override", err.Error())
+	require.True(sm.stacks["app"].stackData().Exists())
+
+	// deletion of network fails without retaining resources
+	sm.SetAllowProtected(true)
+	stacks, err := sm.DestroyAll(false)
+	require.NotNil(err)
+	require.Len(stacks, 2)
+	_, err = sm.Get("network")
+	require.Nil(err)
+	require.Equal(cloudformation.StackStatusDeleteFailed, sm.stacks["network"].stackData().Status)
+
+	var retained []string
+	sm.SetRetainResources(func(stack *StackData, resources []string) error {
+		require.Equal("network", stack.ConfigName)
+		retained = resources
+		return nil
+	})
+	stacks, err = sm.DestroyAll(true)
+	require.Nil(err)
+	require.Equal([]string{"Logs"}, retained)
+	require.Len(stacks, 2)
+	require.Equal("network", stacks[0].ConfigName)
+	require.False(stacks[0].Exists())
+	require.Equal("bootstrap", stacks[1].ConfigName)
+	require.False(stacks[1].Exists())
+}
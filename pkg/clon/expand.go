@@ -0,0 +1,129 @@
+package clon
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// stackNameRegexp matches the valid names of expanded stacks.
+var stackNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][-a-zA-Z0-9]*$`)
+
+// expandStack expands the stack config with ForEach or Matrix into
+// configs of stack instances. If Name is a template, it is rendered
+// with .Each context, otherwise the loop values are appended to
+// Name. Stack configs without ForEach and Matrix are returned as is.
+func expandStack(sc StackConfig, ctx map[string]interface{}, funcs map[string]interface{}) ([]StackConfig, error) {
+	if len(sc.ForEach) == 0 && len(sc.Matrix) == 0 {
+		return []StackConfig{sc}, nil
+	}
+	if len(sc.ForEach) > 0 && len(sc.Matrix) > 0 {
+		return nil, errors.Errorf("stack '%s' cannot have both ForEach and Matrix", sc.Name)
+	}
+
+	var items []map[string]interface{}
+	if len(sc.ForEach) > 0 {
+		items = make([]map[string]interface{}, 0, len(sc.ForEach))
+		for i, e := range sc.ForEach {
+			switch v := normalizeValue(e).(type) {
+			case map[string]interface{}:
+				items = append(items, v)
+			case []interface{}:
+				return nil, errors.Errorf("stack '%s': ForEach element %d must be scalar or map", sc.Name, i)
+			default:
+				items = append(items, map[string]interface{}{"Value": v})
+			}
+		}
+	} else {
+		for k, values := range sc.Matrix {
+			if len(values) == 0 {
+				return nil, errors.Errorf("stack '%s': Matrix key '%s' has no values", sc.Name, k)
+			}
+		}
+		items = matrixItems(sc.Matrix)
+	}
+
+	templated := strings.Contains(sc.Name, "{{")
+	res := make([]StackConfig, 0, len(items))
+	names := make(map[string]bool, len(items))
+	for _, each := range items {
+		inst := sc
+		inst.ForEach, inst.Matrix = nil, nil
+		inst.each = each
+		if templated {
+			c := make(map[string]interface{}, len(ctx)+1)
+			for k, v := range ctx {
+				c[k] = v
+			}
+			c["Each"] = each
+			name, err := renderTemplate(sc.Name, c, funcs)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot render name of stack '%s'", sc.Name)
+			}
+			inst.Name = name
+		} else {
+			suffix, err := eachSuffix(each)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot expand stack '%s'", sc.Name)
+			}
+			inst.Name = sc.Name + "-" + suffix
+		}
+		if !stackNameRegexp.MatchString(inst.Name) {
+			return nil, errors.Errorf("stack '%s' expands to invalid name '%s'", sc.Name, inst.Name)
+		}
+		if names[inst.Name] {
+			return nil, errors.Errorf("stack '%s' expands to duplicate name '%s'", sc.Name, inst.Name)
+		}
+		names[inst.Name] = true
+		res = append(res, inst)
+	}
+	return res, nil
+}
+
+// matrixItems returns all combinations of matrix values. Keys are
+// iterated in sorted order, last key changes fastest.
+func matrixItems(matrix map[string][]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(matrix))
+	for k := range matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := []map[string]interface{}{{}}
+	for _, k := range keys {
+		next := make([]map[string]interface{}, 0, len(items)*len(matrix[k]))
+		for _, item := range items {
+			for _, v := range matrix[k] {
+				e := make(map[string]interface{}, len(item)+1)
+				for ik, iv := range item {
+					e[ik] = iv
+				}
+				e[k] = normalizeValue(v)
+				next = append(next, e)
+			}
+		}
+		items = next
+	}
+	return items
+}
+
+// eachSuffix returns the loop values joined by '-' in order of
+// sorted keys, used as name suffix of expanded stacks.
+func eachSuffix(each map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(each))
+	for k := range each {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		switch each[k].(type) {
+		case map[string]interface{}, []interface{}:
+			return "", errors.Errorf("value of '%s' is not scalar, Name must be a template", k)
+		}
+		parts = append(parts, fmt.Sprint(each[k]))
+	}
+	return strings.Join(parts, "-"), nil
+}
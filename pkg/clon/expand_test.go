@@ -0,0 +1,133 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stackNames(stacks []StackConfig) []string {
+	res := make([]string, 0, len(stacks))
+	for _, s := range stacks {
+		res = append(res, s.Name)
+	}
+	return res
+}
+
+func TestExpandStack(t *testing.T) {
+	require := require.New(t)
+
+	ctx := map[string]interface{}{"Var": map[string]interface{}{"env": "prod"}}
+
+	var inputs = []struct {
+		config StackConfig
+		names  []string
+		each   []map[string]interface{}
+		err    bool
+	}{
+		{
+			config: StackConfig{Name: "app"},
+			names:  []string{"app"},
+			each:   []map[string]interface{}{nil},
+		},
+		{
+			config: StackConfig{Name: "tenant", ForEach: []interface{}{"a", "b"}},
+			names:  []string{"tenant-a", "tenant-b"},
+			each:   []map[string]interface{}{{"Value": "a"}, {"Value": "b"}},
+		},
+		{
+			config: StackConfig{
+				Name: "{{ .Var.env }}-{{ .Each.tenant }}",
+				ForEach: []interface{}{
+					map[interface{}]interface{}{"tenant": "a", "size": 1},
+					map[interface{}]interface{}{"tenant": "b", "size": 2},
+				},
+			},
+			names: []string{"prod-a", "prod-b"},
+			each: []map[string]interface{}{
+				{"tenant": "a", "size": 1},
+				{"tenant": "b", "size": 2},
+			},
+		},
+		{
+			config: StackConfig{
+				Name: "app",
+				Matrix: map[string][]interface{}{
+					"region": {"eu", "us"},
+					"tier":   {"web", "db"},
+				},
+			},
+			names: []string{"app-eu-web", "app-eu-db", "app-us-web", "app-us-db"},
+			each: []map[string]interface{}{
+				{"region": "eu", "tier": "web"},
+				{"region": "eu", "tier": "db"},
+				{"region": "us", "tier": "web"},
+				{"region": "us", "tier": "db"},
+			},
+		},
+		{
+			config: StackConfig{Name: "app", ForEach: []interface{}{"a"}, Matrix: map[string][]interface{}{"x": {1}}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", ForEach: []interface{}{"a", "a"}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", ForEach: []interface{}{[]interface{}{"a"}}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", ForEach: []interface{}{map[string]interface{}{"a": []interface{}{1}}}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", Matrix: map[string][]interface{}{"x": {}}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "{{ .Each.missing.x }}", ForEach: []interface{}{"a"}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "{{ .Each.Value }}", ForEach: []interface{}{"a_b"}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", ForEach: []interface{}{"us.east"}},
+			err:    true,
+		},
+		{
+			config: StackConfig{Name: "app", Matrix: map[string][]interface{}{"tenant": {"tenant a"}}},
+			err:    true,
+		},
+	}
+	for _, input := range inputs {
+		res, err := expandStack(input.config, ctx, nil)
+		if input.err {
+			require.NotNilf(err, "config: %#+v", input.config)
+			continue
+		}
+		require.Nilf(err, "error: %s", err)
+		require.Equal(input.names, stackNames(res))
+		for i, s := range res {
+			require.Equal(input.each[i], s.each)
+			require.Nil(s.ForEach)
+			require.Nil(s.Matrix)
+		}
+	}
+}
+
+func TestTemplateCalls(t *testing.T) {
+	require := require.New(t)
+
+	funcs := map[string]interface{}{"stack": func(string) interface{} { return nil }}
+	res, err := templateCalls(
+		`{{ (stack "a").Outputs.X }}-{{ if true }}{{ with stack "b" }}{{ .Name }}{{ end }}{{ end }}-{{ stack .Name }}`,
+		"stack", funcs)
+	require.Nil(err)
+	require.Equal([]string{"a", "b"}, res)
+
+	_, err = templateCalls(`{{ stack "a" `, "stack", funcs)
+	require.NotNil(err)
+}
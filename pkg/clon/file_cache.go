@@ -0,0 +1,88 @@
+package clon
+
+import (
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/s3file"
+)
+
+// fileCacheEntry is the recorded state of local file,
+// which was synced to S3 bucket.
+type fileCacheEntry struct {
+	Size        int64
+	ModTime     int64
+	Hash        string
+	Bucket      string
+	Key         string
+	VersionID   string
+	ETag        string
+	ContentType string
+	Attributes  string
+}
+
+// fileCache is the local cache of file hashes and versions
+// keyed by file path. Entries are valid only if size and
+// modification time of file are not changed.
+type fileCache struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]*fileCacheEntry
+	changed bool
+}
+
+// loadFileCache loads the cache from path. Missing or invalid cache
+// file results in empty cache. If path is empty, cache is kept
+// only in memory.
+func loadFileCache(path string) *fileCache {
+	c := &fileCache{path: path, entries: make(map[string]*fileCacheEntry)}
+	if path != "" && !readJSONCache(path, "file", &c.entries) {
+		c.entries = make(map[string]*fileCacheEntry)
+	}
+	return c
+}
+
+// lookup returns the cache entry of file, if file is not
+// changed since entry was recorded.
+func (c *fileCache) lookup(path string, info os.FileInfo) *fileCacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[path]
+	if !ok || e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() {
+		return nil
+	}
+	return e
+}
+
+// store records the synced file in cache.
+func (c *fileCache) store(path string, info os.FileInfo, f *s3file.File, attributes string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[path] = &fileCacheEntry{
+		Size:        info.Size(),
+		ModTime:     info.ModTime().UnixNano(),
+		Hash:        f.Hash,
+		Bucket:      f.Bucket,
+		Key:         f.Key,
+		VersionID:   f.VersionID,
+		ETag:        f.ETag,
+		ContentType: f.ContentType,
+		Attributes:  attributes,
+	}
+	c.changed = true
+}
+
+// save writes the cache into file, if it was changed.
+func (c *fileCache) save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.path == "" || !c.changed {
+		return nil
+	}
+	if err := writeJSONCache(c.path, "file", c.entries); err != nil {
+		return errors.Trace(err)
+	}
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,169 @@
+package clon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/s3file"
+)
+
+// FileSet is the set of files synced from local directory
+// or glob pattern.
+type FileSet struct {
+	// Bucket is the bucket of files.
+	Bucket string
+
+	// Prefix is the common prefix of keys of files.
+	Prefix string
+
+	// Files is the map of relative paths to synced files.
+	Files map[string]*s3file.File
+
+	// Versions is the map of relative paths to version
+	// IDs of synced files.
+	Versions map[string]string
+
+	// Hash is the combined hash of all files in set. It is
+	// changed if any file is added, removed or modified.
+	Hash string
+
+	// Deleted is the list of keys of remote objects, which
+	// were deleted, since they are not present locally.
+	Deleted []string
+}
+
+// isFileSet indicates if source is a directory or glob pattern.
+func isFileSet(src string) bool {
+	if hasGlobMeta(src) {
+		return true
+	}
+	info, err := os.Stat(src)
+	return err == nil && info.IsDir()
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
+}
+
+// globBase returns the longest leading directory of
+// pattern, which does not contain glob meta characters.
+func globBase(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for hasGlobMeta(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// matchPath reports whether slash-separated name matches the
+// pattern. In addition to path.Match syntax, the '**' element
+// matches zero or more path elements.
+func matchPath(pattern, name string) (bool, error) {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				ok, err := matchElems(pattern[1:], name[i:])
+				if ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if !ok || err != nil {
+			return false, errors.Trace(err)
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// matchAny reports whether name matches any of patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := matchPath(p, name)
+		if err != nil {
+			return false, errors.Annotatef(err, "invalid pattern '%s'", p)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listFileSet returns the map of slash-separated relative paths
+// to local paths of files matched by src, include and exclude.
+func listFileSet(src string, include, exclude []string) (map[string]string, error) {
+	var base string
+	var roots []string
+	if hasGlobMeta(src) {
+		matches, err := filepath.Glob(src)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid glob pattern '%s'", src)
+		}
+		base, roots = globBase(src), matches
+	} else {
+		base, roots = src, []string{src}
+	}
+	res := make(map[string]string)
+	for _, root := range roots {
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			rel = filepath.ToSlash(rel)
+			if len(include) > 0 {
+				ok, err := matchAny(include, rel)
+				if err != nil || !ok {
+					return errors.Trace(err)
+				}
+			}
+			ok, err := matchAny(exclude, rel)
+			if err != nil || ok {
+				return errors.Trace(err)
+			}
+			res[rel] = p
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot list files of '%s'", src)
+		}
+	}
+	return res, nil
+}
+
+// fileSetHash returns the combined hash of files.
+func fileSetHash(files map[string]*s3file.File) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\n", name, files[name].Hash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,170 @@
+package clon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestMatchPath(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.js", "app.js", true},
+		{"*.js", "js/app.js", false},
+		{"**/*.js", "app.js", true},
+		{"**/*.js", "js/lib/app.js", true},
+		{"js/**", "js/lib/app.js", true},
+		{"js/**", "css/app.css", false},
+		{"js/**/app.js", "js/app.js", true},
+		{"?.txt", "a.txt", true},
+		{"index.html", "index.html", true},
+	}
+	for _, input := range inputs {
+		ok, err := matchPath(input.pattern, input.name)
+		require.Nil(err)
+		require.Equalf(input.match, ok, "pattern: %s, name: %s", input.pattern, input.name)
+	}
+
+	_, err := matchPath("[", "a")
+	require.NotNil(err)
+}
+
+func TestStackManager_syncFileSet(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"site/index.html":     "<html></html>",
+		"site/js/app.js":      "app",
+		"site/js/app.js.map":  "map",
+		"site/css/style.css":  "body {}",
+		"layers/a/layer.zip":  "a",
+		"layers/b/layer.zip":  "b",
+		"layers/b/README.md":  "readme",
+		"templates/stack.yml": "Resources: {}",
+	})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "app"})
+	defer cleanup()
+	config.Files = map[string]FileConfig{
+		"site": {
+			Src:     filepath.Join(dir, "site"),
+			Prefix:  "www",
+			Exclude: []string{"**/*.map"},
+			Delete:  true,
+		},
+		"layers": {
+			Src:     filepath.Join(dir, "layers/*"),
+			Prefix:  "layers/",
+			Include: []string{"**/*.zip"},
+		},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	s3conn.AddObjects(map[string]string{
+		"bucket/www/old.html": "old",
+		"bucket/other.txt":    "other",
+	})
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	require.Nil(sm.SyncFiles())
+
+	site := sm.fileSets["site"]
+	require.NotNil(site)
+	require.Equal("www/", site.Prefix)
+	require.Len(site.Files, 3)
+	require.Equal("www/js/app.js", site.Files["js/app.js"].Key)
+	require.Equal(s3conn.Object("bucket", "www/js/app.js").VersionID, site.Versions["js/app.js"])
+	require.Nil(s3conn.Object("bucket", "www/js/app.js.map"))
+	require.Equal([]string{"www/old.html"}, site.Deleted)
+	require.Nil(s3conn.Object("bucket", "www/old.html"))
+	require.NotNil(s3conn.Object("bucket", "other.txt"))
+
+	layers := sm.fileSets["layers"]
+	require.NotNil(layers)
+	require.Len(layers.Versions, 2)
+	require.Equal("layers/b/layer.zip", layers.Files["b/layer.zip"].Key)
+
+	// hash is stable and changed only if content is changed
+	hash := site.Hash
+	require.Nil(sm.SyncFiles())
+	require.Equal(hash, sm.fileSets["site"].Hash)
+	require.Empty(sm.fileSets["site"].Deleted)
+	require.Nil(os.Remove(filepath.Join(dir, "site/css/style.css")))
+	require.Nil(sm.SyncFiles())
+	require.NotEqual(hash, sm.fileSets["site"].Hash)
+	require.Equal([]string{"www/css/style.css"}, sm.fileSets["site"].Deleted)
+
+	// file sets are exposed to templates
+	app, _, err := sm.getStack("app")
+	require.Nil(err)
+	res, err := sm.render(app, `{{ .FileSet.site.Hash }} {{ index .FileSet.site.Versions "index.html" }}`)
+	require.Nil(err)
+	require.Equal(sm.fileSets["site"].Hash+" "+s3conn.Object("bucket", "www/index.html").VersionID, res)
+}
+
+func TestStackManager_syncFileSetDelete(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"site/index.html":     "<html></html>",
+		"docs/index.html":     "docs",
+		"config.json":         "{}",
+		"templates/stack.yml": "Resources: {}",
+	})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "app"})
+	defer cleanup()
+	config.Variables = map[string]interface{}{"prefix": ""}
+	config.Files = map[string]FileConfig{
+		"site":      {Src: filepath.Join(dir, "site"), Prefix: "www", Delete: true},
+		"docs":      {Src: filepath.Join(dir, "docs"), Prefix: "www/docs"},
+		"config":    {Src: filepath.Join(dir, "config.json"), Key: "www/config.json"},
+		"templates": {Src: filepath.Join(dir, "templates"), Prefix: "templates", Delete: true},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	s3conn.AddObjects(map[string]string{
+		"bucket/www/old.html":       "old",
+		"bucket/www/docs/old.html":  "old",
+		"bucket/templates/app.yml":  "Resources: {}",
+		"bucket/history/app/1.json": "{}",
+	})
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	require.Nil(sm.SyncFiles())
+
+	// objects of other files, nested file sets and clon are not deleted
+	require.Equal([]string{"www/old.html"}, sm.fileSets["site"].Deleted)
+	require.NotNil(s3conn.Object("bucket", "www/config.json"))
+	require.NotNil(s3conn.Object("bucket", "www/docs/index.html"))
+	require.NotNil(s3conn.Object("bucket", "www/docs/old.html"))
+	require.Empty(sm.fileSets["templates"].Deleted)
+	require.NotNil(s3conn.Object("bucket", "templates/app.yml"))
+
+	// prefix rendered empty would delete whole bucket
+	config.Files = map[string]FileConfig{
+		"site": {Src: filepath.Join(dir, "site"), Prefix: "{{ .Var.prefix }}", Delete: true},
+	}
+	sm, err = newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	err = sm.SyncFiles()
+	require.NotNil(err)
+	require.Equal("cannot sync files of 'site', Delete requires Prefix, which is rendered empty", err.Error())
+	require.NotNil(s3conn.Object("bucket", "history/app/1.json"))
+}
@@ -0,0 +1,479 @@
+package clon
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/s3file"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// defaultSyncConcurrency is the default number of files
+// synced concurrently.
+const defaultSyncConcurrency = 8
+
+// deleteObjectsBatchSize is the maximum number of objects
+// deleted by single DeleteObjects call.
+const deleteObjectsBatchSize = 1000
+
+// reservedPrefixes are the prefixes of objects, which are managed
+// by clon in bootstrap bucket and never deleted by file sets.
+var reservedPrefixes = []string{templatesPrefix, sharedPrefix, historyPrefix}
+
+// syncJob is the sync of single file.
+type syncJob struct {
+	// name is the name of file config.
+	name string
+
+	// rel is the relative path of file in file set.
+	// Empty for single files.
+	rel string
+
+	config s3file.Config
+
+	// remote is the map of keys to objects under prefix
+	// of file set or directory of single file.
+	remote map[string]remoteObject
+
+	file *s3file.File
+	err  error
+}
+
+// fileSetSync is the sync state of single file set.
+type fileSetSync struct {
+	config FileConfig
+	set    *FileSet
+	remote map[string]remoteObject
+}
+
+// remoteObject is the listed state of object in S3 bucket.
+type remoteObject struct {
+	ETag string
+	Size int64
+}
+
+// SetSyncConcurrency sets the maximum number of files
+// synced concurrently by SyncFiles.
+func (sm *StackManager) SetSyncConcurrency(n int) {
+	sm.syncConcurrency = n
+}
+
+// SetFileCache sets the path of local cache of file hashes and
+// versions. If path is empty, cache is kept only in memory.
+func (sm *StackManager) SetFileCache(path string) {
+	sm.fileCachePath = path
+	sm.fileCache = nil
+}
+
+// SyncFiles runs the builds and synchronizes the files
+// from local system to S3 bucket.
+func (sm *StackManager) SyncFiles() error {
+	if err := sm.Build(); err != nil {
+		return errors.Trace(err)
+	}
+	if sm.fileCache == nil {
+		sm.fileCache = loadFileCache(sm.fileCachePath)
+	}
+	names := make([]string, 0, len(sm.fileConfigs))
+	for k := range sm.fileConfigs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var jobs, singles []*syncJob
+	sets := make(map[string]*fileSetSync)
+	for _, k := range names {
+		f := sm.fileConfigs[k]
+		config, prefix, err := sm.renderFileConfig(k, f)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if config.Source == "" || !isFileSet(config.Source) {
+			if f.Render && config.Source != "" {
+				if config.Content, err = sm.renderFile(f, config.Source); err != nil {
+					return errors.Annotatef(err, "cannot sync file '%s'", k)
+				}
+			}
+			job := &syncJob{name: k, config: config}
+			if config.Source != "" && config.Content == nil {
+				singles = append(singles, job)
+			}
+			jobs = append(jobs, job)
+			continue
+		}
+		// sync directory or glob
+		local, err := listFileSet(config.Source, f.Include, f.Exclude)
+		if err != nil {
+			return errors.Annotatef(err, "cannot sync files of '%s'", k)
+		}
+		if f.Delete && prefix == "" {
+			return errors.Errorf("cannot sync files of '%s', Delete requires Prefix, which is rendered empty", k)
+		}
+		remote, err := sm.listObjects(config.Bucket, prefix)
+		if err != nil {
+			return errors.Annotatef(err, "cannot sync files of '%s'", k)
+		}
+		sets[k] = &fileSetSync{
+			config: f,
+			remote: remote,
+			set: &FileSet{
+				Bucket:   config.Bucket,
+				Prefix:   prefix,
+				Files:    make(map[string]*s3file.File, len(local)),
+				Versions: make(map[string]string, len(local)),
+			},
+		}
+		rels := make([]string, 0, len(local))
+		for rel := range local {
+			rels = append(rels, rel)
+		}
+		sort.Strings(rels)
+		for _, rel := range rels {
+			c := config
+			c.Source = local[rel]
+			c.Prefix = prefix
+			c.Key = rel
+			if f.Render {
+				if c.Content, err = sm.renderFile(f, c.Source); err != nil {
+					return errors.Annotatef(err, "cannot sync file '%s' of '%s'", rel, k)
+				}
+			}
+			jobs = append(jobs, &syncJob{name: k, rel: rel, config: c, remote: remote})
+		}
+	}
+
+	if err := sm.listSingleFiles(singles); err != nil {
+		return errors.Annotatef(err, "cannot sync files")
+	}
+	sm.runSyncJobs(jobs)
+
+	// owners is the map of synced objects to names of file configs
+	owners := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		if job.err != nil {
+			if job.rel != "" {
+				return errors.Annotatef(job.err, "cannot sync file '%s' of '%s'", job.rel, job.name)
+			}
+			return errors.Annotatef(job.err, "cannot sync file '%s'", job.name)
+		}
+		owners[job.file.Bucket+"/"+job.file.Key] = job.name
+		if job.rel == "" {
+			sm.files[job.name] = job.file
+			continue
+		}
+		set := sets[job.name].set
+		set.Files[job.rel] = job.file
+		set.Versions[job.rel] = job.file.VersionID
+	}
+	for _, k := range names {
+		s, ok := sets[k]
+		if !ok {
+			continue
+		}
+		s.set.Hash = fileSetHash(s.set.Files)
+		if s.config.Delete {
+			var removed []string
+			for key := range s.remote {
+				if _, ok := s.set.Files[strings.TrimPrefix(key, s.set.Prefix)]; ok {
+					continue
+				}
+				if !sm.ownedByOther(k, s.set.Bucket, key, owners, sets) {
+					removed = append(removed, key)
+				}
+			}
+			sort.Strings(removed)
+			objects := make([]*s3.ObjectIdentifier, 0, len(removed))
+			for _, key := range removed {
+				objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+			}
+			if err := sm.deleteObjects(s.set.Bucket, objects); err != nil {
+				return errors.Annotatef(err, "cannot sync files of '%s'", k)
+			}
+			s.set.Deleted = removed
+		}
+		sm.fileSets[k] = s.set
+	}
+	if err := sm.fileCache.save(); err != nil {
+		return errors.Annotatef(err, "cannot save file cache")
+	}
+	return nil
+}
+
+// listSingleFiles sets the remote objects of single files, so that
+// recorded versions of unchanged files can be verified without
+// reading each object. Objects are listed once per directory of
+// keys, files in root of bucket are listed by key.
+func (sm *StackManager) listSingleFiles(jobs []*syncJob) error {
+	listings := make(map[string]map[string]remoteObject)
+	for _, job := range jobs {
+		key := objectKey(job.config)
+		prefix := key
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			prefix = key[:i+1]
+		}
+		id := job.config.Bucket + "/" + prefix
+		remote, ok := listings[id]
+		if !ok {
+			var err error
+			if remote, err = sm.listObjects(job.config.Bucket, prefix); err != nil {
+				return errors.Trace(err)
+			}
+			listings[id] = remote
+		}
+		job.remote = remote
+	}
+	return nil
+}
+
+// objectKey returns the key of object of file, same
+// as it is identified by s3file.
+func objectKey(c s3file.Config) string {
+	if c.Key == "" {
+		return c.Prefix + filepath.Base(c.Source)
+	}
+	return c.Prefix + c.Key
+}
+
+// ownedByOther reports whether the object in bucket belongs to other
+// file config than name or is managed by clon in bootstrap bucket.
+// Objects under prefixes of other file sets, which are nested in
+// prefix of file set name, belong to those file sets.
+func (sm *StackManager) ownedByOther(name, bucket, key string, owners map[string]string, sets map[string]*fileSetSync) bool {
+	if bucket == sm.bucket {
+		for _, p := range reservedPrefixes {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+	}
+	if owner, ok := owners[bucket+"/"+key]; ok && owner != name {
+		return true
+	}
+	prefix := sets[name].set.Prefix
+	for k, s := range sets {
+		if k == name || s.set.Bucket != bucket {
+			continue
+		}
+		if len(s.set.Prefix) > len(prefix) && strings.HasPrefix(s.set.Prefix, prefix) && strings.HasPrefix(key, s.set.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderFileConfig renders the file config into s3file.Config
+// and the prefix of file set.
+func (sm *StackManager) renderFileConfig(k string, f FileConfig) (config s3file.Config, prefix string, err error) {
+	config.Region = sm.awsClient.region
+	if f.Bucket == "" {
+		config.Bucket = sm.bucket
+	} else {
+		config.Bucket, err = sm.render(nil, f.Bucket)
+		if err != nil {
+			return config, "", errors.Annotatef(err, "cannot sync files, bucket name rendering failed for file '%s'", k)
+		}
+	}
+	config.Key, err = sm.render(nil, f.Key)
+	if err != nil {
+		return config, "", errors.Annotatef(err, "cannot sync files, bucket key rendering failed for file '%s'", k)
+	}
+	config.Source, err = sm.render(nil, f.Src)
+	if err != nil {
+		return config, "", errors.Annotatef(err, "cannot sync files, file source rendering failed for file '%s'", k)
+	}
+	config.Source = f.pos.resolvePath(config.Source)
+	config.ContentType = f.ContentType
+	config.CacheControl = f.CacheControl
+	config.ContentEncoding = f.ContentEncoding
+	config.ACL = f.ACL
+	config.ServerSideEncryption = f.ServerSideEncryption
+	if f.KMSKeyID != "" {
+		if config.SSEKMSKeyID, err = sm.render(nil, f.KMSKeyID); err != nil {
+			return config, "", errors.Annotatef(err, "cannot sync files, KMS key rendering failed for file '%s'", k)
+		}
+	} else if f.Bucket == "" {
+		if config.SSEKMSKeyID, err = sm.kmsKeyID(); err != nil {
+			return config, "", errors.Trace(err)
+		}
+	}
+	if len(f.Tags) > 0 {
+		config.Tags = make(map[string]string, len(f.Tags))
+		if err = sm.renderMapToMap(nil, f.Tags, config.Tags); err != nil {
+			return config, "", errors.Annotatef(err, "cannot sync files, tags rendering failed for file '%s'", k)
+		}
+	}
+	if len(f.Metadata) > 0 {
+		config.Metadata = make(map[string]string, len(f.Metadata))
+		if err = sm.renderMapToMap(nil, f.Metadata, config.Metadata); err != nil {
+			return config, "", errors.Annotatef(err, "cannot sync files, metadata rendering failed for file '%s'", k)
+		}
+	}
+	if config.Source == "" || !isFileSet(config.Source) {
+		return config, "", nil
+	}
+	if config.Key != "" {
+		return config, "", errors.Errorf("cannot sync files, Key cannot be used with directory or glob in file '%s'", k)
+	}
+	prefix, err = sm.render(nil, f.Prefix)
+	if err != nil {
+		return config, "", errors.Annotatef(err, "cannot sync files, prefix rendering failed for file '%s'", k)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return config, prefix, nil
+}
+
+// runSyncJobs runs the jobs by bounded pool of workers.
+func (sm *StackManager) runSyncJobs(jobs []*syncJob) {
+	n := sm.syncConcurrency
+	if n <= 0 {
+		n = defaultSyncConcurrency
+	}
+	if n > len(jobs) {
+		n = len(jobs)
+	}
+	ch := make(chan *syncJob)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				job.file, job.err = sm.syncFile(job)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// renderFile renders the content of file at path as template with
+// the context of stack manager.
+func (sm *StackManager) renderFile(f FileConfig, path string) (io.Reader, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read file")
+	}
+	res, err := sm.renderAt(nil, f.pos, string(content))
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot render file '%s'", path)
+	}
+	return strings.NewReader(res), nil
+}
+
+// syncFile uploads or reads single file. Hash of files, which are
+// not changed since last sync, is taken from cache. If listed object
+// has the recorded ETag and size of file, recorded version is used
+// and object is not read.
+// Rendered files are not cached, since content depends on context.
+func (sm *StackManager) syncFile(job *syncJob) (*s3file.File, error) {
+	c := job.config
+	if c.Content != nil {
+		file, err := s3file.Write(sm.awsClient.s3conn, c)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot upload file to s3")
+		}
+		return file, nil
+	}
+	if c.Source == "" {
+		file, err := s3file.Read(sm.awsClient.s3conn, c)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot read file from s3")
+		}
+		// Discard the content
+		file.Body.Close()
+		return file, nil
+	}
+	info, err := os.Stat(c.Source)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot upload file to s3")
+	}
+	if e := sm.fileCache.lookup(c.Source, info); e != nil {
+		c.Hash = e.Hash
+		remote, ok := job.remote[e.Key]
+		if ok && e.ETag != "" && remote.ETag == e.ETag && remote.Size == info.Size() &&
+			e.Bucket == c.Bucket && e.Key == objectKey(c) &&
+			(e.ContentType == c.ContentType || c.ContentType == "") &&
+			e.Attributes == c.AttributesHash() {
+			c.ContentType = e.ContentType
+			return s3file.Known(c, e.VersionID, e.Hash)
+		}
+	}
+	file, err := s3file.Write(sm.awsClient.s3conn, c)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot upload file to s3")
+	}
+	sm.fileCache.store(c.Source, info, file, c.AttributesHash())
+	return file, nil
+}
+
+// listObjects returns the map of keys to objects under prefix.
+// Only current objects are listed, so that the cost does not
+// grow with the version history of bucket.
+func (sm *StackManager) listObjects(bucket, prefix string) (map[string]remoteObject, error) {
+	res := make(map[string]remoteObject)
+	err := sm.awsClient.s3conn.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range out.Contents {
+			res[aws.StringValue(obj.Key)] = remoteObject{
+				ETag: aws.StringValue(obj.ETag),
+				Size: aws.Int64Value(obj.Size),
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot list objects in '%s/%s'", bucket, prefix)
+	}
+	return res, nil
+}
+
+// deleteObjects deletes objects in batches. Objects with
+// version ID are deleted permanently.
+func (sm *StackManager) deleteObjects(bucket string, objects []*s3.ObjectIdentifier) error {
+	for i := 0; i < len(objects); i += deleteObjectsBatchSize {
+		end := i + deleteObjectsBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		out, err := sm.awsClient.s3conn.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects[i:end], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return errors.Annotatef(err, "cannot delete objects in '%s'", bucket)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return errors.Errorf("cannot delete object '%s': %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	return nil
+}
+
+// kmsKeyID returns the rendered KMS key used for encryption
+// of objects in bootstrap bucket.
+func (sm *StackManager) kmsKeyID() (string, error) {
+	if sm.config.KMSKeyID == "" {
+		return "", nil
+	}
+	key, err := sm.render(nil, sm.config.KMSKeyID)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot render KMSKeyID")
+	}
+	return key, nil
+}
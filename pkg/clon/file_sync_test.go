@@ -0,0 +1,291 @@
+package clon
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestStackManager_syncFilesCache(t *testing.T) {
+	require := require.New(t)
+
+	files := map[string]string{"index.txt": "index"}
+	for i := 0; i < 20; i++ {
+		files[filepath.Join("site", string('a'+rune(i))+".txt")] = string('a' + rune(i))
+	}
+	dir := writeConfigFiles(t, files)
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.Files = map[string]FileConfig{
+		"site":  {Src: filepath.Join(dir, "site"), Prefix: "site"},
+		"index": {Src: filepath.Join(dir, "index.txt")},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	cachePath := filepath.Join(dir, ".clon", "file-cache.json")
+
+	newSM := func() *StackManager {
+		sm, err := newStackManager(config, awsClient)
+		require.Nil(err)
+		sm.SetBucket("bucket")
+		sm.SetSyncConcurrency(4)
+		sm.SetFileCache(cachePath)
+		return sm
+	}
+
+	sm := newSM()
+	require.Nil(sm.SyncFiles())
+	head, put, _, _ := s3conn.Calls()
+	require.Equal(21, head)
+	require.Equal(21, put)
+	require.Len(sm.fileSets["site"].Files, 20)
+	_, err := os.Stat(cachePath)
+	require.Nil(err)
+
+	// unchanged files are verified by listing, objects are not read
+	sm = newSM()
+	require.Nil(sm.SyncFiles())
+	head2, put2, _, listVersions := s3conn.Calls()
+	require.Equal(head, head2)
+	require.Equal(put, put2)
+	require.Equal(0, listVersions)
+	require.Equal(s3conn.Object("bucket", "index.txt").VersionID, sm.files["index"].VersionID)
+	require.Equal(s3conn.Object("bucket", "site/a.txt").VersionID, sm.fileSets["site"].Versions["a.txt"])
+	require.Equal("https://s3.eu-central-1.amazonaws.com/bucket/site/a.txt?versionId="+sm.fileSets["site"].Versions["a.txt"],
+		sm.fileSets["site"].Files["a.txt"].URL)
+
+	// modified file is uploaded
+	path := filepath.Join(dir, "site", "b.txt")
+	require.Nil(ioutil.WriteFile(path, []byte("modified"), 0644))
+	require.Nil(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.Nil(sm.SyncFiles())
+	head3, put3, _, _ := s3conn.Calls()
+	require.Equal(head2+1, head3)
+	require.Equal(put2+1, put3)
+	require.Equal("modified", string(s3conn.Object("bucket", "site/b.txt").Body))
+
+	// remote change invalidates recorded version
+	s3conn.AddObjects(map[string]string{"bucket/site/c.txt": "remote", "bucket/index.txt": "remote"})
+	require.Nil(sm.SyncFiles())
+	require.Equal("c", string(s3conn.Object("bucket", "site/c.txt").Body))
+	require.Equal("index", string(s3conn.Object("bucket", "index.txt").Body))
+
+	// invalid cache is ignored
+	require.Nil(ioutil.WriteFile(cachePath, []byte("{invalid"), 0644))
+	sm = newSM()
+	require.Nil(sm.SyncFiles())
+}
+
+func TestStackManager_syncFilesCacheHistory(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{"app.yml": "app", "site/a.txt": "a"})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.Files = map[string]FileConfig{
+		"site": {Src: filepath.Join(dir, "site"), Prefix: "site/"},
+		"app":  {Src: filepath.Join(dir, "app.yml"), Key: "templates/app.yml"},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+
+	// versioned bucket with long history of deployments
+	for i := 0; i < 100; i++ {
+		s3conn.AddObjects(map[string]string{
+			"bucket/templates/app.yml":  fmt.Sprintf("app %d", i),
+			"bucket/templates/base.yml": fmt.Sprintf("base %d", i),
+			"bucket/site/a.txt":         fmt.Sprintf("a %d", i),
+		})
+	}
+
+	cachePath := filepath.Join(dir, ".clon", "file-cache.json")
+	newSM := func() *StackManager {
+		sm, err := newStackManager(config, awsClient)
+		require.Nil(err)
+		sm.SetBucket("bucket")
+		sm.SetFileCache(cachePath)
+		return sm
+	}
+	require.Nil(newSM().SyncFiles())
+	head, put, _, _ := s3conn.Calls()
+
+	sm := newSM()
+	require.Nil(sm.SyncFiles())
+	head2, put2, _, listVersions := s3conn.Calls()
+	require.Equal(head, head2)
+	require.Equal(put, put2)
+	require.Equal(0, listVersions)
+	require.Equal(s3conn.Object("bucket", "templates/app.yml").VersionID, sm.files["app"].VersionID)
+	require.Equal(s3conn.Object("bucket", "site/a.txt").VersionID, sm.fileSets["site"].Versions["a.txt"])
+}
+
+func TestStackManager_syncFilesUnversioned(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{"index.txt": "index"})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.Files = map[string]FileConfig{
+		"index": {Src: filepath.Join(dir, "index.txt")},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+	s3conn.Unversioned = true
+
+	cachePath := filepath.Join(dir, ".clon", "file-cache.json")
+	newSM := func() *StackManager {
+		sm, err := newStackManager(config, awsClient)
+		require.Nil(err)
+		sm.SetBucket("bucket")
+		sm.SetFileCache(cachePath)
+		return sm
+	}
+	sm := newSM()
+	require.Nil(sm.SyncFiles())
+	require.Equal("", sm.files["index"].VersionID)
+	head, put, _, _ := s3conn.Calls()
+
+	// cached file is not read
+	sm = newSM()
+	require.Nil(sm.SyncFiles())
+	head2, put2, _, _ := s3conn.Calls()
+	require.Equal(head, head2)
+	require.Equal(put, put2)
+	require.Equal("", sm.files["index"].VersionID)
+	require.Equal("https://s3.eu-central-1.amazonaws.com/bucket/index.txt", sm.files["index"].URL)
+
+	// remote change is detected
+	s3conn.AddObjects(map[string]string{"bucket/index.txt": "remote"})
+	require.Nil(newSM().SyncFiles())
+	require.Equal("index", string(s3conn.Object("bucket", "index.txt").Body))
+}
+
+func TestStackManager_syncFilesAttributes(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"site/index.html": "<html></html>",
+		"site/data":       `{"a": 1}`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.KMSKeyID = "alias/{{ .Var.env }}"
+	config.Variables = map[string]interface{}{"env": "prod"}
+	config.Files = map[string]FileConfig{
+		"site": {
+			Src:          filepath.Join(dir, "site"),
+			Prefix:       "site",
+			CacheControl: "max-age=60",
+			Tags:         map[string]string{"env": "{{ .Var.env }}"},
+			Metadata:     map[string]string{"Owner": "team"},
+		},
+	}
+	awsClient, _ := newTestAWSClient()
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	require.Nil(sm.SyncFiles())
+
+	obj := s3conn.Object("bucket", "site/index.html")
+	require.Equal("text/html; charset=utf-8", obj.ContentType)
+	require.Equal("max-age=60", obj.CacheControl)
+	require.Equal("aws:kms", obj.ServerSideEncryption)
+	require.Equal("alias/prod", obj.SSEKMSKeyID)
+	require.Equal("env=prod", obj.Tagging)
+	require.Equal("team", obj.Metadata["Owner"])
+	require.Equal("text/plain; charset=utf-8", s3conn.Object("bucket", "site/data").ContentType)
+
+	// changed attributes are uploaded, even if content is not changed
+	_, put, _, _ := s3conn.Calls()
+	f := sm.fileConfigs["site"]
+	f.CacheControl = "no-cache"
+	sm.fileConfigs["site"] = f
+	require.Nil(sm.SyncFiles())
+	_, put2, _, _ := s3conn.Calls()
+	require.Equal(put+2, put2)
+	require.Equal("no-cache", s3conn.Object("bucket", "site/index.html").CacheControl)
+}
+
+func TestStackManager_syncFilesRender(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.json":        `{"env": "{{ .Var.env }}", "bucket": "{{ .Bootstrap.Outputs.Bucket }}", "account": "{{ .AccountId }}", "region": "{{ .Region }}"}`,
+		"raw.json":           `{"env": "{{ .Var.env }}"}`,
+		"states/a.asl.json":  `{"Comment": "{{ .Var.env }}-a"}`,
+		"states/b.asl.json":  `{"Comment": "{{ .Var.env }}-b"}`,
+		"broken/broken.json": `{{ .Var.env`,
+	})
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t)
+	defer cleanup()
+	config.AccountID = "123456789012"
+	config.Variables = map[string]interface{}{"env": "prod"}
+	config.Files = map[string]FileConfig{
+		"config": {Src: filepath.Join(dir, "config.json"), Render: true},
+		"raw":    {Src: filepath.Join(dir, "raw.json")},
+		"states": {Src: filepath.Join(dir, "states"), Prefix: "states", Render: true},
+	}
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-bootstrap/1"),
+			StackName:   aws.String("test-bootstrap"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("Bucket"), OutputValue: aws.String("bucket")},
+			},
+		},
+	})
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	require.Nil(sm.SyncFiles())
+
+	require.JSONEq(`{"env": "prod", "bucket": "bucket", "account": "123456789012", "region": "eu-central-1"}`,
+		string(s3conn.Object("bucket", "config.json").Body))
+	require.Equal(`{"env": "{{ .Var.env }}"}`, string(s3conn.Object("bucket", "raw.json").Body))
+	require.Equal(`{"Comment": "prod-b"}`, string(s3conn.Object("bucket", "states/b.asl.json").Body))
+	require.Equal(sm.files["config"].Hash, hashOf(`{"env": "prod", "bucket": "bucket", "account": "123456789012", "region": "eu-central-1"}`))
+
+	// rendering errors are reported
+	config.Files = map[string]FileConfig{
+		"broken": {Src: filepath.Join(dir, "broken"), Prefix: "broken", Render: true},
+	}
+	sm, err = newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	err = sm.SyncFiles()
+	require.NotNil(err)
+	require.Contains(err.Error(), "broken.json")
+}
+
+func hashOf(content string) string {
+	h := md5.Sum([]byte(content))
+	return hex.EncodeToString(h[:])
+}
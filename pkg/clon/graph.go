@@ -0,0 +1,68 @@
+package clon
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// StackNode is the node of stack dependency graph.
+type StackNode struct {
+	// Name is the config name of stack.
+	Name string
+
+	// DependsOn is the list of stacks, which the stack depends on.
+	DependsOn []string
+}
+
+// Graph returns the dependency graph of stacks in config order.
+// Dependencies are DependsOn of stacks and calls of 'stack' function
+// with constant stack names in RoleARN, Parameters and Tags.
+func (sm *StackManager) Graph() ([]*StackNode, error) {
+	res := make([]*StackNode, 0, len(sm.stackOrder))
+	for _, name := range sm.stackOrder {
+		s, stackConfig, err := sm.getStack(name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		deps, err := sm.stackDependencies(s, stackConfig)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot read dependencies of stack '%s'", name)
+		}
+		res = append(res, &StackNode{Name: name, DependsOn: deps})
+	}
+	return res, nil
+}
+
+// stackDependencies returns the sorted names of stacks, which
+// stack refers to in its templates.
+func (sm *StackManager) stackDependencies(s *stack, stackConfig *StackConfig) ([]string, error) {
+	funcs := sm.renderFuncs(s, stackConfig.pos)
+
+	values := []string{stackConfig.RoleARN}
+	for _, v := range stackConfig.Parameters {
+		values = append(values, v)
+	}
+	for _, v := range stackConfig.Tags {
+		values = append(values, v)
+	}
+	refs := make(map[string]struct{})
+	for _, name := range sm.dependsOn[s.configName] {
+		refs[name] = struct{}{}
+	}
+	for _, v := range values {
+		names, err := templateCalls(v, "stack", funcs)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, name := range names {
+			refs[name] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(refs))
+	for name := range refs {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
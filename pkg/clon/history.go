@@ -0,0 +1,347 @@
+package clon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/cfn"
+	"github.com/spirius/clon/pkg/s3file"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// historyPrefix is the prefix of deployment records in bootstrap bucket.
+const historyPrefix = "history/"
+
+// DeploymentRecord is the record of successful execution of
+// stack plan. Records are stored in bootstrap bucket and
+// numbered sequentially per stack.
+type DeploymentRecord struct {
+	// Number is the sequence number of deployment.
+	Number int
+
+	// Stack is the config name of the stack.
+	Stack string
+
+	// StackName is the CloudFormation stack name.
+	StackName string
+
+	// PlanID is the ID of executed plan.
+	PlanID string
+
+	// Time is the time of execution.
+	Time time.Time
+
+	// TemplateURL is the S3 URL of the copy of deployed template
+	// in deployment history, including its version.
+	TemplateURL string
+
+	// TemplateVersion is the S3 object version of the template copy.
+	TemplateVersion string `json:",omitempty"`
+
+	RoleARN      string            `json:",omitempty"`
+	Capabilities []string          `json:",omitempty"`
+	Parameters   map[string]string `json:",omitempty"`
+	Tags         map[string]string `json:",omitempty"`
+
+	// Files is the map of file names to their S3 object versions.
+	Files map[string]string `json:",omitempty"`
+
+	// FileSets is the map of file set names to their hashes.
+	FileSets map[string]string `json:",omitempty"`
+
+	// ClonVersion is the version of clon, which planned the deployment.
+	ClonVersion string `json:",omitempty"`
+
+	// GitCommit is the commit of git repository of config file.
+	GitCommit string `json:",omitempty"`
+
+	// DeployedBy is the session name of AWS identity.
+	DeployedBy string `json:",omitempty"`
+
+	// RollbackOf is the number of deployment, which was
+	// rolled back to. Zero for regular deployments.
+	RollbackOf int `json:",omitempty"`
+}
+
+// SetVersion sets the version of clon recorded in
+// deployment records.
+func (sm *StackManager) SetVersion(version string) {
+	sm.version = version
+}
+
+// historyKey returns the key of deployment record.
+func historyKey(stack string, number int) string {
+	return fmt.Sprintf("%s/%06d.json", stack, number)
+}
+
+// gitCommit returns the HEAD commit of git repository in dir.
+// Empty string is returned if dir is not in git repository.
+func gitCommit(dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// plannedDeployment is the input of plan created by stack
+// manager, used to complete the record of deployment.
+type plannedDeployment struct {
+	stackData  *StackData
+	rollbackOf int
+}
+
+// maskedValue is the value of NoEcho parameters returned
+// by CloudFormation.
+const maskedValue = "****"
+
+// newDeploymentRecord creates the record of executed change set.
+// Parameters, tags and capabilities are taken from the change set,
+// values of NoEcho parameters are taken from plan, if it was created
+// by this stack manager.
+func (sm *StackManager) newDeploymentRecord(s *stack, stackConfig *StackConfig, planID string, cs *cfn.StackData) *DeploymentRecord {
+	r := &DeploymentRecord{
+		Stack:        s.configName,
+		StackName:    s.name,
+		PlanID:       planID,
+		RoleARN:      s.stackData().RoleARN,
+		Capabilities: cs.Capabilities,
+		Parameters:   cs.Parameters,
+		Tags:         cs.Tags,
+		ClonVersion:  sm.version,
+		GitCommit:    gitCommit(stackConfig.pos.dir()),
+		DeployedBy:   sm.awsClient.sessionName,
+	}
+	if p, ok := sm.planned[planID]; ok {
+		r.RollbackOf = p.rollbackOf
+		for k, v := range r.Parameters {
+			if planned, ok := p.stackData.Parameters[k]; ok && v == maskedValue {
+				r.Parameters[k] = planned
+			}
+		}
+	}
+	if len(sm.files) > 0 {
+		r.Files = make(map[string]string, len(sm.files))
+		for name, f := range sm.files {
+			r.Files[name] = f.VersionID
+		}
+	}
+	if len(sm.fileSets) > 0 {
+		r.FileSets = make(map[string]string, len(sm.fileSets))
+		for name, fs := range sm.fileSets {
+			r.FileSets[name] = fs.Hash
+		}
+	}
+	return r
+}
+
+// writeTemplate stores the copy of deployed template of stack
+// into deployment history.
+func (sm *StackManager) writeTemplate(bucket string, s *stack, number int) (*s3file.File, error) {
+	out, err := sm.awsClient.cfnconn.GetTemplate(&cloudformation.GetTemplateInput{
+		StackName: aws.String(s.name),
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get template")
+	}
+	kmsKeyID, err := sm.kmsKeyID()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f, err := s3file.Write(sm.awsClient.s3conn, s3file.Config{
+		Region:      sm.awsClient.region,
+		Bucket:      bucket,
+		Prefix:      historyPrefix,
+		Key:         fmt.Sprintf("%s/%06d.template", s.configName, number),
+		Content:     strings.NewReader(aws.StringValue(out.TemplateBody)),
+		ContentType: "text/plain",
+		SSEKMSKeyID: kmsKeyID,
+	})
+	return f, errors.Annotatef(err, "cannot write template")
+}
+
+// writeRecord writes the deployment record into bootstrap bucket.
+func (sm *StackManager) writeRecord(bucket, key string, r *DeploymentRecord) error {
+	kmsKeyID, err := sm.kmsKeyID()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Annotatef(err, "cannot encode deployment record")
+	}
+	_, err = s3file.Write(sm.awsClient.s3conn, s3file.Config{
+		Region:      sm.awsClient.region,
+		Bucket:      bucket,
+		Prefix:      historyPrefix,
+		Key:         key,
+		Content:     bytes.NewReader(content),
+		ContentType: "application/json",
+		SSEKMSKeyID: kmsKeyID,
+	})
+	return errors.Annotatef(err, "cannot write deployment record '%s'", key)
+}
+
+// readRecord reads the deployment record from bootstrap bucket.
+func (sm *StackManager) readRecord(bucket, key string) (*DeploymentRecord, error) {
+	f, err := s3file.Read(sm.awsClient.s3conn, s3file.Config{
+		Region: sm.awsClient.region,
+		Bucket: bucket,
+		Prefix: historyPrefix,
+		Key:    key,
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NotFoundf("deployment record '%s'", key)
+		}
+		return nil, errors.Annotatef(err, "cannot read deployment record '%s'", key)
+	}
+	defer f.Body.Close()
+	r := &DeploymentRecord{}
+	if err = json.NewDecoder(f.Body).Decode(r); err != nil {
+		return nil, errors.Annotatef(err, "cannot decode deployment record '%s'", key)
+	}
+	return r, nil
+}
+
+// recordNumbers returns the sorted numbers of deployment records of stack.
+func (sm *StackManager) recordNumbers(bucket, stack string) ([]int, error) {
+	prefix := historyPrefix + stack + "/"
+	var res []int
+	err := sm.awsClient.s3conn.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range out.Contents {
+			key := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+			// copies of templates are stored next to records
+			if !strings.HasSuffix(key, ".json") {
+				continue
+			}
+			if n, err := strconv.Atoi(strings.TrimSuffix(key, ".json")); err == nil {
+				res = append(res, n)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot list deployment records of stack '%s'", stack)
+	}
+	sort.Ints(res)
+	return res, nil
+}
+
+// recordDeployment writes the record of executed change set
+// as the next deployment of stack, together with the copy of
+// deployed template.
+func (sm *StackManager) recordDeployment(s *stack, stackConfig *StackConfig, planID string, cs *cfn.StackData) (*DeploymentRecord, error) {
+	defer delete(sm.planned, planID)
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	numbers, err := sm.recordNumbers(bucket, s.configName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r := sm.newDeploymentRecord(s, stackConfig, planID, cs)
+	r.Number = 1
+	if len(numbers) > 0 {
+		r.Number = numbers[len(numbers)-1] + 1
+	}
+	r.Time = time.Now().UTC()
+	tpl, err := sm.writeTemplate(bucket, s, r.Number)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.TemplateURL = tpl.URL
+	r.TemplateVersion = tpl.VersionID
+	return r, errors.Trace(sm.writeRecord(bucket, historyKey(s.configName, r.Number), r))
+}
+
+// History returns the deployment records of stack sorted by number.
+func (sm *StackManager) History(name string) ([]*DeploymentRecord, error) {
+	if _, _, err := sm.getStack(name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	numbers, err := sm.recordNumbers(bucket, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := make([]*DeploymentRecord, 0, len(numbers))
+	for _, n := range numbers {
+		r, err := sm.readRecord(bucket, historyKey(name, n))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// rollbackStackData returns the stack data of recorded deployment.
+func (sm *StackManager) rollbackStackData(name string, number int) (*StackData, error) {
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r, err := sm.readRecord(bucket, historyKey(name, number))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NotFoundf("deployment %d of stack '%s'", number, name)
+		}
+		return nil, errors.Trace(err)
+	}
+	if r.TemplateURL == "" {
+		return nil, errors.Errorf("deployment %d of stack '%s' has no template URL", number, name)
+	}
+	for k, v := range r.Parameters {
+		if v == maskedValue {
+			return nil, errors.Errorf("deployment %d of stack '%s' has no value of NoEcho parameter '%s'", number, name, k)
+		}
+	}
+	return &StackData{
+		StackData: cfn.StackData{
+			Name:         sm.stackName(name),
+			RoleARN:      r.RoleARN,
+			Capabilities: r.Capabilities,
+			Parameters:   r.Parameters,
+			Tags:         r.Tags,
+			TemplateURL:  r.TemplateURL,
+		},
+	}, nil
+}
+
+// Rollback plans the change of stack back to the template
+// and rendered inputs of recorded deployment.
+func (sm *StackManager) Rollback(name string, number int) (*Plan, error) {
+	stack, stackConfig, err := sm.getStack(name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot rollback stack '%s'", name)
+	}
+	if !stack.stackData().Exists() {
+		return nil, errors.Errorf("cannot rollback stack '%s', stack does not exist", name)
+	}
+	stackData, err := sm.rollbackStackData(name, number)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot rollback stack '%s'", name)
+	}
+	return sm.planStack(stack, stackConfig, stackData, number)
+}
@@ -0,0 +1,92 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/spirius/clon/pkg/cfn"
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestStackManager_history(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{Name: "app"})
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("app", nil)})
+	cfnconn.AddStackResources("test-app", "Resources: {}", nil)
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	sm.SetBucket("bucket")
+	sm.SetVersion("v1.2.3")
+
+	app, appConfig, err := sm.getStack("app")
+	require.Nil(err)
+	executed := func() *cfn.StackData {
+		return &cfn.StackData{
+			Parameters:   map[string]string{"Env": "prod", "Password": maskedValue},
+			Tags:         map[string]string{"team": "core"},
+			Capabilities: []string{"CAPABILITY_IAM"},
+		}
+	}
+
+	// values of NoEcho parameters are taken from plan
+	sm.planned["plan-1"] = &plannedDeployment{
+		stackData:  &StackData{StackData: cfn.StackData{Parameters: map[string]string{"Env": "dev", "Password": "secret"}}},
+		rollbackOf: 3,
+	}
+	r, err := sm.recordDeployment(app, appConfig, "plan-1", executed())
+	require.Nil(err)
+	require.Equal(1, r.Number)
+	require.Empty(sm.planned)
+
+	// plans executed by other process are recorded from change set
+	r, err = sm.recordDeployment(app, appConfig, "plan-2", executed())
+	require.Nil(err)
+	require.Equal(2, r.Number)
+
+	records, err := sm.History("app")
+	require.Nil(err)
+	require.Len(records, 2)
+	r = records[0]
+	require.Equal(1, r.Number)
+	require.Equal("app", r.Stack)
+	require.Equal("test-app", r.StackName)
+	require.Equal("plan-1", r.PlanID)
+	tpl := s3conn.Object("bucket", "history/app/000001.template")
+	require.NotNil(tpl)
+	require.Equal("Resources: {}", string(tpl.Body))
+	require.Equal(tpl.VersionID, r.TemplateVersion)
+	require.Contains(r.TemplateURL, "history/app/000001.template?versionId="+tpl.VersionID)
+	require.Equal(map[string]string{"Env": "prod", "Password": "secret"}, r.Parameters)
+	require.Equal(map[string]string{"team": "core"}, r.Tags)
+	require.Equal([]string{"CAPABILITY_IAM"}, r.Capabilities)
+	require.Equal("v1.2.3", r.ClonVersion)
+	require.Equal("test", r.DeployedBy)
+	require.False(r.Time.IsZero())
+	require.Equal(3, r.RollbackOf)
+	require.Equal(0, records[1].RollbackOf)
+	require.Equal(maskedValue, records[1].Parameters["Password"])
+
+	// rollback uses recorded inputs instead of config
+	rollback, err := sm.rollbackStackData("app", 1)
+	require.Nil(err)
+	require.Equal("test-app", rollback.Name)
+	require.Equal(r.TemplateURL, rollback.TemplateURL)
+	require.Equal(r.Parameters, rollback.Parameters)
+
+	_, err = sm.rollbackStackData("app", 2)
+	require.NotNil(err)
+	require.Equal("deployment 2 of stack 'app' has no value of NoEcho parameter 'Password'", err.Error())
+	_, err = sm.Rollback("app", 5)
+	require.NotNil(err)
+	require.Equal("cannot rollback stack 'app': deployment 5 of stack 'app' not found", err.Error())
+	_, err = sm.History("unknown")
+	require.NotNil(err)
+}
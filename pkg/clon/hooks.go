@@ -0,0 +1,185 @@
+package clon
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/cfn"
+)
+
+// Kinds of stack hooks.
+const (
+	HookPrePlan     = "PrePlan"
+	HookPreExecute  = "PreExecute"
+	HookPostExecute = "PostExecute"
+	HookPreDestroy  = "PreDestroy"
+	HookPostDestroy = "PostDestroy"
+)
+
+// Hook statuses reported by Hook event.
+const (
+	HookStarted   = "started"
+	HookSucceeded = "succeeded"
+	HookFailed    = "failed"
+)
+
+// Hook is the event emitted, when hook command is
+// started or finished.
+type Hook struct {
+	// Stack is the config name of the stack.
+	Stack string
+
+	// Kind is the kind of hook, like PreExecute.
+	Kind string
+
+	// Index is the index of hook in the list of hooks of same kind.
+	Index int
+
+	// Status is the status of hook.
+	Status string
+
+	// ExitCode is the exit code of finished command.
+	ExitCode int
+
+	// Duration is the duration of finished command.
+	Duration time.Duration
+}
+
+// HookOutput is the event emitted for each line of
+// output of hook command.
+type HookOutput struct {
+	// Stack is the config name of the stack.
+	Stack string
+
+	// Kind is the kind of hook.
+	Kind string
+
+	// Line is the line of combined stdout and stderr of command.
+	Line string
+}
+
+// hookEnv returns the environment variables of stack hook. The
+// params are the rendered parameters of stack, the outputs are
+// exposed from stack data sd.
+func (sm *StackManager) hookEnv(s *stack, kind string, params map[string]string, sd *StackData) []string {
+	env := []string{
+		"CLON_HOOK=" + kind,
+		"CLON_STACK=" + s.configName,
+		"CLON_STACK_NAME=" + s.name,
+		"CLON_STACK_ID=" + sd.ID,
+		"CLON_STACK_STATUS=" + sd.Status,
+	}
+	env = append(env, prefixedEnv("CLON_PARAMETER_", params)...)
+	env = append(env, prefixedEnv("CLON_OUTPUT_", sd.Outputs)...)
+	return env
+}
+
+// prefixedEnv returns the sorted environment variables
+// from map m with names prefixed by prefix.
+func prefixedEnv(prefix string, m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, prefix+k+"="+m[k])
+	}
+	return res
+}
+
+// postHookError is the error of post hook, which failed after
+// the stack was changed successfully.
+type postHookError struct {
+	stack  string
+	change string
+	err    error
+}
+
+func (e *postHookError) Error() string {
+	return fmt.Sprintf("stack '%s' is %s, only post hook failed: %s", e.stack, e.change, e.err)
+}
+
+// runHooks runs the hooks of given kind of stack in order. The
+// params are the rendered parameters of stack, if nil, they are
+// rendered from config. The stack data sd provides the outputs
+// and status. The first failed hook stops the execution.
+func (sm *StackManager) runHooks(s *stack, stackConfig *StackConfig, kind string, params map[string]string, sd *StackData) error {
+	var hooks []HookConfig
+	switch kind {
+	case HookPrePlan:
+		hooks = stackConfig.Hooks.PrePlan
+	case HookPreExecute:
+		hooks = stackConfig.Hooks.PreExecute
+	case HookPostExecute:
+		hooks = stackConfig.Hooks.PostExecute
+	case HookPreDestroy:
+		hooks = stackConfig.Hooks.PreDestroy
+	case HookPostDestroy:
+		hooks = stackConfig.Hooks.PostDestroy
+	default:
+		return errors.Errorf("unknown hook '%s'", kind)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	if params == nil {
+		params = make(map[string]string, len(stackConfig.Parameters))
+		if err := sm.renderMapToMap(s, stackConfig.Parameters, params); err != nil {
+			return errors.Annotatef(err, "cannot render Parameters of stack '%s'", s.configName)
+		}
+	}
+	baseEnv := sm.hookEnv(s, kind, params, sd)
+	for i, h := range hooks {
+		command, err := sm.render(s, h.Command)
+		if err != nil {
+			return errors.Annotatef(err, "cannot render Command of %s[%d] hook of stack '%s'", kind, i, s.configName)
+		}
+		dir := h.Dir
+		if dir != "" {
+			if dir, err = sm.render(s, dir); err != nil {
+				return errors.Annotatef(err, "cannot render Dir of %s[%d] hook of stack '%s'", kind, i, s.configName)
+			}
+		}
+		if dir = stackConfig.pos.resolvePath(dir); dir == "" {
+			dir = stackConfig.pos.dir()
+		}
+		extra := make(map[string]string, len(h.Env))
+		if err = sm.renderMapToMap(s, h.Env, extra); err != nil {
+			return errors.Annotatef(err, "cannot render Env of %s[%d] hook of stack '%s'", kind, i, s.configName)
+		}
+		env := append(append([]string{}, baseEnv...), prefixedEnv("", extra)...)
+
+		sm.emit(&Hook{Stack: s.configName, Kind: kind, Index: i, Status: HookStarted})
+		exitCode, duration, err := runCommand(command, dir, env, func(line string) {
+			sm.emit(&HookOutput{Stack: s.configName, Kind: kind, Line: line})
+		})
+		status := &Hook{Stack: s.configName, Kind: kind, Index: i, Status: HookSucceeded, ExitCode: exitCode, Duration: duration}
+		if err != nil {
+			status.Status = HookFailed
+			sm.emit(status)
+			return errors.Annotatef(err, "%s[%d] hook of stack '%s' failed", kind, i, s.configName)
+		}
+		sm.emit(status)
+	}
+	return nil
+}
+
+// plannedParameters returns the parameters of executed plan. Parameters
+// of plans created by other process are read from the change set.
+func (sm *StackManager) plannedParameters(s *stack, changeSetID, planID string) (map[string]string, error) {
+	if p, ok := sm.planned[planID]; ok {
+		return p.stackData.Parameters, nil
+	}
+	cs, err := s.getChangeSet(&cfn.ChangeSetData{
+		ID:        changeSetID,
+		StackData: &s.stackData().StackData,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get change set '%s' for stack '%s'", changeSetID, s.configName)
+	}
+	return cs.Data().StackData.Parameters, nil
+}
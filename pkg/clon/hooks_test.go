@@ -0,0 +1,178 @@
+package clon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/spirius/clon/pkg/cfn"
+)
+
+func TestStackManager_runHooks(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "clon")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	config, cleanup := newTestConfig(t, StackConfig{
+		Name:       "app",
+		Parameters: map[string]string{"Env": "{{ .Var.env }}"},
+		Hooks: HooksConfig{
+			PostExecute: []HookConfig{
+				{Command: "echo $CLON_STACK $CLON_PARAMETER_Env $CLON_OUTPUT_Url $EXTRA", Env: map[string]string{"EXTRA": "{{ .Name }}"}},
+				{Command: "pwd > out.txt", Dir: dir},
+			},
+			PrePlan: []HookConfig{{Command: "echo {{ .Var.env }} >&2; exit 2"}},
+		},
+	})
+	defer cleanup()
+	config.Variables = map[string]interface{}{"env": "prod"}
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-app/1"),
+			StackName:   aws.String("test-app"),
+			StackStatus: aws.String(cloudformation.StackStatusUpdateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("Url"), OutputValue: aws.String("https://app")},
+			},
+		},
+	})
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var events []interface{}
+	sm.SetEventHandler(func(e interface{}) {
+		if h, ok := e.(*Hook); ok {
+			h.Duration = 0
+		}
+		events = append(events, e)
+	})
+
+	s, stackConfig, err := sm.getStack("app")
+	require.Nil(err)
+	require.Nil(sm.runHooks(s, stackConfig, HookPostExecute, nil, s.stackData()))
+	require.Equal([]interface{}{
+		&Hook{Stack: "app", Kind: HookPostExecute, Index: 0, Status: HookStarted},
+		&HookOutput{Stack: "app", Kind: HookPostExecute, Line: "app prod https://app test"},
+		&Hook{Stack: "app", Kind: HookPostExecute, Index: 0, Status: HookSucceeded},
+		&Hook{Stack: "app", Kind: HookPostExecute, Index: 1, Status: HookStarted},
+		&Hook{Stack: "app", Kind: HookPostExecute, Index: 1, Status: HookSucceeded},
+	}, events)
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	require.Nil(err)
+	require.Equal(dir+"\n", string(out))
+
+	// stacks without hooks are not affected
+	events = nil
+	require.Nil(sm.runHooks(s, stackConfig, HookPreDestroy, nil, s.stackData()))
+	require.Empty(events)
+
+	// failed hook aborts the plan
+	_, err = sm.Plan("app")
+	require.NotNil(err)
+	require.Contains(err.Error(), "PrePlan[0] hook of stack 'app' failed")
+	require.Equal([]interface{}{
+		&Hook{Stack: "app", Kind: HookPrePlan, Index: 0, Status: HookStarted},
+		&HookOutput{Stack: "app", Kind: HookPrePlan, Line: "prod"},
+		&Hook{Stack: "app", Kind: HookPrePlan, Index: 0, Status: HookFailed, ExitCode: 2},
+	}, events)
+}
+
+func TestStackManager_executeHooks(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{
+		Name:       "app",
+		Parameters: map[string]string{"Env": "{{ .Var.env }}"},
+		Hooks: HooksConfig{
+			PreExecute: []HookConfig{{Command: "echo $CLON_PARAMETER_Env; exit 3"}},
+		},
+	})
+	defer cleanup()
+	config.Variables = map[string]interface{}{"env": "prod"}
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("app", nil)})
+	cfnconn.MockDescribeChangeSet = func(in *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+		return &cloudformation.DescribeChangeSetOutput{
+			ChangeSetId:     in.ChangeSetName,
+			StackName:       aws.String("test-app"),
+			Status:          aws.String(cloudformation.ChangeSetStatusCreateComplete),
+			ExecutionStatus: aws.String(cloudformation.ExecutionStatusAvailable),
+			Parameters: []*cloudformation.Parameter{
+				{ParameterKey: aws.String("Env"), ParameterValue: aws.String("staging")},
+			},
+		}, nil
+	}
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var lines []string
+	sm.SetEventHandler(func(e interface{}) {
+		if o, ok := e.(*HookOutput); ok {
+			lines = append(lines, o.Line)
+		}
+	})
+
+	// parameters of executed change set are exposed, not rendered config
+	_, err = sm.Execute("app", "plan-1")
+	require.NotNil(err)
+	require.Contains(err.Error(), "PreExecute[0] hook of stack 'app' failed")
+	require.Equal([]string{"staging"}, lines)
+
+	// parameters of plan created by stack manager are used as is
+	lines = nil
+	sm.planned["plan-2"] = &plannedDeployment{
+		stackData: &StackData{StackData: cfn.StackData{Parameters: map[string]string{"Env": "dev"}}},
+	}
+	_, err = sm.Execute("app", "plan-2")
+	require.NotNil(err)
+	require.Equal([]string{"dev"}, lines)
+}
+
+func TestStackManager_postExecuteHookFailed(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{
+		Name: "app",
+		Hooks: HooksConfig{
+			PostExecute: []HookConfig{{Command: "exit 5"}},
+		},
+	})
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{newTestStack("app", nil)})
+	cfnconn.AddChangeSets([]*cloudformation.DescribeChangeSetOutput{
+		{
+			ChangeSetId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:changeSet/plan-1/1"),
+			ChangeSetName:   aws.String("plan-1"),
+			StackName:       aws.String("test-app"),
+			Status:          aws.String(cloudformation.ChangeSetStatusCreateComplete),
+			ExecutionStatus: aws.String(cloudformation.ExecutionStatusAvailable),
+		},
+	})
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var hooks []*Hook
+	sm.SetEventHandler(func(e interface{}) {
+		if h, ok := e.(*Hook); ok {
+			hooks = append(hooks, h)
+		}
+	})
+
+	// stack is deployed, error reports only failed hook
+	stack, err := sm.Execute("app", "plan-1")
+	require.NotNil(err)
+	require.Equal("stack 'app' is deployed, only post hook failed: PostExecute[0] hook of stack 'app' failed: exit status 5",
+		errors.Cause(err).Error())
+	require.Equal(cloudformation.StackStatusUpdateComplete, stack.Status)
+	require.Len(hooks, 2)
+	require.Equal(HookFailed, hooks[1].Status)
+	require.Equal(5, hooks[1].ExitCode)
+}
@@ -0,0 +1,50 @@
+package clon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// readJSONCache decodes the cache file at path into v. The kind is
+// the name of cache used in messages. Missing or invalid cache file
+// is not an error, false is returned if v was not decoded.
+func readJSONCache(path, kind string, v interface{}) bool {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debugf("cannot read %s cache '%s': %s", kind, path, err)
+		}
+		return false
+	}
+	if err = json.Unmarshal(content, v); err != nil {
+		log.Debugf("ignoring invalid %s cache '%s': %s", kind, path, err)
+		return false
+	}
+	return true
+}
+
+// writeJSONCache encodes v into the cache file at path. The file
+// is replaced atomically, so that interrupted write does not leave
+// the cache corrupted.
+func writeJSONCache(path, kind string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return errors.Annotatef(err, "cannot encode %s cache", kind)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Annotatef(err, "cannot create %s cache directory", kind)
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return errors.Annotatef(err, "cannot write %s cache", kind)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return errors.Annotatef(err, "cannot write %s cache", kind)
+	}
+	return nil
+}
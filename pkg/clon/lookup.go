@@ -0,0 +1,137 @@
+package clon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// tplRequiredEnv is function exposed to template engine with name
+// 'requiredEnv'. Unlike sprig's 'env', it fails if environment
+// variable is not set.
+func tplRequiredEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.Errorf("environment variable '%s' is not set", name)
+	}
+	return value, nil
+}
+
+// tplEnvOr is function exposed to template engine with name 'envOr'.
+// It returns the value of environment variable or def, if variable is not set.
+func tplEnvOr(name, def string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return def
+}
+
+// valueLookup reads values from AWS SSM Parameter Store and
+// AWS Secrets Manager. Values are cached for the lifetime of lookup.
+type valueLookup struct {
+	ssmconn ssmiface.SSMAPI
+	smconn  secretsmanageriface.SecretsManagerAPI
+
+	lock  sync.Mutex
+	cache map[string]string
+}
+
+func newValueLookup(ssmconn ssmiface.SSMAPI, smconn secretsmanageriface.SecretsManagerAPI) *valueLookup {
+	return &valueLookup{
+		ssmconn: ssmconn,
+		smconn:  smconn,
+		cache:   make(map[string]string),
+	}
+}
+
+func (l *valueLookup) cached(key string, fn func() (string, error)) (string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if value, ok := l.cache[key]; ok {
+		return value, nil
+	}
+	value, err := fn()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	l.cache[key] = value
+	return value, nil
+}
+
+// funcs returns the template functions of lookup.
+func (l *valueLookup) funcs() map[string]interface{} {
+	return map[string]interface{}{
+		"ssm":    l.tplSSM,
+		"secret": l.tplSecret,
+	}
+}
+
+// tplSSM is function exposed to template engine with name 'ssm'.
+// It returns the decrypted value of SSM parameter.
+func (l *valueLookup) tplSSM(name string) (string, error) {
+	return l.cached("ssm:"+name, func() (string, error) {
+		out, err := l.ssmconn.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			if e, ok := err.(awserr.Error); ok && e.Code() == ssm.ErrCodeParameterNotFound {
+				return "", errors.NotFoundf("ssm parameter '%s'", name)
+			}
+			return "", errors.Annotatef(err, "cannot read ssm parameter '%s'", name)
+		}
+		if out.Parameter == nil {
+			return "", errors.NotFoundf("ssm parameter '%s'", name)
+		}
+		return aws.StringValue(out.Parameter.Value), nil
+	})
+}
+
+// tplSecret is function exposed to template engine with name 'secret'.
+// It returns the string value of secret. If key is specified,
+// secret value is parsed as JSON object and value of key is returned.
+func (l *valueLookup) tplSecret(id string, key ...string) (string, error) {
+	if len(key) > 1 {
+		return "", errors.Errorf("secret accepts at most one key, got %d", len(key))
+	}
+	value, err := l.cached("secret:"+id, func() (string, error) {
+		out, err := l.smconn.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(id),
+		})
+		if err != nil {
+			if e, ok := err.(awserr.Error); ok && e.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+				return "", errors.NotFoundf("secret '%s'", id)
+			}
+			return "", errors.Annotatef(err, "cannot read secret '%s'", id)
+		}
+		if out.SecretString == nil {
+			return "", errors.Errorf("secret '%s' does not have string value", id)
+		}
+		return aws.StringValue(out.SecretString), nil
+	})
+	if err != nil || len(key) == 0 {
+		return value, errors.Trace(err)
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal([]byte(value), &m); err != nil {
+		return "", errors.Annotatef(err, "cannot parse secret '%s' as JSON object", id)
+	}
+	v, ok := m[key[0]]
+	if !ok {
+		return "", errors.NotFoundf("key '%s' in secret '%s'", key[0], id)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,102 @@
+package clon
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestEnv(t *testing.T) {
+	require := require.New(t)
+
+	os.Setenv("CLON_TEST_ENV", "value")
+	defer os.Unsetenv("CLON_TEST_ENV")
+	os.Unsetenv("CLON_TEST_ENV_MISSING")
+
+	res, err := renderTemplate(`{{ requiredEnv "CLON_TEST_ENV" }}`, nil, nil)
+	require.Nil(err)
+	require.Equal("value", res)
+
+	_, err = renderTemplate(`{{ requiredEnv "CLON_TEST_ENV_MISSING" }}`, nil, nil)
+	require.NotNil(err)
+
+	// sprig version returns empty string for missing variables
+	res, err = renderTemplate(`{{ env "CLON_TEST_ENV" }}-{{ env "CLON_TEST_ENV_MISSING" }}`, nil, nil)
+	require.Nil(err)
+	require.Equal("value-", res)
+
+	res, err = renderTemplate(`{{ envOr "CLON_TEST_ENV" "def" }}-{{ envOr "CLON_TEST_ENV_MISSING" "def" }}`, nil, nil)
+	require.Nil(err)
+	require.Equal("value-def", res)
+}
+
+func TestValueLookup_ssm(t *testing.T) {
+	require := require.New(t)
+
+	ssmconn := mock.NewMockSSMAPI()
+	ssmconn.AddParameters(map[string]string{
+		"/app/db/host": "db.example.com",
+	})
+	l := newValueLookup(ssmconn, mock.NewMockSecretsManagerAPI())
+
+	res, err := renderTemplate(`{{ ssm "/app/db/host" }}`, nil, l.funcs())
+	require.Nil(err)
+	require.Equal("db.example.com", res)
+
+	_, err = l.tplSSM("/app/db/missing")
+	require.NotNil(err)
+	require.True(errors.IsNotFound(err))
+
+	// cached values are not read again
+	ssmconn.MockGetParameter = func(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+		return nil, fmt.Errorf("some error")
+	}
+	res, err = l.tplSSM("/app/db/host")
+	require.Nil(err)
+	require.Equal("db.example.com", res)
+
+	_, err = l.tplSSM("/app/db/other")
+	require.NotNil(err)
+	require.False(errors.IsNotFound(err))
+}
+
+func TestValueLookup_secret(t *testing.T) {
+	require := require.New(t)
+
+	smconn := mock.NewMockSecretsManagerAPI()
+	smconn.AddSecrets(map[string]string{
+		"plain": "secret-value",
+		"json":  `{"username": "admin", "port": 5432}`,
+	})
+	l := newValueLookup(mock.NewMockSSMAPI(), smconn)
+
+	var inputs = []struct {
+		tpl      string
+		expected string
+		err      bool
+	}{
+		{`{{ secret "plain" }}`, "secret-value", false},
+		{`{{ secret "json" "username" }}`, "admin", false},
+		{`{{ secret "json" "port" }}`, "5432", false},
+		{`{{ secret "json" "missing" }}`, "", true},
+		{`{{ secret "plain" "key" }}`, "", true},
+		{`{{ secret "missing" }}`, "", true},
+		{`{{ secret "json" "a" "b" }}`, "", true},
+	}
+	for _, input := range inputs {
+		res, err := renderTemplate(input.tpl, nil, l.funcs())
+		if input.err {
+			require.NotNilf(err, "template: %s", input.tpl)
+			continue
+		}
+		require.Nilf(err, "template: %s, error: %s", input.tpl, err)
+		require.Equal(input.expected, res)
+	}
+}
@@ -0,0 +1,369 @@
+package mock
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// MockS3Object is the version of object stored in MockS3API.
+type MockS3Object struct {
+	Body        []byte
+	ContentType string
+	ETag        string
+	VersionID   string
+
+	// DeleteMarker indicates if version is a delete marker.
+	DeleteMarker bool
+
+	CacheControl         string
+	ContentEncoding      string
+	ServerSideEncryption string
+	SSEKMSKeyID          string
+	ACL                  string
+	Tagging              string
+	Metadata             map[string]string
+}
+
+// MockS3API is the in-memory mock for AWS S3 API with versioning
+// enabled on all buckets, unless Unversioned is set.
+type MockS3API struct {
+	s3iface.S3API
+
+	// Unversioned disables versioning on all buckets. Objects are
+	// replaced on write and have "null" version, which is returned
+	// only by list operations.
+	Unversioned bool
+
+	objectsLock sync.Mutex
+	objects     map[string][]*MockS3Object
+	version     int
+
+	headCalls         int
+	putCalls          int
+	listCalls         int
+	listVersionsCalls int
+
+	// MockPutObject can be used to mock the call to PutObject API.
+	MockPutObject func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+
+	// MockGetObject can be used to mock the call to GetObject API.
+	MockGetObject func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+
+	// MockDeleteObjects can be used to mock the call to DeleteObjects API.
+	MockDeleteObjects func(*s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
+}
+
+// NewMockS3API creates new mock of S3 API.
+func NewMockS3API() *MockS3API {
+	return &MockS3API{
+		objects: make(map[string][]*MockS3Object),
+	}
+}
+
+func objectPath(bucket, key *string) string {
+	return aws.StringValue(bucket) + "/" + aws.StringValue(key)
+}
+
+func noSuchKey(code string) error {
+	return awserr.NewRequestFailure(awserr.New(code, "The specified key does not exist.", nil), 404, "")
+}
+
+// AddObjects adds new objects to mock implementation. The keys
+// of objects are in bucket/key format.
+func (c *MockS3API) AddObjects(objects map[string]string) {
+	for path, body := range objects {
+		parts := strings.SplitN(path, "/", 2)
+		c.putObject(parts[0], parts[1], &MockS3Object{Body: []byte(body)})
+	}
+}
+
+// Object returns the latest version of object, or nil
+// if object does not exist or is deleted.
+func (c *MockS3API) Object(bucket, key string) *MockS3Object {
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	versions := c.objects[bucket+"/"+key]
+	if len(versions) == 0 || versions[len(versions)-1].DeleteMarker {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// Versions returns all versions of object, oldest first.
+func (c *MockS3API) Versions(bucket, key string) []*MockS3Object {
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	return append([]*MockS3Object{}, c.objects[bucket+"/"+key]...)
+}
+
+func (c *MockS3API) putObject(bucket, key string, obj *MockS3Object) *MockS3Object {
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	c.version++
+	h := md5.Sum(obj.Body)
+	obj.ETag = fmt.Sprintf(`"%s"`, hex.EncodeToString(h[:]))
+	obj.VersionID = "v" + strconv.Itoa(c.version)
+	path := bucket + "/" + key
+	if c.Unversioned {
+		obj.VersionID = nullVersionID
+		c.objects[path] = []*MockS3Object{obj}
+		return obj
+	}
+	c.objects[path] = append(c.objects[path], obj)
+	return obj
+}
+
+// nullVersionID is the version of objects in unversioned buckets.
+const nullVersionID = "null"
+
+// versionID returns the version ID of object as it is returned
+// by object operations, which omit the version of unversioned objects.
+func versionID(obj *MockS3Object) *string {
+	if obj.VersionID == nullVersionID {
+		return nil
+	}
+	return aws.String(obj.VersionID)
+}
+
+func (c *MockS3API) getObject(bucket, key *string, versionID *string) *MockS3Object {
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	versions := c.objects[objectPath(bucket, key)]
+	if len(versions) == 0 {
+		return nil
+	}
+	if versionID == nil {
+		if versions[len(versions)-1].DeleteMarker {
+			return nil
+		}
+		return versions[len(versions)-1]
+	}
+	for _, v := range versions {
+		if v.VersionID == aws.StringValue(versionID) && !v.DeleteMarker {
+			return v
+		}
+	}
+	return nil
+}
+
+// DeleteObjects invokes the mock method if it is set, otherwise
+// it will add delete markers in default mock implementation.
+// Versions with version ID are deleted permanently.
+func (c *MockS3API) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	if c.MockDeleteObjects != nil {
+		return c.MockDeleteObjects(in)
+	}
+	if len(in.Delete.Objects) > 1000 {
+		return nil, awserr.New("MalformedXML", "The XML you provided was not well-formed", nil)
+	}
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	out := &s3.DeleteObjectsOutput{}
+	for _, obj := range in.Delete.Objects {
+		path := objectPath(in.Bucket, obj.Key)
+		if obj.VersionId != nil {
+			versions := c.objects[path]
+			for i, v := range versions {
+				if v.VersionID == aws.StringValue(obj.VersionId) {
+					versions = append(versions[:i:i], versions[i+1:]...)
+					break
+				}
+			}
+			if len(versions) == 0 {
+				delete(c.objects, path)
+			} else {
+				c.objects[path] = versions
+			}
+			out.Deleted = append(out.Deleted, &s3.DeletedObject{Key: obj.Key, VersionId: obj.VersionId})
+			continue
+		}
+		if c.Unversioned {
+			delete(c.objects, path)
+			out.Deleted = append(out.Deleted, &s3.DeletedObject{Key: obj.Key})
+			continue
+		}
+		c.version++
+		marker := &MockS3Object{
+			VersionID:    "v" + strconv.Itoa(c.version),
+			DeleteMarker: true,
+		}
+		c.objects[path] = append(c.objects[path], marker)
+		out.Deleted = append(out.Deleted, &s3.DeletedObject{
+			Key:                   obj.Key,
+			DeleteMarker:          aws.Bool(true),
+			DeleteMarkerVersionId: aws.String(marker.VersionID),
+		})
+	}
+	return out, nil
+}
+
+// PutObject invokes the mock method if it is set,
+// otherwise it will store object in default mock implementation.
+func (c *MockS3API) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if c.MockPutObject != nil {
+		return c.MockPutObject(in)
+	}
+	c.objectsLock.Lock()
+	c.putCalls++
+	c.objectsLock.Unlock()
+	var body []byte
+	if in.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(in.Body); err != nil {
+			return nil, err
+		}
+	}
+	obj := c.putObject(aws.StringValue(in.Bucket), aws.StringValue(in.Key), &MockS3Object{
+		Body:                 body,
+		ContentType:          aws.StringValue(in.ContentType),
+		CacheControl:         aws.StringValue(in.CacheControl),
+		ContentEncoding:      aws.StringValue(in.ContentEncoding),
+		ServerSideEncryption: aws.StringValue(in.ServerSideEncryption),
+		SSEKMSKeyID:          aws.StringValue(in.SSEKMSKeyId),
+		ACL:                  aws.StringValue(in.ACL),
+		Tagging:              aws.StringValue(in.Tagging),
+		Metadata:             aws.StringValueMap(in.Metadata),
+	})
+	return &s3.PutObjectOutput{
+		ETag:      aws.String(obj.ETag),
+		VersionId: versionID(obj),
+	}, nil
+}
+
+// HeadObject returns the object attributes from default mock implementation.
+func (c *MockS3API) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	c.objectsLock.Lock()
+	c.headCalls++
+	c.objectsLock.Unlock()
+	obj := c.getObject(in.Bucket, in.Key, in.VersionId)
+	if obj == nil {
+		return nil, noSuchKey("NotFound")
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(int64(len(obj.Body))),
+		ContentType:          aws.String(obj.ContentType),
+		CacheControl:         optionalString(obj.CacheControl),
+		ContentEncoding:      optionalString(obj.ContentEncoding),
+		ServerSideEncryption: optionalString(obj.ServerSideEncryption),
+		SSEKMSKeyId:          optionalString(obj.SSEKMSKeyID),
+		ETag:                 aws.String(obj.ETag),
+		Metadata:             aws.StringMap(obj.Metadata),
+		VersionId:            versionID(obj),
+	}, nil
+}
+
+// GetObject invokes the mock method if it is set,
+// otherwise it will return object from default mock implementation.
+func (c *MockS3API) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if c.MockGetObject != nil {
+		return c.MockGetObject(in)
+	}
+	obj := c.getObject(in.Bucket, in.Key, in.VersionId)
+	if obj == nil {
+		return nil, noSuchKey(s3.ErrCodeNoSuchKey)
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(obj.Body)),
+		ContentLength: aws.Int64(int64(len(obj.Body))),
+		ContentType:   aws.String(obj.ContentType),
+		ETag:          aws.String(obj.ETag),
+		Metadata:      aws.StringMap(obj.Metadata),
+		VersionId:     versionID(obj),
+	}, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// ListObjectsV2Pages calls fn with single page of latest versions
+// of objects from default mock implementation.
+func (c *MockS3API) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	c.objectsLock.Lock()
+	c.listCalls++
+	prefix := aws.StringValue(in.Bucket) + "/" + aws.StringValue(in.Prefix)
+	paths := make([]string, 0, len(c.objects))
+	for path, versions := range c.objects {
+		if strings.HasPrefix(path, prefix) && len(versions) > 0 && !versions[len(versions)-1].DeleteMarker {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	out := &s3.ListObjectsV2Output{}
+	for _, path := range paths {
+		obj := c.objects[path][len(c.objects[path])-1]
+		out.Contents = append(out.Contents, &s3.Object{
+			Key:  aws.String(strings.TrimPrefix(path, aws.StringValue(in.Bucket)+"/")),
+			ETag: aws.String(obj.ETag),
+			Size: aws.Int64(int64(len(obj.Body))),
+		})
+	}
+	c.objectsLock.Unlock()
+	fn(out, true)
+	return nil
+}
+
+// ListObjectVersionsPages calls fn with single page of all versions
+// and delete markers of objects from default mock implementation.
+func (c *MockS3API) ListObjectVersionsPages(in *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	c.objectsLock.Lock()
+	c.listVersionsCalls++
+	prefix := aws.StringValue(in.Bucket) + "/" + aws.StringValue(in.Prefix)
+	paths := make([]string, 0, len(c.objects))
+	for path := range c.objects {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	out := &s3.ListObjectVersionsOutput{}
+	for _, path := range paths {
+		versions := c.objects[path]
+		key := aws.String(strings.TrimPrefix(path, aws.StringValue(in.Bucket)+"/"))
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			latest := aws.Bool(i == len(versions)-1)
+			if v.DeleteMarker {
+				out.DeleteMarkers = append(out.DeleteMarkers, &s3.DeleteMarkerEntry{
+					Key:       key,
+					VersionId: aws.String(v.VersionID),
+					IsLatest:  latest,
+				})
+				continue
+			}
+			out.Versions = append(out.Versions, &s3.ObjectVersion{
+				Key:       key,
+				VersionId: aws.String(v.VersionID),
+				ETag:      aws.String(v.ETag),
+				Size:      aws.Int64(int64(len(v.Body))),
+				IsLatest:  latest,
+			})
+		}
+	}
+	c.objectsLock.Unlock()
+	fn(out, true)
+	return nil
+}
+
+// Calls returns the number of HeadObject, PutObject, ListObjectsV2
+// and ListObjectVersions calls of default mock implementation.
+func (c *MockS3API) Calls() (head, put, list, listVersions int) {
+	c.objectsLock.Lock()
+	defer c.objectsLock.Unlock()
+	return c.headCalls, c.putCalls, c.listCalls, c.listVersionsCalls
+}
@@ -0,0 +1,57 @@
+package mock
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// MockSecretsManagerAPI is the in-memory mock for AWS Secrets Manager API.
+type MockSecretsManagerAPI struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	secretsLock sync.Mutex
+	secrets     map[string]string
+
+	// MockGetSecretValue can be used to mock the call to GetSecretValue API.
+	MockGetSecretValue func(*secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// NewMockSecretsManagerAPI creates new mock of Secrets Manager API.
+func NewMockSecretsManagerAPI() *MockSecretsManagerAPI {
+	return &MockSecretsManagerAPI{
+		secrets: make(map[string]string),
+	}
+}
+
+// AddSecrets adds new secrets with string values to mock implementation.
+func (c *MockSecretsManagerAPI) AddSecrets(secrets map[string]string) {
+	c.secretsLock.Lock()
+	defer c.secretsLock.Unlock()
+	for id, value := range secrets {
+		c.secrets[id] = value
+	}
+}
+
+// GetSecretValue invokes the mock method if it is set,
+// otherwise it will return secret from default mock implementation.
+func (c *MockSecretsManagerAPI) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	if c.MockGetSecretValue != nil {
+		return c.MockGetSecretValue(in)
+	}
+	c.secretsLock.Lock()
+	defer c.secretsLock.Unlock()
+	id := aws.StringValue(in.SecretId)
+	value, ok := c.secrets[id]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, fmt.Sprintf("Secrets Manager can't find the specified secret %s.", id), nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		Name:         aws.String(id),
+		SecretString: aws.String(value),
+	}, nil
+}
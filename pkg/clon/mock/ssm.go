@@ -0,0 +1,121 @@
+package mock
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// MockSSMAPI is the in-memory mock for AWS SSM Parameter Store API.
+type MockSSMAPI struct {
+	ssmiface.SSMAPI
+
+	parametersLock sync.Mutex
+	parameters     map[string]*ssm.Parameter
+
+	// MockGetParameter can be used to mock the call to GetParameter API.
+	MockGetParameter func(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+
+	// MockPutParameter can be used to mock the call to PutParameter API.
+	MockPutParameter func(*ssm.PutParameterInput) (*ssm.PutParameterOutput, error)
+
+	// MockDeleteParameter can be used to mock the call to DeleteParameter API.
+	MockDeleteParameter func(*ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error)
+}
+
+// NewMockSSMAPI creates new mock of SSM API.
+func NewMockSSMAPI() *MockSSMAPI {
+	return &MockSSMAPI{
+		parameters: make(map[string]*ssm.Parameter),
+	}
+}
+
+// AddParameters adds new parameters of String type to mock implementation.
+func (c *MockSSMAPI) AddParameters(params map[string]string) {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	for name, value := range params {
+		c.parameters[name] = &ssm.Parameter{
+			Name:    aws.String(name),
+			Type:    aws.String(ssm.ParameterTypeString),
+			Value:   aws.String(value),
+			Version: aws.Int64(1),
+		}
+	}
+}
+
+// Parameters returns the values of parameters stored in mock implementation.
+func (c *MockSSMAPI) Parameters() map[string]string {
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	res := make(map[string]string, len(c.parameters))
+	for name, p := range c.parameters {
+		res[name] = aws.StringValue(p.Value)
+	}
+	return res
+}
+
+func parameterNotFound(name string) error {
+	return awserr.New(ssm.ErrCodeParameterNotFound, fmt.Sprintf("Parameter %s not found.", name), nil)
+}
+
+// GetParameter invokes the mock method if it is set,
+// otherwise it will return parameter from default mock implementation.
+func (c *MockSSMAPI) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if c.MockGetParameter != nil {
+		return c.MockGetParameter(in)
+	}
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	p, ok := c.parameters[aws.StringValue(in.Name)]
+	if !ok {
+		return nil, parameterNotFound(aws.StringValue(in.Name))
+	}
+	out := *p
+	return &ssm.GetParameterOutput{Parameter: &out}, nil
+}
+
+// PutParameter invokes the mock method if it is set,
+// otherwise it will store parameter in default mock implementation.
+func (c *MockSSMAPI) PutParameter(in *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	if c.MockPutParameter != nil {
+		return c.MockPutParameter(in)
+	}
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	name := aws.StringValue(in.Name)
+	version := int64(1)
+	if p, ok := c.parameters[name]; ok {
+		if !aws.BoolValue(in.Overwrite) {
+			return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, "The parameter already exists.", nil)
+		}
+		version = aws.Int64Value(p.Version) + 1
+	}
+	c.parameters[name] = &ssm.Parameter{
+		Name:    aws.String(name),
+		Type:    in.Type,
+		Value:   in.Value,
+		Version: aws.Int64(version),
+	}
+	return &ssm.PutParameterOutput{Version: aws.Int64(version)}, nil
+}
+
+// DeleteParameter invokes the mock method if it is set,
+// otherwise it will delete parameter from default mock implementation.
+func (c *MockSSMAPI) DeleteParameter(in *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	if c.MockDeleteParameter != nil {
+		return c.MockDeleteParameter(in)
+	}
+	c.parametersLock.Lock()
+	defer c.parametersLock.Unlock()
+	name := aws.StringValue(in.Name)
+	if _, ok := c.parameters[name]; !ok {
+		return nil, parameterNotFound(name)
+	}
+	delete(c.parameters, name)
+	return &ssm.DeleteParameterOutput{}, nil
+}
@@ -0,0 +1,175 @@
+package clon
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/juju/errors"
+)
+
+// Name mangling styles of environment variables.
+const (
+	// MangleNone keeps the names, only characters, which are not
+	// allowed in environment variable names, are replaced by '_'.
+	MangleNone = "none"
+
+	// MangleUpper converts the names to upper case.
+	MangleUpper = "upper"
+
+	// MangleSnake converts the camel case names to upper
+	// snake case, e.g. VpcId to VPC_ID.
+	MangleSnake = "snake"
+)
+
+// StackOutputs is the outputs of single stack.
+type StackOutputs struct {
+	// Stack is the config name of the stack.
+	Stack string
+
+	// Outputs is the map of output keys to values.
+	Outputs map[string]string
+}
+
+// EnvVar is the environment variable.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Outputs returns the outputs of stack. If dependencies is set,
+// the outputs of stacks, which the stack depends on directly or
+// indirectly, precede the outputs of the stack. Dependencies,
+// which do not exist, are skipped with warning.
+func (sm *StackManager) Outputs(name string, dependencies bool) ([]*StackOutputs, error) {
+	sd, err := sm.Get(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if sd.ID == "" {
+		return nil, errors.NotFoundf("stack '%s'", name)
+	}
+	var res []*StackOutputs
+	if dependencies {
+		visited := map[string]bool{name: true}
+		if err = sm.dependencyOutputs(name, visited, &res); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return append(res, &StackOutputs{Stack: name, Outputs: sd.Outputs}), nil
+}
+
+// dependencyOutputs appends the outputs of dependencies of
+// stack to res in dependency order.
+func (sm *StackManager) dependencyOutputs(name string, visited map[string]bool, res *[]*StackOutputs) error {
+	s, stackConfig, err := sm.getStack(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	deps, err := sm.stackDependencies(s, stackConfig)
+	if err != nil {
+		return errors.Annotatef(err, "cannot read dependencies of stack '%s'", name)
+	}
+	for _, dep := range deps {
+		if visited[dep] {
+			continue
+		}
+		visited[dep] = true
+		if err = sm.dependencyOutputs(dep, visited, res); err != nil {
+			return errors.Trace(err)
+		}
+		sd, err := sm.Get(dep)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if sd.ID == "" {
+			sm.warn(name, "dependency '%s' does not exist, outputs are skipped", dep)
+			continue
+		}
+		*res = append(*res, &StackOutputs{Stack: dep, Outputs: sd.Outputs})
+	}
+	return nil
+}
+
+// OutputsEnv returns the environment variables of outputs sorted
+// by name. Names of outputs of the last stack are composed of prefix
+// and output key, other outputs are qualified with stack name. If
+// names collide, the later value wins.
+func OutputsEnv(outputs []*StackOutputs, prefix, mangle string) ([]*EnvVar, error) {
+	vars := make(map[string]string)
+	for i, o := range outputs {
+		qualifier := ""
+		if i < len(outputs)-1 {
+			q, err := mangleName(o.Stack, mangle)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			qualifier = q + "_"
+		}
+		for k, v := range o.Outputs {
+			name, err := mangleName(k, mangle)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			vars[envName(prefix+qualifier+name)] = v
+		}
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]*EnvVar, 0, len(names))
+	for _, name := range names {
+		res = append(res, &EnvVar{Name: name, Value: vars[name]})
+	}
+	return res, nil
+}
+
+// mangleName converts the name using mangle style.
+func mangleName(name, mangle string) (string, error) {
+	switch mangle {
+	case MangleNone, "":
+		return name, nil
+	case MangleUpper:
+		return strings.ToUpper(name), nil
+	case MangleSnake:
+		return snakeCase(name), nil
+	}
+	return "", errors.Errorf("unknown name mangling '%s', allowed values are %s, %s, %s", mangle, MangleNone, MangleUpper, MangleSnake)
+}
+
+// snakeCase converts the camel case name to upper snake case.
+// Acronyms are kept together, e.g. DBEndpointURL is converted
+// to DB_ENDPOINT_URL.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteRune('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// envName replaces the characters, which are not allowed in
+// environment variable names, by '_'.
+func envName(name string) string {
+	res := []byte(name)
+	for i, c := range res {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			res[i] = '_'
+		}
+	}
+	return string(res)
+}
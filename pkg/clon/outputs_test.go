@@ -0,0 +1,113 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func TestSnakeCase(t *testing.T) {
+	require := require.New(t)
+
+	for in, out := range map[string]string{
+		"VpcId":         "VPC_ID",
+		"DBEndpointURL": "DB_ENDPOINT_URL",
+		"Subnet1Id":     "SUBNET1_ID",
+		"api-gw":        "API_GW",
+		"URL":           "URL",
+		"vpc":           "VPC",
+	} {
+		require.Equal(out, snakeCase(in), in)
+	}
+}
+
+func TestOutputsEnv(t *testing.T) {
+	require := require.New(t)
+
+	outputs := []*StackOutputs{
+		{Stack: "base-network", Outputs: map[string]string{"VpcId": "vpc-1"}},
+		{Stack: "app", Outputs: map[string]string{"ApiURL": "https://app", "1st": "a"}},
+	}
+	vars, err := OutputsEnv(outputs, "", MangleNone)
+	require.Nil(err)
+	require.Equal([]*EnvVar{
+		{Name: "ApiURL", Value: "https://app"},
+		{Name: "_st", Value: "a"},
+		{Name: "base_network_VpcId", Value: "vpc-1"},
+	}, vars)
+
+	vars, err = OutputsEnv(outputs, "APP_", MangleSnake)
+	require.Nil(err)
+	require.Equal([]*EnvVar{
+		{Name: "APP_1ST", Value: "a"},
+		{Name: "APP_API_URL", Value: "https://app"},
+		{Name: "APP_BASE_NETWORK_VPC_ID", Value: "vpc-1"},
+	}, vars)
+
+	vars, err = OutputsEnv(outputs[1:], "", MangleUpper)
+	require.Nil(err)
+	require.Equal([]*EnvVar{
+		{Name: "APIURL", Value: "https://app"},
+		{Name: "_ST", Value: "a"},
+	}, vars)
+
+	_, err = OutputsEnv(outputs, "", "camel")
+	require.NotNil(err)
+}
+
+func TestStackManager_outputs(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "network"},
+		StackConfig{Name: "roles"},
+		StackConfig{Name: "db", Parameters: map[string]string{"Vpc": `{{ (stack "network").Outputs.VpcId }}`}},
+		StackConfig{Name: "app", DependsOn: []string{"db", "roles"}},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	var stacks []*cloudformation.Stack
+	for name, outputs := range map[string]map[string]string{
+		"network": {"VpcId": "vpc-1"},
+		"db":      {"Endpoint": "db.local"},
+		"app":     {"Url": "https://app"},
+	} {
+		s := &cloudformation.Stack{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-" + name + "/1"),
+			StackName:   aws.String("test-" + name),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+		}
+		for k, v := range outputs {
+			s.Outputs = append(s.Outputs, &cloudformation.Output{OutputKey: aws.String(k), OutputValue: aws.String(v)})
+		}
+		stacks = append(stacks, s)
+	}
+	cfnconn.AddStacks(stacks)
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	var warnings []*Warning
+	sm.SetEventHandler(func(e interface{}) {
+		if w, ok := e.(*Warning); ok {
+			warnings = append(warnings, w)
+		}
+	})
+
+	outputs, err := sm.Outputs("app", false)
+	require.Nil(err)
+	require.Equal([]*StackOutputs{{Stack: "app", Outputs: map[string]string{"Url": "https://app"}}}, outputs)
+
+	outputs, err = sm.Outputs("app", true)
+	require.Nil(err)
+	require.Equal([]*StackOutputs{
+		{Stack: "network", Outputs: map[string]string{"VpcId": "vpc-1"}},
+		{Stack: "db", Outputs: map[string]string{"Endpoint": "db.local"}},
+		{Stack: "app", Outputs: map[string]string{"Url": "https://app"}},
+	}, outputs)
+	require.Equal([]*Warning{{Stack: "app", Message: "dependency 'roles' does not exist, outputs are skipped"}}, warnings)
+
+	_, err = sm.Outputs("roles", false)
+	require.NotNil(err)
+}
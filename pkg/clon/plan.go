@@ -1,6 +1,7 @@
 package clon
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -32,6 +33,12 @@ func (d DiffString) IsEqual() bool {
 	return d.old == d.new
 }
 
+// MarshalJSON encodes the diff as object with
+// Old and New values.
+func (d DiffString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Old, New string }{d.old, d.new})
+}
+
 // DiffStringMap is map of string diffs.
 type DiffStringMap map[string]DiffString
 
@@ -72,6 +79,14 @@ type Plan struct {
 	RoleARN    DiffString
 	Parameters DiffStringMap
 	HasChange  bool
+
+	// SSMParameters is the diff of SSM parameters exported
+	// from stack outputs.
+	SSMParameters DiffStringMap
+
+	// Policies is the list of policies matched by
+	// resource changes.
+	Policies []*PolicyResult
 }
 
 func newPlan(cs *cfn.ChangeSetData, stack *StackData, ignoreNestedUpdates bool) (*Plan, error) {
@@ -0,0 +1,179 @@
+package clon
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// Effects of policies.
+const (
+	PolicyDeny    = "deny"
+	PolicyWarn    = "warn"
+	PolicyConfirm = "confirm"
+)
+
+// PolicyResult is the policy, which matched the resource change.
+type PolicyResult struct {
+	// Policy is the name of matched policy.
+	Policy string
+
+	// Effect is the effect of matched policy.
+	Effect string
+
+	// Message is the message of matched policy.
+	Message string
+
+	// LogicalID is the logical ID of changed resource.
+	LogicalID string
+
+	// ResourceType is the type of changed resource.
+	ResourceType string
+
+	// Action is the action of change.
+	Action string
+
+	// Replacement is the replacement of modified resource.
+	Replacement string
+}
+
+// String returns the description of the matched change.
+func (r *PolicyResult) String() string {
+	action := r.Action
+	if r.Replacement == cloudformation.ReplacementTrue {
+		action = "Replace"
+	} else if r.Replacement == cloudformation.ReplacementConditional {
+		action = "Conditional replace"
+	}
+	res := fmt.Sprintf("%s of %s (%s) matches policy '%s'", action, r.LogicalID, r.ResourceType, r.Policy)
+	if r.Message != "" {
+		res += ": " + r.Message
+	}
+	return res
+}
+
+// PolicyResults returns the results of policies with
+// given effect.
+func (p *Plan) PolicyResults(effect string) []*PolicyResult {
+	var res []*PolicyResult
+	for _, r := range p.Policies {
+		if r.Effect == effect {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// validate returns the list of validation errors of policy.
+func (p PolicyConfig) validate() []string {
+	var res []string
+	if p.Name == "" {
+		res = append(res, "Name is required")
+	}
+	if p.Effect == "" {
+		res = append(res, "Effect is required")
+	}
+	for _, patterns := range [][]string{p.ResourceTypes, p.LogicalIDs, p.Properties} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				res = append(res, fmt.Sprintf("invalid pattern '%s'", pattern))
+			}
+		}
+	}
+	return res
+}
+
+// matchesAny reports whether value matches any of glob
+// patterns. Empty list of patterns matches any value.
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny reports whether value is one of values.
+// Empty list of values contains any value.
+func containsAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the policy matches the resource change.
+func (p PolicyConfig) matches(c *cloudformation.ResourceChange) bool {
+	if !matchesAny(p.ResourceTypes, aws.StringValue(c.ResourceType)) ||
+		!matchesAny(p.LogicalIDs, aws.StringValue(c.LogicalResourceId)) ||
+		!containsAny(p.Actions, aws.StringValue(c.Action)) {
+		return false
+	}
+	if len(p.Replacement) > 0 && !containsAny(p.Replacement, aws.StringValue(c.Replacement)) {
+		return false
+	}
+	if len(p.Properties) == 0 {
+		return true
+	}
+	for _, d := range c.Details {
+		if d.Target == nil || aws.StringValue(d.Target.Attribute) != cloudformation.ResourceAttributeProperties {
+			continue
+		}
+		if matchesAny(p.Properties, aws.StringValue(d.Target.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluatePolicies returns the results of global and stack
+// policies, which match the resource changes of plan.
+func (sm *StackManager) evaluatePolicies(stackConfig *StackConfig, changes []*cloudformation.ResourceChange) []*PolicyResult {
+	policies := append(append([]PolicyConfig{}, sm.config.Policies...), stackConfig.Policies...)
+	var res []*PolicyResult
+	for _, c := range changes {
+		for _, p := range policies {
+			if !p.matches(c) {
+				continue
+			}
+			res = append(res, &PolicyResult{
+				Policy:       p.Name,
+				Effect:       p.Effect,
+				Message:      p.Message,
+				LogicalID:    aws.StringValue(c.LogicalResourceId),
+				ResourceType: aws.StringValue(c.ResourceType),
+				Action:       aws.StringValue(c.Action),
+				Replacement:  aws.StringValue(c.Replacement),
+			})
+		}
+	}
+	return res
+}
+
+// CheckPolicies verifies, that the plan does not contain
+// changes denied by policies.
+func (p *Plan) CheckPolicies() error {
+	denied := p.PolicyResults(PolicyDeny)
+	if len(denied) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(denied))
+	for _, r := range denied {
+		msgs = append(msgs, r.String())
+	}
+	return errors.Errorf("plan of stack '%s' is denied by policies:\n  %s", p.Stack.ConfigName, strings.Join(msgs, "\n  "))
+}
@@ -0,0 +1,133 @@
+package clon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func newTestResourceChange(action, logicalID, resourceType, replacement string, properties ...string) *cloudformation.ResourceChange {
+	c := &cloudformation.ResourceChange{
+		Action:            aws.String(action),
+		LogicalResourceId: aws.String(logicalID),
+		ResourceType:      aws.String(resourceType),
+	}
+	if replacement != "" {
+		c.Replacement = aws.String(replacement)
+	}
+	for _, p := range properties {
+		c.Details = append(c.Details, &cloudformation.ResourceChangeDetail{
+			Target: &cloudformation.ResourceTargetDefinition{
+				Attribute: aws.String(cloudformation.ResourceAttributeProperties),
+				Name:      aws.String(p),
+			},
+		})
+	}
+	return c
+}
+
+func TestPolicyConfig_matches(t *testing.T) {
+	require := require.New(t)
+
+	db := newTestResourceChange("Modify", "OrdersDB", "AWS::RDS::DBInstance", "True", "DBInstanceClass", "Tags")
+	table := newTestResourceChange("Remove", "OrdersTable", "AWS::DynamoDB::Table", "")
+	bucket := newTestResourceChange("Add", "Assets", "AWS::S3::Bucket", "")
+
+	var inputs = []struct {
+		policy  PolicyConfig
+		matches []bool
+	}{
+		{PolicyConfig{}, []bool{true, true, true}},
+		{PolicyConfig{ResourceTypes: []string{"AWS::RDS::*", "AWS::DynamoDB::Table"}}, []bool{true, true, false}},
+		{PolicyConfig{LogicalIDs: []string{"Orders*"}, Actions: []string{"Remove"}}, []bool{false, true, false}},
+		{PolicyConfig{Replacement: []string{"True", "Conditional"}}, []bool{true, false, false}},
+		{PolicyConfig{Properties: []string{"DBInstance*"}}, []bool{true, false, false}},
+		{PolicyConfig{Properties: []string{"Engine"}}, []bool{false, false, false}},
+		{PolicyConfig{Actions: []string{"Add", "Modify"}, ResourceTypes: []string{"AWS::S3::*"}}, []bool{false, false, true}},
+	}
+	for i, input := range inputs {
+		for j, c := range []*cloudformation.ResourceChange{db, table, bucket} {
+			require.Equal(input.matches[j], input.policy.matches(c), "policy %d, change %d", i, j)
+		}
+	}
+}
+
+func TestStackManager_evaluatePolicies(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t, StackConfig{
+		Name: "db",
+		Policies: []PolicyConfig{
+			{Name: "tables", Effect: PolicyConfirm, ResourceTypes: []string{"AWS::DynamoDB::Table"}},
+		},
+	})
+	defer cleanup()
+	config.Policies = []PolicyConfig{
+		{Name: "no-db-replacement", Effect: PolicyDeny, Message: "databases must not be replaced",
+			ResourceTypes: []string{"AWS::RDS::*"}, Replacement: []string{"True", "Conditional"}},
+		{Name: "removals", Effect: PolicyWarn, Actions: []string{"Remove"}},
+	}
+	awsClient, _ := newTestAWSClient()
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	_, stackConfig, err := sm.getStack("db")
+	require.Nil(err)
+	changes := []*cloudformation.ResourceChange{
+		newTestResourceChange("Modify", "OrdersDB", "AWS::RDS::DBInstance", "Conditional"),
+		newTestResourceChange("Remove", "OrdersTable", "AWS::DynamoDB::Table", ""),
+		newTestResourceChange("Modify", "Assets", "AWS::S3::Bucket", "False"),
+	}
+	plan := &Plan{
+		Stack:    &StackData{ConfigName: "db"},
+		Policies: sm.evaluatePolicies(stackConfig, changes),
+	}
+	require.Equal([]*PolicyResult{
+		{Policy: "no-db-replacement", Effect: PolicyDeny, Message: "databases must not be replaced",
+			LogicalID: "OrdersDB", ResourceType: "AWS::RDS::DBInstance", Action: "Modify", Replacement: "Conditional"},
+		{Policy: "removals", Effect: PolicyWarn, LogicalID: "OrdersTable", ResourceType: "AWS::DynamoDB::Table", Action: "Remove"},
+		{Policy: "tables", Effect: PolicyConfirm, LogicalID: "OrdersTable", ResourceType: "AWS::DynamoDB::Table", Action: "Remove"},
+	}, plan.Policies)
+	require.Len(plan.PolicyResults(PolicyConfirm), 1)
+
+	err = plan.CheckPolicies()
+	require.NotNil(err)
+	require.Equal("plan of stack 'db' is denied by policies:\n"+
+		"  Conditional replace of OrdersDB (AWS::RDS::DBInstance) matches policy 'no-db-replacement': databases must not be replaced",
+		err.Error())
+
+	plan.Policies = sm.evaluatePolicies(stackConfig, changes[1:])
+	require.Nil(plan.CheckPolicies())
+}
+
+func TestDecodeConfig_policies(t *testing.T) {
+	require := require.New(t)
+
+	c, err := DecodeConfig(strings.NewReader(`
+Name: app
+Bootstrap:
+  Template: bootstrap.yml
+Policies:
+  - Effect: deny
+    ResourceTypes: ["AWS::RDS::["]
+Stacks:
+  - Name: db
+    Template: db.yml
+    Policies:
+      - Name: tables
+`), "clon.yml")
+	require.Nil(err)
+	err = c.Validate()
+	require.NotNil(err)
+	require.Equal(`clon.yml:12:9: Stacks[0].Policies[0]: Effect is required
+clon.yml:6:5: Policies[0]: Name is required
+clon.yml:6:5: Policies[0]: invalid pattern 'AWS::RDS::['`, err.Error())
+
+	_, err = DecodeConfig(strings.NewReader("Policies:\n  - Name: a\n    Effect: block\n"), "clon.yml")
+	require.NotNil(err)
+	require.Contains(err.Error(), "clon.yml:3:13: Policies[0].Effect: invalid value 'block', allowed values are deny, warn, confirm")
+}
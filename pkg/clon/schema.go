@@ -0,0 +1,83 @@
+package clon
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/juju/errors"
+)
+
+// JSONSchema returns the JSON Schema of config file, which can be
+// used by editors for completion and validation of config files.
+func JSONSchema() ([]byte, error) {
+	g := &schemaGenerator{definitions: make(map[string]interface{})}
+	schema := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "clon config",
+		"$ref":        g.schema(reflect.TypeOf(Config{}))["$ref"],
+		"definitions": g.definitions,
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(data, '\n'), nil
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// schema returns the schema of type t. Structs are added to
+// definitions and referenced by name.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+		if _, ok := g.definitions[t.Name()]; ok {
+			return ref
+		}
+		props := make(map[string]interface{})
+		def := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		g.definitions[t.Name()] = def
+		for _, f := range configFields(t) {
+			s := g.schema(f.Type)
+			if enum := schemaEnum(f); enum != nil {
+				if items, ok := s["items"].(map[string]interface{}); ok {
+					items["enum"] = enum
+				} else {
+					s["enum"] = enum
+				}
+			}
+			props[f.Name] = s
+		}
+		return ref
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.schema(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.schema(t.Elem()),
+		}
+	case reflect.String:
+		// scalars are converted to strings on decode
+		return map[string]interface{}{"type": []string{"string", "number", "boolean"}}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
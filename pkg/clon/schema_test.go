@@ -0,0 +1,20 @@
+package clon
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSchema(t *testing.T) {
+	require := require.New(t)
+
+	schema, err := JSONSchema()
+	require.Nil(err)
+
+	// published schema must be regenerated with 'make schema'
+	published, err := ioutil.ReadFile("../../clon.schema.json")
+	require.Nil(err)
+	require.Equal(string(published), string(schema))
+}
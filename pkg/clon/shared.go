@@ -0,0 +1,234 @@
+package clon
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/spirius/clon/pkg/s3file"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// sharedPrefix is the prefix of shared values in bootstrap bucket.
+const sharedPrefix = "shared/"
+
+// sharedKeyRegexp matches the valid keys of shared values.
+var sharedKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9][-a-zA-Z0-9_.]*(/[a-zA-Z0-9][-a-zA-Z0-9_.]*)*$`)
+
+// SharedValue is the value published by stack into the shared
+// value store in bootstrap bucket.
+type SharedValue struct {
+	// Key is the key of shared value.
+	Key string
+
+	// Value is the published value.
+	Value string
+
+	// Stack is the config name of publishing stack.
+	Stack string
+
+	// Output is the name of published stack output.
+	Output string
+
+	// VersionID is the S3 object version of the value.
+	VersionID string `json:"-"`
+}
+
+// renderPublish renders the Publish keys of stack config with .Each
+// context of expanded stacks and verifies, that keys are valid and
+// not published by other stacks. The publishers is the map of
+// already published keys to stack names.
+func renderPublish(sc *StackConfig, ctx, funcs map[string]interface{}, publishers map[string]string) error {
+	if len(sc.Publish) == 0 {
+		return nil
+	}
+	if sc.each != nil {
+		c := make(map[string]interface{}, len(ctx)+1)
+		for k, v := range ctx {
+			c[k] = v
+		}
+		c["Each"] = sc.each
+		ctx = c
+	}
+	publish := make(map[string]string, len(sc.Publish))
+	for key, output := range sc.Publish {
+		k, err := renderTemplate(key, ctx, funcs)
+		if err != nil {
+			return errors.Annotatef(err, "cannot render Publish key '%s' of stack '%s'", key, sc.Name)
+		}
+		if !sharedKeyRegexp.MatchString(k) {
+			return errors.Errorf("stack '%s' has invalid Publish key '%s'", sc.Name, k)
+		}
+		if other, ok := publishers[k]; ok {
+			return errors.Errorf("stack '%s' publishes key '%s', which is already published by stack '%s'", sc.Name, k, other)
+		}
+		publishers[k] = sc.Name
+		publish[k] = output
+	}
+	sc.Publish = publish
+	return nil
+}
+
+// getBucket returns the bootstrap bucket. If bucket is not
+// set, it is read from outputs of bootstrap stack.
+func (sm *StackManager) getBucket() (string, error) {
+	if sm.bucket != "" {
+		return sm.bucket, nil
+	}
+	bootstrap, _, err := sm.getStack(sm.config.RootStack)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot read bootstrap stack")
+	}
+	data := bootstrap.stackData()
+	if data == nil || data.Outputs["Bucket"] == "" {
+		return "", errors.Errorf("bootstrap stack is not initialized, 'Bucket' output is not found")
+	}
+	return data.Outputs["Bucket"], nil
+}
+
+// publish writes the outputs listed in Publish of stack into the
+// shared value store. Unchanged values do not create new versions.
+func (sm *StackManager) publish(s *stack, stackConfig *StackConfig) error {
+	if len(stackConfig.Publish) == 0 {
+		return nil
+	}
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	kmsKeyID, err := sm.kmsKeyID()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data := s.stackData()
+	keys := make([]string, 0, len(stackConfig.Publish))
+	for key := range stackConfig.Publish {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		output := stackConfig.Publish[key]
+		value, ok := data.Outputs[output]
+		if !ok {
+			return errors.Errorf("cannot publish '%s', output '%s' of stack '%s' not found", key, output, s.configName)
+		}
+		v := &SharedValue{Key: key, Value: value, Stack: s.configName, Output: output}
+		content, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return errors.Annotatef(err, "cannot encode shared value '%s'", key)
+		}
+		f, err := s3file.Write(sm.awsClient.s3conn, s3file.Config{
+			Region:      sm.awsClient.region,
+			Bucket:      bucket,
+			Prefix:      sharedPrefix,
+			Key:         key + ".json",
+			Content:     bytes.NewReader(content),
+			ContentType: "application/json",
+			SSEKMSKeyID: kmsKeyID,
+		})
+		if err != nil {
+			return errors.Annotatef(err, "cannot publish '%s'", key)
+		}
+		v.VersionID = f.VersionID
+		sm.shared[key] = v
+		sm.emit(v)
+	}
+	return nil
+}
+
+// readShared reads the shared value from bootstrap bucket.
+func (sm *StackManager) readShared(key string) (*SharedValue, error) {
+	if !sharedKeyRegexp.MatchString(key) {
+		return nil, errors.Errorf("invalid shared value key '%s'", key)
+	}
+	if v, ok := sm.shared[key]; ok {
+		return v, nil
+	}
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	f, err := s3file.Read(sm.awsClient.s3conn, s3file.Config{
+		Region: sm.awsClient.region,
+		Bucket: bucket,
+		Prefix: sharedPrefix,
+		Key:    key + ".json",
+	})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.NotFoundf("shared value '%s'", key)
+		}
+		return nil, errors.Annotatef(err, "cannot read shared value '%s'", key)
+	}
+	defer f.Body.Close()
+	v := &SharedValue{}
+	if err = json.NewDecoder(f.Body).Decode(v); err != nil {
+		return nil, errors.Annotatef(err, "cannot decode shared value '%s'", key)
+	}
+	v.Key = key
+	v.VersionID = f.VersionID
+	sm.shared[key] = v
+	return v, nil
+}
+
+// tplShared is function exposed to template engine with name
+// 'shared'. It returns the shared value. If value does not
+// exist and default is specified, the default is returned.
+func (sm *StackManager) tplShared(key string, def ...interface{}) (interface{}, error) {
+	if len(def) > 1 {
+		return nil, errors.Errorf("shared accepts at most one default value")
+	}
+	v, err := sm.readShared(key)
+	if err != nil {
+		if errors.IsNotFound(err) && len(def) == 1 {
+			return def[0], nil
+		}
+		return nil, errors.Trace(err)
+	}
+	return v.Value, nil
+}
+
+// GetShared returns the shared value by key.
+func (sm *StackManager) GetShared(key string) (*SharedValue, error) {
+	v, err := sm.readShared(key)
+	return v, errors.Trace(err)
+}
+
+// ListShared returns all shared values sorted by key.
+func (sm *StackManager) ListShared() ([]*SharedValue, error) {
+	bucket, err := sm.getBucket()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var keys []string
+	err = sm.awsClient.s3conn.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(sharedPrefix),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range out.Contents {
+			key := strings.TrimPrefix(aws.StringValue(obj.Key), sharedPrefix)
+			if strings.HasSuffix(key, ".json") {
+				keys = append(keys, strings.TrimSuffix(key, ".json"))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot list shared values")
+	}
+	sort.Strings(keys)
+	res := make([]*SharedValue, 0, len(keys))
+	for _, key := range keys {
+		v, err := sm.readShared(key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
@@ -0,0 +1,156 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestStackManager_shared(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "network", Publish: map[string]string{"network/vpc-id": "VpcId"}},
+		StackConfig{Name: "broken", Publish: map[string]string{"broken": "Missing"}},
+		StackConfig{Name: "app"},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-bootstrap/1"),
+			StackName:   aws.String("test-bootstrap"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("Bucket"), OutputValue: aws.String("bucket")},
+			},
+		},
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-network/1"),
+			StackName:   aws.String("test-network"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("VpcId"), OutputValue: aws.String("vpc-1")},
+			},
+		},
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-broken/1"),
+			StackName:   aws.String("test-broken"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+		},
+	})
+	s3conn := awsClient.s3conn.(*mock.MockS3API)
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	var events []interface{}
+	sm.SetEventHandler(func(event interface{}) { events = append(events, event) })
+
+	network, networkConfig, err := sm.getStack("network")
+	require.Nil(err)
+	require.Nil(sm.publish(network, networkConfig))
+	require.Len(events, 1)
+	require.Equal("vpc-1", events[0].(*SharedValue).Value)
+
+	obj := s3conn.Object("bucket", "shared/network/vpc-id.json")
+	require.NotNil(obj)
+	require.Equal("application/json", obj.ContentType)
+	require.JSONEq(`{"Key": "network/vpc-id", "Value": "vpc-1", "Stack": "network", "Output": "VpcId"}`, string(obj.Body))
+
+	// unchanged value does not create new version
+	require.Nil(sm.publish(network, networkConfig))
+	require.Len(s3conn.Versions("bucket", "shared/network/vpc-id.json"), 1)
+
+	broken, brokenConfig, err := sm.getStack("broken")
+	require.Nil(err)
+	require.NotNil(sm.publish(broken, brokenConfig))
+
+	// values are read from bucket by new stack manager
+	sm, err = newStackManager(config, awsClient)
+	require.Nil(err)
+	app, _, err := sm.getStack("app")
+	require.Nil(err)
+
+	var inputs = []struct {
+		tpl      string
+		expected string
+		err      bool
+	}{
+		{`{{ shared "network/vpc-id" }}`, "vpc-1", false},
+		{`{{ shared "missing" "default" }}`, "default", false},
+		{`{{ shared "missing" }}`, "", true},
+		{`{{ shared "../invalid" }}`, "", true},
+		{`{{ shared "a" "b" "c" }}`, "", true},
+	}
+	for _, input := range inputs {
+		res, err := sm.render(app, input.tpl)
+		if input.err {
+			require.NotNilf(err, "template: %s", input.tpl)
+			continue
+		}
+		require.Nilf(err, "template: %s, error: %s", input.tpl, err)
+		require.Equal(input.expected, res)
+	}
+
+	values, err := sm.ListShared()
+	require.Nil(err)
+	require.Equal([]*SharedValue{{
+		Key:       "network/vpc-id",
+		Value:     "vpc-1",
+		Stack:     "network",
+		Output:    "VpcId",
+		VersionID: obj.VersionID,
+	}}, values)
+
+	_, err = sm.GetShared("missing")
+	require.True(errors.IsNotFound(err))
+
+	// invalid publish keys are rejected
+	config.Stacks[1].Publish = map[string]string{"/invalid": "VpcId"}
+	_, err = newStackManager(config, awsClient)
+	require.NotNil(err)
+}
+
+func TestStackManager_publishKeys(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{
+			Name:    "db",
+			ForEach: []interface{}{"a", "b"},
+			Publish: map[string]string{"db/{{ .Each.Value }}/endpoint": "Endpoint"},
+		},
+		StackConfig{Name: "network", Publish: map[string]string{"{{ .Name }}/vpc-id": "VpcId"}},
+	)
+	defer cleanup()
+	awsClient, _ := newTestAWSClient()
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+	_, dbConfig, err := sm.getStack("db-b")
+	require.Nil(err)
+	require.Equal(map[string]string{"db/b/endpoint": "Endpoint"}, dbConfig.Publish)
+	_, networkConfig, err := sm.getStack("network")
+	require.Nil(err)
+	require.Equal(map[string]string{"test/vpc-id": "VpcId"}, networkConfig.Publish)
+
+	// keys of expanded stacks must be unique
+	config.Stacks[1].Publish = map[string]string{"db/endpoint": "Endpoint"}
+	_, err = newStackManager(config, awsClient)
+	require.NotNil(err)
+	require.Equal("stack 'db-b' publishes key 'db/endpoint', which is already published by stack 'db-a'", err.Error())
+
+	// keys must be unique across stacks
+	config.Stacks[1].Publish = map[string]string{"db/{{ .Each.Value }}/endpoint": "Endpoint"}
+	config.Stacks[2].Publish = map[string]string{"db/a/endpoint": "Endpoint"}
+	_, err = newStackManager(config, awsClient)
+	require.NotNil(err)
+	require.Equal("stack 'network' publishes key 'db/a/endpoint', which is already published by stack 'db-a'", err.Error())
+}
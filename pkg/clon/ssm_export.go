@@ -0,0 +1,189 @@
+package clon
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// valueKnownAfterExecution is the planned value of SSM parameter,
+// which is exported from output not yet available on stack.
+const valueKnownAfterExecution = "(known after execution)"
+
+// ParameterExport is the event emitted after SSM parameter
+// is updated or deleted.
+type ParameterExport struct {
+	// Stack is the name of exporting stack.
+	Stack string
+
+	// Name is the name of SSM parameter.
+	Name string
+
+	// Output is the name of exported stack output.
+	Output string
+
+	// Deleted indicates if parameter was deleted.
+	Deleted bool
+}
+
+// exportedParameters returns the rendered SSM parameter names
+// mapped to the names of stack outputs.
+func (sm *StackManager) exportedParameters(s *stack, stackConfig *StackConfig) (map[string]string, error) {
+	res := make(map[string]string, len(stackConfig.ExportToSSM))
+	for name, output := range stackConfig.ExportToSSM {
+		rendered, err := sm.render(s, name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot render SSM parameter name '%s'", name)
+		}
+		if rendered == "" {
+			return nil, errors.Errorf("SSM parameter name '%s' is rendered as empty string", name)
+		}
+		if _, ok := res[rendered]; ok {
+			return nil, errors.Errorf("duplicate SSM parameter name '%s'", rendered)
+		}
+		res[rendered] = output
+	}
+	return res, nil
+}
+
+// getParameter reads the SSM parameter value. The missing
+// parameter is returned as empty string.
+func (sm *StackManager) getParameter(name string) (string, bool, error) {
+	out, err := sm.awsClient.ssmconn.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == ssm.ErrCodeParameterNotFound {
+			return "", false, nil
+		}
+		return "", false, errors.Annotatef(err, "cannot read SSM parameter '%s'", name)
+	}
+	if out.Parameter == nil {
+		return "", false, nil
+	}
+	return aws.StringValue(out.Parameter.Value), true, nil
+}
+
+// planParameters returns the diff between current values of
+// SSM parameters and stack outputs. If the plan of stack has
+// change, outputs are not known before execution, otherwise
+// the exported outputs must exist.
+func (sm *StackManager) planParameters(s *stack, stackConfig *StackConfig, hasChange bool) (DiffStringMap, error) {
+	if len(stackConfig.ExportToSSM) == 0 {
+		return nil, nil
+	}
+	params, err := sm.exportedParameters(s, stackConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	outputs := map[string]string{}
+	if data := s.stackData(); data != nil {
+		outputs = data.Outputs
+	}
+	res := make(DiffStringMap, len(params))
+	for name, output := range params {
+		current, _, err := sm.getParameter(name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		value := valueKnownAfterExecution
+		if !hasChange {
+			var ok bool
+			if value, ok = outputs[output]; !ok {
+				return nil, errors.Errorf("cannot export SSM parameter '%s', output '%s' of stack '%s' not found", name, output, s.configName)
+			}
+		}
+		res[name] = DiffString{old: current, new: value}
+	}
+	return res, nil
+}
+
+// exportParameters writes the outputs listed in ExportToSSM of
+// stack into SSM parameters. Unchanged parameters are not updated.
+func (sm *StackManager) exportParameters(s *stack, stackConfig *StackConfig) error {
+	if len(stackConfig.ExportToSSM) == 0 {
+		return nil
+	}
+	params, err := sm.exportedParameters(s, stackConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data := s.stackData()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output := params[name]
+		value, ok := data.Outputs[output]
+		if !ok {
+			return errors.Errorf("cannot export SSM parameter '%s', output '%s' of stack '%s' not found", name, output, s.configName)
+		}
+		current, exists, err := sm.getParameter(name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if exists && current == value {
+			continue
+		}
+		_, err = sm.awsClient.ssmconn.PutParameter(&ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(value),
+			Type:      aws.String(ssm.ParameterTypeString),
+			Overwrite: aws.Bool(true),
+		})
+		if err != nil {
+			return errors.Annotatef(err, "cannot put SSM parameter '%s'", name)
+		}
+		sm.emit(&ParameterExport{Stack: s.configName, Name: name, Output: output})
+	}
+	return nil
+}
+
+// deleteParameters deletes the SSM parameters exported by stack.
+// Parameters, which do not exist, are ignored.
+func (sm *StackManager) deleteParameters(s *stack, stackConfig *StackConfig) error {
+	if len(stackConfig.ExportToSSM) == 0 {
+		return nil
+	}
+	params, err := sm.exportedParameters(s, stackConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err = sm.awsClient.ssmconn.DeleteParameter(&ssm.DeleteParameterInput{
+			Name: aws.String(name),
+		})
+		if err != nil {
+			if e, ok := err.(awserr.Error); ok && e.Code() == ssm.ErrCodeParameterNotFound {
+				continue
+			}
+			return errors.Annotatef(err, "cannot delete SSM parameter '%s'", name)
+		}
+		sm.emit(&ParameterExport{Stack: s.configName, Name: name, Output: params[name], Deleted: true})
+	}
+	return nil
+}
+
+// ExportParameters writes the outputs of stack into SSM parameters
+// listed in ExportToSSM of stack config.
+func (sm *StackManager) ExportParameters(name string) error {
+	stack, stackConfig, err := sm.getStack(name)
+	if err != nil {
+		return errors.Annotatef(err, "cannot get stack '%s'", name)
+	}
+	if err = sm.exportParameters(stack, stackConfig); err != nil {
+		return errors.Annotatef(err, "cannot export outputs of stack '%s'", name)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ssm"
+
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+func TestStackManager_exportToSSM(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{
+			Name: "network",
+			ExportToSSM: map[string]string{
+				"/{{ .Name }}/vpc-id":    "VpcId",
+				"/{{ .Name }}/subnet-id": "SubnetId",
+			},
+		},
+		StackConfig{Name: "app", ExportToSSM: map[string]string{"/test/app-url": "Url"}},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-network/1"),
+			StackName:   aws.String("test-network"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("VpcId"), OutputValue: aws.String("vpc-1")},
+				{OutputKey: aws.String("SubnetId"), OutputValue: aws.String("subnet-1")},
+			},
+		},
+	})
+	ssmconn := awsClient.ssmconn.(*mock.MockSSMAPI)
+	ssmconn.AddParameters(map[string]string{"/test/subnet-id": "subnet-0"})
+
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	var exports []*ParameterExport
+	sm.SetEventHandler(func(event interface{}) {
+		if e, ok := event.(*ParameterExport); ok {
+			exports = append(exports, e)
+		}
+	})
+
+	network, networkConfig, err := sm.getStack("network")
+	require.Nil(err)
+	diff, err := sm.planParameters(network, networkConfig, false)
+	require.Nil(err)
+	require.Equal(DiffStringMap{
+		"/test/vpc-id":    {old: "", new: "vpc-1"},
+		"/test/subnet-id": {old: "subnet-0", new: "subnet-1"},
+	}, diff)
+
+	require.Nil(sm.exportParameters(network, networkConfig))
+	require.Equal(map[string]string{
+		"/test/vpc-id":    "vpc-1",
+		"/test/subnet-id": "subnet-1",
+	}, ssmconn.Parameters())
+	require.Len(exports, 2)
+
+	// unchanged parameters are not updated
+	exports = nil
+	require.Nil(sm.exportParameters(network, networkConfig))
+	require.Empty(exports)
+	diff, err = sm.planParameters(network, networkConfig, false)
+	require.Nil(err)
+	require.False(diff.HasChange())
+
+	// outputs of changed stack are known after execution
+	diff, err = sm.planParameters(network, networkConfig, true)
+	require.Nil(err)
+	require.Equal(DiffStringMap{
+		"/test/vpc-id":    {old: "vpc-1", new: valueKnownAfterExecution},
+		"/test/subnet-id": {old: "subnet-1", new: valueKnownAfterExecution},
+	}, diff)
+	app, appConfig, err := sm.getStack("app")
+	require.Nil(err)
+	diff, err = sm.planParameters(app, appConfig, true)
+	require.Nil(err)
+	require.Equal(DiffStringMap{
+		"/test/app-url": {old: "", new: valueKnownAfterExecution},
+	}, diff)
+	require.NotNil(sm.exportParameters(app, appConfig))
+
+	// missing output of unchanged stack fails the plan
+	_, err = sm.planParameters(app, appConfig, false)
+	require.NotNil(err)
+	require.Equal("cannot export SSM parameter '/test/app-url', output 'Url' of stack 'app' not found", err.Error())
+
+	// missing parameters are ignored on delete
+	_, err = ssmconn.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String("/test/vpc-id")})
+	require.Nil(err)
+	exports = nil
+	require.Nil(sm.deleteParameters(network, networkConfig))
+	require.Equal([]*ParameterExport{
+		{Stack: "network", Name: "/test/subnet-id", Output: "SubnetId", Deleted: true},
+	}, exports)
+	require.Empty(ssmconn.Parameters())
+}
@@ -226,14 +226,17 @@ func (s *stack) getChangeSet(csData *cfn.ChangeSetData) (*cfn.ChangeSet, error)
 	return cs, nil
 }
 
-func (s *stack) execute(csData *cfn.ChangeSetData) (err error) {
+// execute executes the change set and waits until stack is
+// updated. The data of executed change set is returned.
+func (s *stack) execute(csData *cfn.ChangeSetData) (*cfn.ChangeSetData, error) {
 	cs, err := cfn.NewChangeSet(s.sm.awsClient.cfnconn, csData)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
+	executed := cs.Data()
 
 	if err = cs.Execute(); err != nil {
-		return errors.Annotatef(err, "cannot execute change set '%s'", csData.Name)
+		return executed, errors.Annotatef(err, "cannot execute change set '%s'", csData.Name)
 	}
 
 	cl := s.trackUpdates(func(stack *cfn.StackData) (bool, error) {
@@ -245,11 +248,13 @@ func (s *stack) execute(csData *cfn.ChangeSetData) (err error) {
 		return false, errors.Errorf("stack '%s' has invlid status '%s'", stack.Name, stack.Status)
 	})
 
-	return errors.Trace(cl.Wait())
+	return executed, errors.Trace(cl.Wait())
 }
 
-func (s *stack) destroy() error {
-	err := s.stack.Destroy()
+// destroy deletes the stack and waits until it is deleted. The
+// retainResources are logical IDs of resources, which are retained.
+func (s *stack) destroy(retainResources ...string) error {
+	err := s.stack.Destroy(retainResources...)
 	if err != nil {
 		return errors.Annotatef(err, "cannot destroy stack '%s'", s.name)
 	}
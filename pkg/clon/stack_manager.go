@@ -3,6 +3,7 @@ package clon
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/juju/errors"
@@ -11,6 +12,9 @@ import (
 	"github.com/spirius/clon/pkg/s3file"
 )
 
+// templatesPrefix is the prefix of stack templates in bootstrap bucket.
+const templatesPrefix = "templates/"
+
 // StackManager is high level API for managing AWS CloudFormation
 // stacks.
 type StackManager struct {
@@ -25,9 +29,41 @@ type StackManager struct {
 
 	fileConfigs map[string]FileConfig
 
-	bucket string
-	vars   map[string]string
-	files  map[string]*s3file.File
+	// dependsOn is the rendered DependsOn of stacks.
+	dependsOn map[string][]string
+
+	// warnings is the set of emitted warnings.
+	warnings map[string]bool
+
+	// shared is the cache of shared values.
+	shared map[string]*SharedValue
+
+	bucket     string
+	vars       map[string]interface{}
+	varOrigins map[string]string
+	files      map[string]*s3file.File
+	fileSets   map[string]*FileSet
+	lookup     *valueLookup
+
+	syncConcurrency int
+	fileCachePath   string
+	fileCache       *fileCache
+	buildCachePath  string
+	buildCache      *buildCache
+
+	// version is the clon version recorded in deployment history.
+	version string
+
+	// planned is the map of IDs of plans created by stack manager
+	// to their inputs, used for records of deployment history.
+	planned map[string]*plannedDeployment
+
+	// allowProtected allows destroying protected stacks.
+	allowProtected bool
+
+	// retainResources accepts retaining of resources, which
+	// failed to delete.
+	retainResources func(*StackData, []string) error
 
 	emit   func(interface{})
 	verify func(string) error
@@ -73,6 +109,9 @@ func (sm *StackManager) getStack(name string) (*stack, *StackConfig, error) {
 // In order for S3 upload to work, bucket field must be set by calling SetBucket.
 func (sm *StackManager) renderStackData(s *stack, stackConfig *StackConfig) (*StackData, error) {
 	var err error
+	if err = sm.verifyDependencies(s); err != nil {
+		return nil, errors.Trace(err)
+	}
 	sd := &StackData{
 		StackData: cfn.StackData{
 			Name:         sm.stackName(s.configName),
@@ -95,11 +134,16 @@ func (sm *StackManager) renderStackData(s *stack, stackConfig *StackConfig) (*St
 	}
 
 	if sm.bucket != "" {
+		kmsKeyID, err := sm.kmsKeyID()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
 		tpl, err := s3file.Write(sm.awsClient.s3conn, s3file.Config{
-			Region: sm.awsClient.region,
-			Bucket: sm.bucket,
-			Prefix: "templates/",
-			Source: stackConfig.Template,
+			Region:      sm.awsClient.region,
+			Bucket:      sm.bucket,
+			Prefix:      templatesPrefix,
+			Source:      stackConfig.pos.resolvePath(stackConfig.Template),
+			SSEKMSKeyID: kmsKeyID,
 		})
 		if err != nil {
 			return nil, errors.Annotatef(err, "cannot upload template '%s' for stack '%s'", stackConfig.Template, s.configName)
@@ -107,7 +151,7 @@ func (sm *StackManager) renderStackData(s *stack, stackConfig *StackConfig) (*St
 		sd.TemplateURL = tpl.URL
 	} else {
 		// should be used only for bootstrapping
-		content, err := ioutil.ReadFile(stackConfig.Template)
+		content, err := ioutil.ReadFile(stackConfig.pos.resolvePath(stackConfig.Template))
 		if err != nil {
 			return nil, errors.Annotatef(err, "cannot read template for stack '%s'", s.configName)
 		}
@@ -138,15 +182,54 @@ func (sm *StackManager) getTemplateCtx() map[string]interface{} {
 	}
 }
 
+// templateFuncs returns the template functions of StackManager.
+// Relative paths of 'file' function are resolved against the
+// directory of config file, where pos is defined.
+func (sm *StackManager) templateFuncs(pos position) map[string]interface{} {
+	funcs := sm.lookup.funcs()
+	funcs["file"] = tplFuncFile(pos.dir())
+	return funcs
+}
+
+// renderFuncs returns the template functions for rendering stack
+// inputs. The 'stack' function is available only for non-root stacks.
+func (sm *StackManager) renderFuncs(s *stack, pos position) map[string]interface{} {
+	funcs := sm.templateFuncs(pos)
+	funcs["stackOutput"] = sm.tplStackOutput
+	funcs["stackOr"] = sm.tplStackOr
+	funcs["shared"] = sm.tplShared
+	if s != nil && s.configName != sm.config.RootStack {
+		funcs["stack"] = sm.tplGetStackData(s)
+	}
+	return funcs
+}
+
 // render will render the content as golang template using
 // context of StackManager.
 func (sm *StackManager) render(s *stack, content string) (string, error) {
+	pos := sm.config.pos
+	if s != nil {
+		if stackConfig, ok := sm.stackConfigs[s.configName]; ok {
+			pos = stackConfig.pos
+		}
+	}
+	return sm.renderAt(s, pos, content)
+}
+
+// renderAt renders the content same as render, resolving
+// paths of 'file' function against the location pos.
+func (sm *StackManager) renderAt(s *stack, pos position, content string) (string, error) {
 	ctx := sm.getTemplateCtx()
-	funcs := make(map[string]interface{})
+	if s != nil {
+		if stackConfig, ok := sm.stackConfigs[s.configName]; ok && stackConfig.each != nil {
+			ctx["Each"] = stackConfig.each
+		}
+	}
+	funcs := sm.renderFuncs(s, pos)
 	if s != nil {
 		if s.configName != sm.config.RootStack {
-			funcs["stack"] = sm.tplGetStackData(s)
 			ctx["File"] = sm.files
+			ctx["FileSet"] = sm.fileSets
 		}
 	}
 	if s == nil || s.configName != sm.config.RootStack {
@@ -170,33 +253,85 @@ func (sm *StackManager) tplGetStackData(child *stack) func(name string) (*StackD
 			}
 		}()
 		var s *stack
-		// avoid self-references
-		if child.configName == name {
-			err = errors.Errorf("found self reference in stack '%s'", child.configName)
+		if s, err = sm.requireStack(child, name); err != nil {
 			return
 		}
-		// find the stack
-		s, _, err = sm.getStack(name)
-		if err != nil {
-			err = errors.Annotatef(err, "cannot find stack '%s'", name)
-			return
+		return s.stackData(), nil
+	}
+}
+
+// requireStack adds child as dependent stack of stack with name
+// and verifies, that the stack is up-to-date.
+func (sm *StackManager) requireStack(child *stack, name string) (*stack, error) {
+	// avoid self-references
+	if child.configName == name {
+		return nil, errors.Errorf("found self reference in stack '%s'", child.configName)
+	}
+	// find the stack
+	s, _, err := sm.getStack(name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot find stack '%s'", name)
+	}
+	// check for cyclic reference
+	if err = s.addChild(child); err != nil {
+		return nil, errors.Annotatef(err, "cannot add '%s' as child of '%s'", child.configName, s.configName)
+	}
+	// check if already updated
+	if s.updated || (s.planned && !s.hasChange) {
+		return s, nil
+	}
+	// update stack if needed
+	if err = sm.verify(name); err != nil {
+		return nil, errors.Annotatef(err, "stack '%s' is not deployed", name)
+	}
+	return s, nil
+}
+
+// verifyDependencies verifies, that stacks listed in DependsOn
+// of stack are up-to-date.
+func (sm *StackManager) verifyDependencies(s *stack) error {
+	for _, name := range sm.dependsOn[s.configName] {
+		if _, err := sm.requireStack(s, name); err != nil {
+			return errors.Annotatef(err, "dependency '%s' of stack '%s' failed", name, s.configName)
 		}
-		// check for cyclic reference
-		if err = s.addChild(child); err != nil {
-			err = errors.Annotatef(err, "cannot add '%s' as child of '%s'", child.configName, s.configName)
-			return
+	}
+	return nil
+}
+
+// resolveDependencies renders DependsOn of stacks and adds stacks
+// as children of their dependencies, so that cyclic dependencies
+// are detected before any stack is rendered.
+func (sm *StackManager) resolveDependencies() error {
+	for _, name := range sm.stackOrder {
+		s, stackConfig, err := sm.getStack(name)
+		if err != nil {
+			return errors.Trace(err)
 		}
-		// check if already updated
-		if s.updated || (s.planned && !s.hasChange) {
-			return s.stackData(), nil
+		ctx := sm.getTemplateCtx()
+		if stackConfig.each != nil {
+			ctx["Each"] = stackConfig.each
 		}
-		// update stack if needed
-		if err = sm.verify(name); err != nil {
-			err = errors.Annotatef(err, "stack '%s' is not deployed", name)
-			return
+		funcs := sm.templateFuncs(stackConfig.pos)
+		deps := make([]string, 0, len(stackConfig.DependsOn))
+		for _, dep := range stackConfig.DependsOn {
+			if dep, err = renderTemplate(dep, ctx, funcs); err != nil {
+				return errors.Annotatef(err, "cannot render DependsOn of stack '%s'", name)
+			}
+			if dep == name {
+				return errors.Errorf("stack '%s' depends on itself", name)
+			}
+			parent, _, err := sm.getStack(dep)
+			if err != nil {
+				return errors.Annotatef(err, "stack '%s' depends on unknown stack", name)
+			}
+			if err = parent.addChild(s); err != nil {
+				return errors.Annotatef(err, "cannot add '%s' as child of '%s'", name, dep)
+			}
+			deps = append(deps, dep)
 		}
-		return s.stackData(), nil
+		sm.dependsOn[name] = deps
 	}
+	return nil
 }
 
 // Plan creates plan of changes.
@@ -209,6 +344,19 @@ func (sm *StackManager) Plan(name string) (*Plan, error) {
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot plan '%s', stack input rendering failed", name)
 	}
+	return sm.planStack(stack, stackConfig, stackData, 0)
+}
+
+// planStack creates plan of changes from stack data. If plan
+// contains changes, its inputs are kept for deployment history.
+// The rollbackOf is the number of deployment, which the plan
+// rolls back to.
+func (sm *StackManager) planStack(stack *stack, stackConfig *StackConfig, stackData *StackData, rollbackOf int) (*Plan, error) {
+	name := stack.configName
+	err := sm.runHooks(stack, stackConfig, HookPrePlan, stackData.Parameters, stack.stackData())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	cs, err := stack.plan(stackData)
 	if err != nil {
@@ -219,6 +367,13 @@ func (sm *StackManager) Plan(name string) (*Plan, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if plan.SSMParameters, err = sm.planParameters(stack, stackConfig, plan.HasChange); err != nil {
+		return nil, errors.Annotatef(err, "cannot plan SSM parameters of stack '%s'", name)
+	}
+	plan.Policies = sm.evaluatePolicies(stackConfig, plan.ChangeSet.Changes)
+	if plan.HasChange {
+		sm.planned[plan.ID] = &plannedDeployment{stackData: stackData, rollbackOf: rollbackOf}
+	}
 
 	stack.planned = true
 	stack.hasChange = plan.HasChange
@@ -229,7 +384,7 @@ func (sm *StackManager) Plan(name string) (*Plan, error) {
 
 // GetPlan returns the Plan data.
 func (sm *StackManager) GetPlan(name, planID string) (*Plan, error) {
-	stack, _, err := sm.getStack(name)
+	stack, stackConfig, err := sm.getStack(name)
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot get stack '%s'", name)
 	}
@@ -247,7 +402,15 @@ func (sm *StackManager) GetPlan(name, planID string) (*Plan, error) {
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot execute change set '%s' for stack '%s'", changeSetID, name)
 	}
-	return newPlan(cs.Data(), stack.stackData(), sm.config.IgnoreNestedUpdates)
+	plan, err := newPlan(cs.Data(), stack.stackData(), sm.config.IgnoreNestedUpdates)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if plan.SSMParameters, err = sm.planParameters(stack, stackConfig, plan.HasChange); err != nil {
+		return nil, errors.Annotatef(err, "cannot plan SSM parameters of stack '%s'", name)
+	}
+	plan.Policies = sm.evaluatePolicies(stackConfig, plan.ChangeSet.Changes)
+	return plan, nil
 }
 
 // SetEventHandler sets the function which is called
@@ -278,9 +441,28 @@ func (sm *StackManager) List() ([]*StackData, error) {
 	return res, nil
 }
 
+// Variables returns the list of rendered variables
+// sorted by name.
+func (sm *StackManager) Variables() []*Variable {
+	names := make([]string, 0, len(sm.vars))
+	for name := range sm.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]*Variable, 0, len(names))
+	for _, name := range names {
+		res = append(res, &Variable{
+			Name:   name,
+			Value:  sm.vars[name],
+			Origin: sm.varOrigins[name],
+		})
+	}
+	return res
+}
+
 // Execute executes the plan on the stack.
 func (sm *StackManager) Execute(name string, planID string) (*StackData, error) {
-	stack, _, err := sm.getStack(name)
+	stack, stackConfig, err := sm.getStack(name)
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot get stack '%s'", name)
 	}
@@ -291,14 +473,47 @@ func (sm *StackManager) Execute(name string, planID string) (*StackData, error)
 		AccountID: sm.awsClient.accountID,
 		Resource:  "changeSet/" + planID,
 	}).String()
-	err = stack.execute(&cfn.ChangeSetData{
+	if len(sm.config.Policies) > 0 || len(stackConfig.Policies) > 0 {
+		plan, err := sm.GetPlan(name, planID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = plan.CheckPolicies(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if len(stackConfig.Hooks.PreExecute) > 0 {
+		params, err := sm.plannedParameters(stack, changeSetID, planID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = sm.runHooks(stack, stackConfig, HookPreExecute, params, stack.stackData()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	executed, err := stack.execute(&cfn.ChangeSetData{
 		ID:        changeSetID,
 		StackData: &stack.stackData().StackData,
 	})
 	if err != nil {
 		stack.updated = true
+		return stack.stackData(), errors.Annotatef(err, "cannot execute change set '%s' for stack '%s'", changeSetID, name)
+	}
+	if err = sm.publish(stack, stackConfig); err != nil {
+		return stack.stackData(), errors.Annotatef(err, "cannot publish outputs of stack '%s'", name)
+	}
+	if err = sm.exportParameters(stack, stackConfig); err != nil {
+		return stack.stackData(), errors.Annotatef(err, "cannot export outputs of stack '%s'", name)
+	}
+	if r, err := sm.recordDeployment(stack, stackConfig, planID, executed.StackData); err != nil {
+		sm.warn(name, "deployment is not recorded in history: %s", err)
+	} else {
+		sm.emit(r)
 	}
-	return stack.stackData(), errors.Annotatef(err, "cannot execute change set '%s' for stack '%s'", changeSetID, name)
+	if err = sm.runHooks(stack, stackConfig, HookPostExecute, stack.stackData().Parameters, stack.stackData()); err != nil {
+		return stack.stackData(), errors.Trace(&postHookError{stack: name, change: "deployed", err: err})
+	}
+	return stack.stackData(), nil
 }
 
 // Get returns stack data.
@@ -312,101 +527,118 @@ func (sm *StackManager) Get(name string) (*StackData, error) {
 
 // Destroy destroys the stack.
 func (sm *StackManager) Destroy(name string) (*StackData, error) {
-	stack, _, err := sm.getStack(name)
+	stack, stackConfig, err := sm.getStack(name)
 	if err != nil {
 		return nil, errors.Annotatef(err, "cannot get stack '%s'", name)
 	}
-	if err = stack.destroy(); err != nil {
+	if err = sm.checkProtected(name, stackConfig); err != nil {
+		return nil, errors.Trace(err)
+	}
+	before := *stack.stackData()
+	if err = sm.runHooks(stack, stackConfig, HookPreDestroy, before.Parameters, &before); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = sm.destroyStack(stack, stackConfig); err != nil {
 		return nil, errors.Annotatef(err, "cannot destroy stack '%s'", name)
 	}
-	return stack.stackData(), nil
-}
-
-// SyncFiles synchronizes the files from local
-// system to S3 bucket.
-func (sm *StackManager) SyncFiles() error {
-	var file *s3file.File
-	var err error
-	for k, f := range sm.fileConfigs {
-		config := s3file.Config{
-			Region: sm.awsClient.region,
-		}
-		// render file config
-		if f.Bucket == "" {
-			config.Bucket = sm.bucket
-		} else {
-			config.Bucket, err = sm.render(nil, f.Bucket)
-			if err != nil {
-				return errors.Annotatef(err, "cannot sync files, bucket name rendering failed for file '%s'", k)
-			}
-		}
-		config.Key, err = sm.render(nil, f.Key)
-		if err != nil {
-			return errors.Annotatef(err, "cannot sync files, bucket key rendering failed for file '%s'", k)
-		}
-		config.Source, err = sm.render(nil, f.Src)
-		if err != nil {
-			return errors.Annotatef(err, "cannot sync files, file source rendering failed for file '%s'", k)
-		}
-		// read or write the file
-		if config.Source == "" {
-			file, err = s3file.Read(sm.awsClient.s3conn, config)
-			if err != nil {
-				return errors.Annotatef(err, "cannot read file '%s' from s3", k)
-			}
-			// Discard the content
-			file.Body.Close()
-		} else {
-			file, err = s3file.Write(sm.awsClient.s3conn, config)
-			if err != nil {
-				return errors.Annotatef(err, "cannot upload file '%s' to s3", k)
-			}
-		}
-		sm.files[k] = file
+	if err = sm.deleteParameters(stack, stackConfig); err != nil {
+		return stack.stackData(), errors.Annotatef(err, "cannot delete SSM parameters of stack '%s'", name)
 	}
-	return nil
+	// outputs of destroyed stack are exposed to post destroy hooks
+	after := before
+	after.Status = stack.stackData().Status
+	if err = sm.runHooks(stack, stackConfig, HookPostDestroy, before.Parameters, &after); err != nil {
+		return stack.stackData(), errors.Trace(&postHookError{stack: name, change: "destroyed", err: err})
+	}
+	return stack.stackData(), nil
 }
 
 // NewStackManager creates new instance of StackManager from config.
 // It initialized AWS session, verifies account id and
 // reads stacks statuses.
 func NewStackManager(config Config) (*StackManager, error) {
+	awsClient, err := newAWSClient(config.Region)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot create new StackManager, aws error occurred")
+	}
+
+	if config.AccountID != "" && config.AccountID != awsClient.accountID {
+		return nil, errors.Errorf("AccountID specified in config (%s) is not same as for AWS connection (%s)", config.AccountID, awsClient.accountID)
+	}
+	return newStackManager(config, awsClient)
+}
+
+// newStackManager creates new instance of StackManager from
+// config, using awsClient for all AWS calls.
+func newStackManager(config Config, awsClient *awsClient) (*StackManager, error) {
+	var err error
 	sm := &StackManager{
 		config:       &config,
 		name:         config.Name,
 		stackOrder:   make([]string, 0, len(config.Stacks)),
 		stacks:       make(map[string]*stack, len(config.Stacks)),
 		stackConfigs: make(map[string]*StackConfig, len(config.Stacks)),
+		dependsOn:    make(map[string][]string, len(config.Stacks)),
+		warnings:     make(map[string]bool),
+		shared:       make(map[string]*SharedValue),
+		planned:      make(map[string]*plannedDeployment),
 		emit:         func(interface{}) {},
 		verify:       func(name string) error { return nil },
 
-		vars:  make(map[string]string, len(config.Variables)),
-		files: make(map[string]*s3file.File, len(config.Files)),
-	}
-	awsClient, err := newAWSClient()
-	if err != nil {
-		return nil, errors.Annotatef(err, "cannot create new StackManager, aws error occurred")
-	}
-
-	if config.AccountID != "" && config.AccountID != awsClient.accountID {
-		return nil, errors.Errorf("AccountID specified in config (%s) is not same as for AWS connection (%s)", config.AccountID, awsClient.accountID)
+		files:    make(map[string]*s3file.File, len(config.Files)),
+		fileSets: make(map[string]*FileSet, len(config.Files)),
 	}
-
 	sm.awsClient = awsClient
-
-	for k, v := range config.Variables {
-		sm.vars[k], err = renderTemplate(v, sm.getTemplateCtx(), nil)
-		if err != nil {
-			return nil, errors.Annotatef(err, "cannot render variable %s = %s", k, v)
+	sm.lookup = newValueLookup(awsClient.ssmconn, awsClient.smconn)
+
+	vars, origins := mergeVariableSources(append([]VariableSource{{
+		Origin:    VariableOriginConfig,
+		Variables: config.Variables,
+	}}, config.VariableSources...))
+	// variables of config are rendered relative to the defining file
+	positions := make(map[string]position, len(vars))
+	for k, origin := range origins {
+		positions[k] = config.pos
+		if pos, ok := config.variablePos[k]; ok && origin == VariableOriginConfig {
+			positions[k] = pos
+		}
+	}
+	for k, origin := range config.variableOrigins {
+		if origins[k] == VariableOriginConfig {
+			origins[k] = origin
 		}
 	}
+	funcs := func(name string) map[string]interface{} {
+		return sm.templateFuncs(positions[name])
+	}
+	if sm.vars, err = resolveVariables(vars, sm.getTemplateCtx(), funcs); err != nil {
+		return nil, errors.Annotatef(err, "cannot render variables")
+	}
+	sm.varOrigins = origins
 	sm.fileConfigs = config.Files
 
+	// publishers is the map of shared value keys to publishing stacks
+	publishers := make(map[string]string)
 	for _, stackConfig := range config.Stacks {
-		sm.stackOrder = append(sm.stackOrder, stackConfig.Name)
-		if err = sm.addStack(stackConfig.Name, stackConfig); err != nil {
-			return nil, errors.Annotatef(err, "cannot add %s stack", stackConfig.Name)
+		stackConfigs, err := expandStack(stackConfig, sm.getTemplateCtx(), sm.templateFuncs(stackConfig.pos))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if stackConfig.Name == config.RootStack && (len(stackConfig.ForEach) > 0 || len(stackConfig.Matrix) > 0) {
+			return nil, errors.Errorf("stack '%s' cannot be expanded", stackConfig.Name)
 		}
+		for _, stackConfig := range stackConfigs {
+			if err = renderPublish(&stackConfig, sm.getTemplateCtx(), sm.templateFuncs(stackConfig.pos), publishers); err != nil {
+				return nil, errors.Trace(err)
+			}
+			sm.stackOrder = append(sm.stackOrder, stackConfig.Name)
+			if err = sm.addStack(stackConfig.Name, stackConfig); err != nil {
+				return nil, errors.Annotatef(err, "cannot add %s stack", stackConfig.Name)
+			}
+		}
+	}
+	if err = sm.resolveDependencies(); err != nil {
+		return nil, errors.Annotatef(err, "cannot resolve stack dependencies")
 	}
 
 	return sm, nil
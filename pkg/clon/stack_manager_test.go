@@ -0,0 +1,214 @@
+package clon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	cfnmock "github.com/spirius/clon/pkg/cfn/mock"
+	mock "github.com/spirius/clon/pkg/clon/mock"
+)
+
+// newTestAWSClient returns awsClient with mocked AWS APIs.
+func newTestAWSClient() (*awsClient, *cfnmock.MockCloudFormationAPI) {
+	cfnconn := cfnmock.NewMockCloudFormationAPI()
+	return &awsClient{
+		cfnconn:     cfnconn,
+		s3conn:      mock.NewMockS3API(),
+		ssmconn:     mock.NewMockSSMAPI(),
+		smconn:      mock.NewMockSecretsManagerAPI(),
+		accountID:   "123456789012",
+		region:      "eu-central-1",
+		sessionName: "test",
+	}, cfnconn
+}
+
+// newTestConfig returns the config with bootstrap stack and given
+// stacks. Templates of all stacks are created in temporary directory.
+func newTestConfig(t *testing.T, stacks ...StackConfig) (Config, func()) {
+	dir, err := ioutil.TempDir("", "clon")
+	require.Nil(t, err)
+	tpl := filepath.Join(dir, "template.yml")
+	require.Nil(t, ioutil.WriteFile(tpl, []byte("Resources: {}"), 0644))
+	stacks = append([]StackConfig{{Name: "bootstrap"}}, stacks...)
+	for i := range stacks {
+		stacks[i].Template = tpl
+	}
+	return Config{
+		Name:      "test",
+		RootStack: "bootstrap",
+		Stacks:    stacks,
+	}, func() { os.RemoveAll(dir) }
+}
+
+func TestStackManager_dependsOn(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "roles"},
+		StackConfig{Name: "network"},
+		StackConfig{
+			Name:       "app",
+			DependsOn:  []string{"roles"},
+			Parameters: map[string]string{"Vpc": `{{ (stack "network").Name }}`},
+		},
+		StackConfig{
+			Name:      "tenant",
+			ForEach:   []interface{}{"a", "b"},
+			DependsOn: []string{"app", "{{ if eq .Each.Value \"b\" }}tenant-a{{ else }}roles{{ end }}"},
+		},
+	)
+	defer cleanup()
+	awsClient, _ := newTestAWSClient()
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	graph, err := sm.Graph()
+	require.Nil(err)
+	require.Equal([]*StackNode{
+		{Name: "bootstrap", DependsOn: []string{}},
+		{Name: "roles", DependsOn: []string{}},
+		{Name: "network", DependsOn: []string{}},
+		{Name: "app", DependsOn: []string{"network", "roles"}},
+		{Name: "tenant-a", DependsOn: []string{"app", "roles"}},
+		{Name: "tenant-b", DependsOn: []string{"app", "tenant-a"}},
+	}, graph)
+
+	// dependencies are verified, even if outputs are not used
+	var verified []string
+	sm.SetVerify(func(name string) error {
+		verified = append(verified, name)
+		return nil
+	})
+	s, stackConfig, err := sm.getStack("tenant-b")
+	require.Nil(err)
+	_, err = sm.renderStackData(s, stackConfig)
+	require.Nil(err)
+	require.Equal([]string{"app", "tenant-a"}, verified)
+}
+
+func TestStackManager_dependsOnErrors(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		stacks []StackConfig
+		err    string
+	}{
+		{
+			[]StackConfig{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"c"}},
+				{Name: "c", DependsOn: []string{"a"}},
+			},
+			"cyclic dependency between stacks",
+		},
+		{
+			[]StackConfig{{Name: "a", DependsOn: []string{"a"}}},
+			"stack 'a' depends on itself",
+		},
+		{
+			[]StackConfig{{Name: "a", DependsOn: []string{"missing"}}},
+			"stack 'missing' not found",
+		},
+	}
+	for _, input := range inputs {
+		config, cleanup := newTestConfig(t, input.stacks...)
+		awsClient, _ := newTestAWSClient()
+		_, err := newStackManager(config, awsClient)
+		cleanup()
+		require.NotNil(err)
+		require.Contains(err.Error(), input.err)
+	}
+}
+
+func TestStackManager_stackOutput(t *testing.T) {
+	require := require.New(t)
+
+	config, cleanup := newTestConfig(t,
+		StackConfig{Name: "network"},
+		StackConfig{Name: "failed"},
+		StackConfig{Name: "missing"},
+		StackConfig{Name: "app"},
+	)
+	defer cleanup()
+	awsClient, cfnconn := newTestAWSClient()
+	cfnconn.AddStacks([]*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-network/1"),
+			StackName:   aws.String("test-network"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("VpcId"), OutputValue: aws.String("vpc-1")},
+			},
+		},
+		{
+			StackId:     aws.String("arn:aws:cloudformation:eu-central-1:123456789012:stack/test-failed/1"),
+			StackName:   aws.String("test-failed"),
+			StackStatus: aws.String(cloudformation.StackStatusUpdateRollbackComplete),
+			Outputs: []*cloudformation.Output{
+				{OutputKey: aws.String("Key"), OutputValue: aws.String("old")},
+			},
+		},
+	})
+	sm, err := newStackManager(config, awsClient)
+	require.Nil(err)
+
+	var warnings []string
+	sm.SetEventHandler(func(event interface{}) {
+		if w, ok := event.(*Warning); ok {
+			warnings = append(warnings, w.Message)
+		}
+	})
+	sm.SetVerify(func(name string) error {
+		return errors.Errorf("stack '%s' must not be verified", name)
+	})
+	app, _, err := sm.getStack("app")
+	require.Nil(err)
+
+	var inputs = []struct {
+		tpl      string
+		expected string
+		warning  string
+	}{
+		{`{{ stackOutput "network" "VpcId" "vpc-default" }}`, "vpc-1", ""},
+		{`{{ stackOutput "network" "Missing" "def" }}`, "def", "output 'Missing' of stack 'network' not found, using default value"},
+		{`{{ stackOutput "missing" "VpcId" "def" }}`, "def", "stack 'missing' does not exist, using default value"},
+		{`{{ stackOutput "unknown" "VpcId" "def" }}`, "def", "stack 'unknown' is not defined, using default value"},
+		{`{{ stackOutput "failed" "Key" "def" }}`, "old", "stack 'failed' is in UPDATE_ROLLBACK_COMPLETE state, its outputs might be stale"},
+		{`{{ (stackOr "network" nil).Outputs.VpcId }}`, "vpc-1", ""},
+		// warning for missing stack is already emitted
+		{`{{ with stackOr "missing" nil }}{{ .Outputs.VpcId }}{{ else }}none{{ end }}`, "none", ""},
+	}
+	for _, input := range inputs {
+		warnings = nil
+		res, err := sm.render(app, input.tpl)
+		require.Nilf(err, "template: %s, error: %s", input.tpl, err)
+		require.Equal(input.expected, res)
+		if input.warning == "" {
+			require.Empty(warnings)
+		} else {
+			require.Equal([]string{input.warning}, warnings)
+		}
+	}
+
+	// warnings are emitted once
+	warnings = nil
+	_, err = sm.render(app, `{{ stackOutput "network" "Missing" "def" }}`)
+	require.Nil(err)
+	require.Empty(warnings)
+
+	// planned changes make outputs stale
+	network, _, err := sm.getStack("network")
+	require.Nil(err)
+	network.planned, network.hasChange = true, true
+	_, err = sm.render(app, `{{ stackOutput "network" "VpcId" "" }}`)
+	require.Nil(err)
+	require.Equal([]string{"stack 'network' has planned changes, its outputs might be stale"}, warnings)
+}
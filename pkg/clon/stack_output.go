@@ -0,0 +1,81 @@
+package clon
+
+import (
+	"fmt"
+)
+
+// Warning is the event emitted by StackManager, when the operation
+// can continue, but the result might not be as expected.
+type Warning struct {
+	// Stack is the config name of the stack, which warning relates to.
+	Stack string
+
+	// Message is the warning message.
+	Message string
+}
+
+// warn emits the warning event. Same warning is emitted only once.
+func (sm *StackManager) warn(stack, format string, args ...interface{}) {
+	w := &Warning{Stack: stack, Message: fmt.Sprintf(format, args...)}
+	key := w.Stack + "\x00" + w.Message
+	if sm.warnings[key] {
+		return
+	}
+	sm.warnings[key] = true
+	sm.emit(w)
+}
+
+// currentStackData returns the current data of stack without
+// verification. If stack does not exist, nil is returned and
+// warning is emitted. If stack outputs might be outdated,
+// warning is emitted.
+func (sm *StackManager) currentStackData(name string) *StackData {
+	s, ok := sm.stacks[name]
+	if !ok {
+		sm.warn(name, "stack '%s' is not defined, using default value", name)
+		return nil
+	}
+	data := s.stackData()
+	if data == nil || !data.Exists() {
+		sm.warn(name, "stack '%s' does not exist, using default value", name)
+		return nil
+	}
+	switch {
+	case s.updated:
+	case s.planned && s.hasChange:
+		sm.warn(name, "stack '%s' has planned changes, its outputs might be stale", name)
+	case data.IsInProgress():
+		sm.warn(name, "stack '%s' is being updated, its outputs might be stale", name)
+	case data.IsFailed() || data.IsRollback():
+		sm.warn(name, "stack '%s' is in %s state, its outputs might be stale", name, data.Status)
+	}
+	return data
+}
+
+// tplStackOutput is function exposed to template engine with name
+// 'stackOutput'. It returns the current value of stack output,
+// without verifying the stack. If stack or output does not
+// exist, def is returned.
+func (sm *StackManager) tplStackOutput(name, key string, def interface{}) interface{} {
+	data := sm.currentStackData(name)
+	if data == nil {
+		return def
+	}
+	value, ok := data.Outputs[key]
+	if !ok {
+		sm.warn(name, "output '%s' of stack '%s' not found, using default value", key, name)
+		return def
+	}
+	return value
+}
+
+// tplStackOr is function exposed to template engine with name
+// 'stackOr'. It returns the current data of stack, without
+// verifying the stack. If stack does not exist, def is returned.
+func (sm *StackManager) tplStackOr(name string, def interface{}) interface{} {
+	data := sm.currentStackData(name)
+	if data == nil {
+		return def
+	}
+	return data
+}
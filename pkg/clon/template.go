@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/Masterminds/sprig"
 	"github.com/juju/errors"
@@ -13,7 +14,10 @@ import (
 
 func newTemplate(funcs map[string]interface{}) *template.Template {
 	funcMap := sprig.TxtFuncMap()
-	funcMap["file"] = tplFuncFile
+	funcMap["file"] = tplFuncFile("")
+	funcMap["commaDelimitedList"] = tplCommaDelimitedList
+	funcMap["requiredEnv"] = tplRequiredEnv
+	funcMap["envOr"] = tplEnvOr
 	for name, fn := range funcs {
 		funcMap[name] = fn
 	}
@@ -52,17 +56,95 @@ func renderTemplate(content string, ctx interface{}, funcs map[string]interface{
 	return buf.String(), nil
 }
 
-func tplFuncFile(path string) (_ string, err error) {
-	var content []byte
-	p := filepath.Clean(path)
+// tplFuncFile returns the function exposed to template engine
+// with name 'file'. Relative paths are resolved against dir.
+func tplFuncFile(dir string) func(string) (string, error) {
+	return func(path string) (_ string, err error) {
+		var content []byte
+		p := filepath.Clean(path)
 
-	if p[0] == '/' || p == "." {
-		return "", errors.Errorf("Invalid path '%s', it is absolute or cannot be resolved", path)
+		if p[0] == '/' || p == "." {
+			return "", errors.Errorf("Invalid path '%s', it is absolute or cannot be resolved", path)
+		}
+
+		if content, err = ioutil.ReadFile(filepath.Join(dir, p)); err != nil {
+			return "", errors.Trace(err)
+		}
+
+		return string(content), nil
 	}
+}
 
-	if content, err = ioutil.ReadFile(p); err != nil {
-		return "", errors.Trace(err)
+// walkTemplate calls visit for each node of parsed template.
+func walkTemplate(tpl *template.Template, visit func(parse.Node)) {
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil {
+			walkNode(t.Tree.Root, visit)
+		}
+	}
+}
+
+func walkNode(node parse.Node, visit func(parse.Node)) {
+	visit(node)
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkNode(c, visit)
+		}
+	case *parse.ActionNode:
+		walkNode(n.Pipe, visit)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.TemplateNode:
+		walkNode(n.Pipe, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkNode(c, visit)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			walkNode(c, visit)
+		}
+	case *parse.ChainNode:
+		walkNode(n.Node, visit)
 	}
+}
+
+func walkBranch(n *parse.BranchNode, visit func(parse.Node)) {
+	walkNode(n.Pipe, visit)
+	walkNode(n.List, visit)
+	walkNode(n.ElseList, visit)
+}
 
-	return string(content), nil
+// templateCalls returns the first arguments of calls to function fn
+// in template content. Only string literal arguments are returned.
+// The funcs must include all functions used by template.
+func templateCalls(content, fn string, funcs map[string]interface{}) ([]string, error) {
+	tpl, err := newTemplate(funcs).Parse(content)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var res []string
+	walkTemplate(tpl, func(node parse.Node) {
+		n, ok := node.(*parse.CommandNode)
+		if !ok || len(n.Args) < 2 {
+			return
+		}
+		if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == fn {
+			if s, ok := n.Args[1].(*parse.StringNode); ok {
+				res = append(res, s.Text)
+			}
+		}
+	})
+	return res, nil
 }
@@ -0,0 +1,302 @@
+package clon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/juju/errors"
+)
+
+// VariableOriginConfig is the origin of variables defined in config.
+const VariableOriginConfig = "config"
+
+// VariableSource is a set of variables with common origin.
+type VariableSource struct {
+	// Origin describes where variables are coming from,
+	// for example "file:vars.yml" or "cli".
+	Origin string
+
+	// Variables is the map of variables.
+	Variables map[string]interface{}
+}
+
+// Variable represents the rendered variable.
+type Variable struct {
+	Name   string
+	Value  interface{}
+	Origin string
+}
+
+// mergeVariableSources merges the variables from sources. Variables
+// of later sources override the earlier ones. Returns the merged
+// variables and the origin of each variable.
+func mergeVariableSources(sources []VariableSource) (map[string]interface{}, map[string]string) {
+	vars := make(map[string]interface{})
+	origins := make(map[string]string)
+	for _, source := range sources {
+		for k, v := range source.Variables {
+			vars[k] = v
+			origins[k] = source.Origin
+		}
+	}
+	return vars, origins
+}
+
+// normalizeValue converts the maps decoded from YAML
+// (map[interface{}]interface{}) into map[string]interface{},
+// so that values can be used by template functions and
+// encoded as JSON.
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			res[fmt.Sprint(k)] = normalizeValue(e)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			res[k] = normalizeValue(e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, e := range val {
+			res[i] = normalizeValue(e)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// variableRefs returns the names of variables, referenced by
+// string values of v. Only references in form of .Var.name,
+// $.Var.name and (index .Var "name") are detected.
+func variableRefs(v interface{}, funcs map[string]interface{}) ([]string, error) {
+	refs := make(map[string]struct{})
+	if err := collectVariableRefs(v, funcs, refs); err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := make([]string, 0, len(refs))
+	for name := range refs {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func collectVariableRefs(v interface{}, funcs map[string]interface{}, refs map[string]struct{}) error {
+	switch val := v.(type) {
+	case string:
+		tpl, err := newTemplate(funcs).Parse(val)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		walkTemplate(tpl, addVariableRefs(refs))
+	case map[string]interface{}:
+		for _, e := range val {
+			if err := collectVariableRefs(e, funcs, refs); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	case []interface{}:
+		for _, e := range val {
+			if err := collectVariableRefs(e, funcs, refs); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// addVariableRefs is the visitor of template nodes, which adds
+// the names of referenced variables to refs.
+func addVariableRefs(refs map[string]struct{}) func(parse.Node) {
+	return func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.CommandNode:
+			if len(n.Args) == 3 {
+				if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "index" {
+					if isVarNode(n.Args[1]) {
+						if s, ok := n.Args[2].(*parse.StringNode); ok {
+							refs[s.Text] = struct{}{}
+						}
+					}
+				}
+			}
+		case *parse.FieldNode:
+			addVariableRef(n.Ident, refs)
+		case *parse.VariableNode:
+			if len(n.Ident) > 0 && n.Ident[0] == "$" {
+				addVariableRef(n.Ident[1:], refs)
+			}
+		}
+	}
+}
+
+// isVarNode indicates if node is reference to the .Var map itself.
+func isVarNode(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "Var"
+	case *parse.VariableNode:
+		return len(n.Ident) == 2 && n.Ident[0] == "$" && n.Ident[1] == "Var"
+	}
+	return false
+}
+
+func addVariableRef(ident []string, refs map[string]struct{}) {
+	if len(ident) >= 2 && ident[0] == "Var" {
+		refs[ident[1]] = struct{}{}
+	}
+}
+
+// sortVariables returns the variable names in dependency order,
+// so that each variable comes after all variables it refers to.
+// References to unknown variables are ignored.
+func sortVariables(deps map[string][]string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		res   = make([]string, 0, len(deps))
+		state = make(map[string]int, len(deps))
+		names = make([]string, 0, len(deps))
+		path  []string
+		visit func(name string) error
+	)
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			chain := []string{name}
+			for i := len(path) - 1; i >= 0 && path[i] != name; i-- {
+				chain = append([]string{path[i]}, chain...)
+			}
+			chain = append([]string{name}, chain...)
+			return errors.Errorf("cyclic reference between variables: %s", strings.Join(chain, " -> "))
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		res = append(res, name)
+		return nil
+	}
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return res, nil
+}
+
+// renderValue renders all string values of v as templates.
+// Maps and lists are rendered recursively, other values are
+// returned as is.
+func renderValue(v interface{}, ctx interface{}, funcs map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return renderTemplate(val, ctx, funcs)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			r, err := renderValue(e, ctx, funcs)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot render key '%s'", k)
+			}
+			res[k] = r
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, e := range val {
+			r, err := renderValue(e, ctx, funcs)
+			if err != nil {
+				return nil, errors.Annotatef(err, "cannot render element %d", i)
+			}
+			res[i] = r
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
+
+// resolveVariables renders the variables in dependency order.
+// Rendered variables are exposed to following ones as .Var
+// in template context ctx. The funcs returns the template
+// functions of named variable.
+func resolveVariables(vars map[string]interface{}, ctx map[string]interface{}, funcs func(name string) map[string]interface{}) (map[string]interface{}, error) {
+	deps := make(map[string][]string, len(vars))
+	values := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		values[name] = normalizeValue(v)
+		refs, err := variableRefs(values[name], funcs(name))
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot parse variable '%s'", name)
+		}
+		deps[name] = refs
+	}
+	order, err := sortVariables(deps)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := make(map[string]interface{}, len(vars))
+	ctx["Var"] = res
+	for _, name := range order {
+		if res[name], err = renderValue(values[name], ctx, funcs(name)); err != nil {
+			return nil, errors.Annotatef(err, "cannot render variable '%s'", name)
+		}
+	}
+	return res, nil
+}
+
+// tplCommaDelimitedList is function exposed to template engine with name
+// 'commaDelimitedList'. It joins the list into the value suitable for
+// CommaDelimitedList stack parameter.
+func tplCommaDelimitedList(v interface{}) (string, error) {
+	var list []string
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case []string:
+		list = val
+	case []interface{}:
+		list = make([]string, 0, len(val))
+		for i, e := range val {
+			switch e.(type) {
+			case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+				return "", errors.Errorf("element %d of list is not a scalar value", i)
+			}
+			list = append(list, fmt.Sprint(e))
+		}
+	default:
+		return "", errors.Errorf("cannot convert %T to comma delimited list", v)
+	}
+	for _, e := range list {
+		if strings.Contains(e, ",") {
+			return "", errors.Errorf("list element '%s' contains comma", e)
+		}
+	}
+	return strings.Join(list, ","), nil
+}
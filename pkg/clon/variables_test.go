@@ -0,0 +1,154 @@
+package clon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeValue(t *testing.T) {
+	require := require.New(t)
+
+	in := map[interface{}]interface{}{
+		"list": []interface{}{1, "a", map[interface{}]interface{}{"b": true}},
+		1:      "one",
+	}
+	expected := map[string]interface{}{
+		"list": []interface{}{1, "a", map[string]interface{}{"b": true}},
+		"1":    "one",
+	}
+	require.Equal(expected, normalizeValue(in))
+}
+
+func TestVariableRefs(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		value interface{}
+		refs  []string
+		err   bool
+	}{
+		{"plain", []string{}, false},
+		{"{{ .Var.a }}", []string{"a"}, false},
+		{"{{ $.Var.a.b }}-{{ .Var.c }}", []string{"a", "c"}, false},
+		{`{{ index .Var "a" }}`, []string{"a"}, false},
+		{"{{ if .Var.a }}{{ .Var.b }}{{ else }}{{ .Var.c }}{{ end }}", []string{"a", "b", "c"}, false},
+		{"{{ range .Var.a }}{{ . }}{{ end }}", []string{"a"}, false},
+		{"{{ .Var.a | upper }}", []string{"a"}, false},
+		{"{{ .Name }}", []string{}, false},
+		{[]interface{}{"{{ .Var.a }}", map[string]interface{}{"x": "{{ .Var.b }}"}, 1}, []string{"a", "b"}, false},
+		{"{{ .Var.a ", nil, true},
+	}
+	for _, input := range inputs {
+		refs, err := variableRefs(input.value, nil)
+		if input.err {
+			require.NotNil(err)
+			continue
+		}
+		require.Nilf(err, "error: %s", err)
+		require.Equalf(input.refs, refs, "input: %#+v", input.value)
+	}
+}
+
+func TestSortVariables(t *testing.T) {
+	require := require.New(t)
+
+	order, err := sortVariables(map[string][]string{
+		"a": {"b", "c"},
+		"b": {"c", "unknown"},
+		"c": {},
+	})
+	require.Nil(err)
+	require.Equal([]string{"c", "b", "a"}, order)
+
+	_, err = sortVariables(map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a"},
+	})
+	require.NotNil(err)
+	require.Contains(err.Error(), "a -> b -> c -> a")
+
+	_, err = sortVariables(map[string][]string{
+		"a": {"a"},
+	})
+	require.NotNil(err)
+	require.Contains(err.Error(), "a -> a")
+}
+
+func TestResolveVariables(t *testing.T) {
+	require := require.New(t)
+
+	vars := map[string]interface{}{
+		"env":     "prod",
+		"name":    "app-{{ .Var.env }}",
+		"port":    8080,
+		"enabled": true,
+		"subnets": []interface{}{"subnet-1", "subnet-2"},
+		"tags": map[interface{}]interface{}{
+			"Name": "{{ .Var.name }}",
+		},
+		"joined": "{{ .Var.subnets | commaDelimitedList }}",
+	}
+	res, err := resolveVariables(vars, map[string]interface{}{}, noFuncs)
+	require.Nil(err)
+	require.Equal(map[string]interface{}{
+		"env":     "prod",
+		"name":    "app-prod",
+		"port":    8080,
+		"enabled": true,
+		"subnets": []interface{}{"subnet-1", "subnet-2"},
+		"tags": map[string]interface{}{
+			"Name": "app-prod",
+		},
+		"joined": "subnet-1,subnet-2",
+	}, res)
+
+	_, err = resolveVariables(map[string]interface{}{
+		"a": "{{ .Var.b }}",
+		"b": []interface{}{"{{ .Var.a }}"},
+	}, map[string]interface{}{}, noFuncs)
+	require.NotNil(err)
+}
+
+func noFuncs(string) map[string]interface{} { return nil }
+
+func TestCommaDelimitedList(t *testing.T) {
+	require := require.New(t)
+
+	var inputs = []struct {
+		value    interface{}
+		expected string
+		err      bool
+	}{
+		{[]interface{}{"a", "b"}, "a,b", false},
+		{[]interface{}{1, true}, "1,true", false},
+		{[]string{"a"}, "a", false},
+		{[]interface{}{}, "", false},
+		{"a,b", "a,b", false},
+		{[]interface{}{"a,b"}, "", true},
+		{[]interface{}{[]interface{}{"a"}}, "", true},
+		{map[string]interface{}{}, "", true},
+	}
+	for _, input := range inputs {
+		res, err := tplCommaDelimitedList(input.value)
+		if input.err {
+			require.NotNilf(err, "input: %#+v", input.value)
+			continue
+		}
+		require.Nil(err)
+		require.Equal(input.expected, res)
+	}
+}
+
+func TestMergeVariableSources(t *testing.T) {
+	require := require.New(t)
+
+	vars, origins := mergeVariableSources([]VariableSource{
+		{Origin: VariableOriginConfig, Variables: map[string]interface{}{"a": "1", "b": "2"}},
+		{Origin: "file:vars.yml", Variables: map[string]interface{}{"b": "3", "c": []interface{}{"4"}}},
+		{Origin: "cli", Variables: map[string]interface{}{"c": "5"}},
+	})
+	require.Equal(map[string]interface{}{"a": "1", "b": "3", "c": "5"}, vars)
+	require.Equal(map[string]string{"a": VariableOriginConfig, "b": "file:vars.yml", "c": "cli"}, origins)
+}
@@ -0,0 +1,145 @@
+package s3file
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// AttributesMetadataKey is the user metadata key, which stores
+// the hash of object attributes set by Config.
+const AttributesMetadataKey = "Clon-Attributes-Md5"
+
+// defaultContentType is the content type of files, which
+// cannot be detected.
+const defaultContentType = "application/octet-stream"
+
+// AttributesHash returns the hash of object attributes, like
+// encryption, ACL, headers, tags and metadata. Empty string is
+// returned if no attributes are set.
+func (c Config) AttributesHash() string {
+	attrs := map[string]interface{}{}
+	for k, v := range map[string]string{
+		"CacheControl":         c.CacheControl,
+		"ContentEncoding":      c.ContentEncoding,
+		"ServerSideEncryption": c.serverSideEncryption(),
+		"SSEKMSKeyID":          c.SSEKMSKeyID,
+		"ACL":                  c.ACL,
+	} {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+	if len(c.Tags) > 0 {
+		attrs["Tags"] = c.Tags
+	}
+	if len(c.Metadata) > 0 {
+		attrs["Metadata"] = c.Metadata
+	}
+	if len(attrs) == 0 {
+		return ""
+	}
+	// json encodes maps with sorted keys
+	content, _ := json.Marshal(attrs)
+	h := md5.Sum(content)
+	return hex.EncodeToString(h[:])
+}
+
+// serverSideEncryption returns the server-side encryption algorithm.
+func (c Config) serverSideEncryption() string {
+	if c.ServerSideEncryption == "" && c.SSEKMSKeyID != "" {
+		return s3.ServerSideEncryptionAwsKms
+	}
+	return c.ServerSideEncryption
+}
+
+// tagging returns the tags in URL query format.
+func (c Config) tagging() *string {
+	if len(c.Tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(c.Tags))
+	for k := range c.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(c.Tags[k]))
+	}
+	return aws.String(strings.Join(parts, "&"))
+}
+
+// metadata returns the user metadata of object with content
+// hash and attributes hash.
+func (c Config) metadata(hash string) map[string]*string {
+	res := make(map[string]*string, len(c.Metadata)+2)
+	for k, v := range c.Metadata {
+		res[k] = aws.String(v)
+	}
+	res[HashMetadataKey] = aws.String(hash)
+	if h := c.AttributesHash(); h != "" {
+		res[AttributesMetadataKey] = aws.String(h)
+	}
+	return res
+}
+
+// optionalString returns nil for empty string.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// metadataValue returns the value of user metadata key.
+func metadataValue(metadata map[string]*string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v), true
+		}
+	}
+	return "", false
+}
+
+// attributesUnchanged indicates if previous object has the same
+// content type and attributes.
+func attributesUnchanged(prev *s3.HeadObjectOutput, c Config, contentType string) bool {
+	if aws.StringValue(prev.ContentType) != contentType {
+		return false
+	}
+	h, _ := metadataValue(prev.Metadata, AttributesMetadataKey)
+	return h == c.AttributesHash()
+}
+
+// contentTypeByName returns the content type identified
+// from extension of name.
+func contentTypeByName(name string) string {
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// sniffContentType identifies the content type from the
+// beginning of content and seeks back to start.
+func sniffContentType(content io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(content, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", errors.Annotatef(err, "cannot read content")
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Annotatef(err, "cannot seek")
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
@@ -0,0 +1,175 @@
+package s3file
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/juju/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// DefaultMultipartThreshold is the default size of content,
+	// starting from which multipart upload is used.
+	DefaultMultipartThreshold = 100 * 1024 * 1024
+
+	// DefaultPartSize is the default size of single part
+	// of multipart upload.
+	DefaultPartSize = 16 * 1024 * 1024
+
+	// MinPartSize is the minimum part size allowed by S3.
+	MinPartSize = 5 * 1024 * 1024
+
+	// HashMetadataKey is the user metadata key, which stores
+	// the md5 hash of whole content of uploaded objects.
+	HashMetadataKey = "Clon-Content-Md5"
+)
+
+// contentHash calculates md5 hash of whole content and
+// the ETag, which S3 assigns to multipart upload of content.
+type contentHash struct {
+	partSize int64
+	size     int64
+	whole    hash.Hash
+	part     hash.Hash
+	partLen  int64
+	parts    []byte
+	count    int
+}
+
+func newContentHash(partSize int64) *contentHash {
+	return &contentHash{
+		partSize: partSize,
+		whole:    md5.New(),
+		part:     md5.New(),
+	}
+}
+
+// Write implements io.Writer.
+func (h *contentHash) Write(p []byte) (int, error) {
+	n := len(p)
+	h.size += int64(n)
+	h.whole.Write(p)
+	for len(p) > 0 {
+		l := h.partSize - h.partLen
+		if int64(len(p)) < l {
+			l = int64(len(p))
+		}
+		h.part.Write(p[:l])
+		h.partLen += l
+		p = p[l:]
+		if h.partLen == h.partSize {
+			h.finishPart()
+		}
+	}
+	return n, nil
+}
+
+func (h *contentHash) finishPart() {
+	h.parts = h.part.Sum(h.parts)
+	h.part.Reset()
+	h.partLen = 0
+	h.count++
+}
+
+// Sum returns the md5 hash of whole content.
+func (h *contentHash) Sum() []byte {
+	return h.whole.Sum(nil)
+}
+
+// MultipartETag returns the ETag of multipart upload
+// of content in quoted form.
+func (h *contentHash) MultipartETag() string {
+	parts, count := h.parts, h.count
+	if h.partLen > 0 || count == 0 {
+		parts = h.part.Sum(append([]byte{}, parts...))
+		count++
+	}
+	sum := md5.Sum(parts)
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), count)
+}
+
+// isUnchanged indicates if previous object has same content. Content
+// hash is compared to hash metadata, or to plain or multipart ETag.
+func isUnchanged(prev *s3.HeadObjectOutput, hash string, multipartETag string) bool {
+	if h, ok := metadataValue(prev.Metadata, HashMetadataKey); ok {
+		return h == hash
+	}
+	etag := aws.StringValue(prev.ETag)
+	return etag == fmt.Sprintf(`"%s"`, hash) || (multipartETag != "" && etag == multipartETag)
+}
+
+// putMultipart uploads the content using multipart upload. The
+// upload is aborted on failure.
+func putMultipart(conn s3iface.S3API, f *File, c Config, content io.Reader, partSize int64) (_ *s3.CompleteMultipartUploadOutput, resErr error) {
+	upload, err := conn.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(f.Bucket),
+		Key:                  aws.String(f.Key),
+		ContentType:          aws.String(f.ContentType),
+		CacheControl:         optionalString(c.CacheControl),
+		ContentEncoding:      optionalString(c.ContentEncoding),
+		ServerSideEncryption: optionalString(c.serverSideEncryption()),
+		SSEKMSKeyId:          optionalString(c.SSEKMSKeyID),
+		ACL:                  optionalString(c.ACL),
+		Tagging:              c.tagging(),
+		Metadata:             c.metadata(f.Hash),
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot create multipart upload")
+	}
+	defer func() {
+		if resErr != nil {
+			conn.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(f.Bucket),
+				Key:      aws.String(f.Key),
+				UploadId: upload.UploadId,
+			})
+		}
+	}()
+
+	var parts []*s3.CompletedPart
+	buf := make([]byte, partSize)
+	for num := int64(1); ; num++ {
+		n, err := io.ReadFull(content, buf)
+		if err == io.EOF && num > 1 {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, errors.Annotatef(err, "cannot read part %d", num)
+		}
+		sum := md5.Sum(buf[:n])
+		out, err := conn.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(f.Bucket),
+			Key:        aws.String(f.Key),
+			UploadId:   upload.UploadId,
+			PartNumber: aws.Int64(num),
+			ContentMD5: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot upload part %d", num)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(num)})
+		if int64(n) < partSize {
+			break
+		}
+	}
+
+	out, err := conn.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(f.Bucket),
+		Key:             aws.String(f.Key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot complete multipart upload")
+	}
+	return out, nil
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
-	"hash"
 	"io"
 	"net/url"
 	"os"
@@ -36,15 +35,55 @@ type Config struct {
 	// bucket key.
 	Prefix string
 
-	// Content of the file. Used only by Write.
-	Content io.ReadSeeker
+	// Content of the file. Used only by Write. Content, which
+	// is not io.ReadSeeker, is streamed into temporary file while
+	// hashing, so it does not need to fit in memory.
+	Content io.Reader
 
-	// ContentType is the content-type of file.
+	// ContentType is the content-type of file. Used only by Write.
+	// If not set, it is detected from extension of key or content.
 	ContentType string
 
+	// CacheControl is the Cache-Control header of object.
+	CacheControl string
+
+	// ContentEncoding is the Content-Encoding header of object.
+	ContentEncoding string
+
+	// ServerSideEncryption is the server-side encryption algorithm,
+	// AES256 or aws:kms. Defaults to aws:kms if SSEKMSKeyID is set.
+	ServerSideEncryption string
+
+	// SSEKMSKeyID is the KMS key ID, ARN or alias used for
+	// server-side encryption.
+	SSEKMSKeyID string
+
+	// ACL is the canned ACL of object.
+	ACL string
+
+	// Tags is the map of object tags.
+	Tags map[string]string
+
+	// Metadata is the map of user metadata of object.
+	Metadata map[string]string
+
 	// MaxSize is the maximum size of Read or Write operation.
 	MaxSize int64
 
+	// MultipartThreshold is the size of content, starting from which
+	// multipart upload is used. Defaults to DefaultMultipartThreshold,
+	// negative value disables multipart uploads.
+	MultipartThreshold int64
+
+	// PartSize is the size of single part of multipart upload.
+	// Defaults to DefaultPartSize.
+	PartSize int64
+
+	// Hash is the precomputed md5 of content in hex
+	// representation. If set, content is not hashed by Write
+	// and is opened only if upload is needed.
+	Hash string
+
 	// VersionID is the version of S3 object. Used only
 	// by Read.
 	VersionID string
@@ -68,6 +107,9 @@ type File struct {
 	// Hash is the md5 of content in hex representation.
 	Hash string
 
+	// ETag is the entity tag of s3 object.
+	ETag string
+
 	// Body is the content of object. Set by Read function.
 	Body io.ReadCloser
 
@@ -82,7 +124,7 @@ type File struct {
 	URL string
 }
 
-func setHash(r io.ReadSeeker, h hash.Hash, maxSize int64) (err error) {
+func setHash(r io.ReadSeeker, h io.Writer, maxSize int64) (err error) {
 	buf := make([]byte, 4096)
 	size := int64(0)
 	for {
@@ -130,6 +172,19 @@ func (f *File) setLocation(c Config) error {
 	return nil
 }
 
+// nullVersionID is the version ID of objects in buckets
+// without versioning, as it is returned by list operations.
+const nullVersionID = "null"
+
+// versionID returns the version ID of object. Version of
+// objects in buckets without versioning is empty.
+func versionID(v *string) string {
+	if id := aws.StringValue(v); id != nullVersionID {
+		return id
+	}
+	return ""
+}
+
 func (f *File) setURL() {
 	if f.Region == "" {
 		return
@@ -150,6 +205,7 @@ func Write(conn s3iface.S3API, c Config) (*File, error) {
 	var (
 		content io.ReadSeeker
 		f       = &File{}
+		h       []byte
 		err     error
 	)
 
@@ -160,28 +216,78 @@ func Write(conn s3iface.S3API, c Config) (*File, error) {
 	// identify content
 	if c.Content == nil && c.Source == "" {
 		return nil, errors.Errorf("s3 upload failed, neither content nor source are set")
-	} else if c.Content == nil {
-		content, err = os.Open(c.Source)
+	}
+	openContent := func() error {
+		if content != nil {
+			return nil
+		}
+		file, err := os.Open(c.Source)
 		if err != nil {
-			return nil, errors.Annotatef(err, "s3 upload failed, cannot open file")
+			return errors.Annotatef(err, "s3 upload failed, cannot open file")
 		}
-	} else {
-		content = c.Content
+		content = file
+		return nil
+	}
+	if rs, ok := c.Content.(io.ReadSeeker); ok {
+		content = rs
+	}
+	defer func() {
+		if closer, ok := content.(io.Closer); ok && c.Content == nil {
+			closer.Close()
+		}
+	}()
+
+	threshold, partSize := c.MultipartThreshold, c.PartSize
+	if threshold == 0 {
+		threshold = DefaultMultipartThreshold
+	}
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	} else if partSize < MinPartSize {
+		return nil, errors.Errorf("s3 upload failed, part size must be at least %d", MinPartSize)
 	}
 
 	// calculate content hash
-	hash := md5.New()
-	if err = setHash(content, hash, c.MaxSize); err != nil {
-		return nil, errors.Annotatef(err, "s3 upload failed")
+	var multipartETag string
+	if _, ok := c.Content.(io.ReadSeeker); c.Content != nil && !ok {
+		hash := newContentHash(partSize)
+		tmp, err := spool(c.Content, hash, c.MaxSize)
+		if err != nil {
+			return nil, errors.Annotatef(err, "s3 upload failed")
+		}
+		defer func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		content = tmp
+		h = hash.Sum()
+		multipartETag = hash.MultipartETag()
+	} else if c.Hash != "" {
+		if h, err = hex.DecodeString(c.Hash); err != nil || len(h) != md5.Size {
+			return nil, errors.Errorf("s3 upload failed, invalid hash '%s'", c.Hash)
+		}
+	} else {
+		if err = openContent(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		hash := newContentHash(partSize)
+		if err = setHash(content, hash, c.MaxSize); err != nil {
+			return nil, errors.Annotatef(err, "s3 upload failed")
+		}
+		h = hash.Sum()
+		multipartETag = hash.MultipartETag()
 	}
-	h := hash.Sum(nil)
-	h = h[:]
 	f.Hash = hex.EncodeToString(h)
 
-	if c.ContentType == "" {
-		f.ContentType = "application/octet-stream"
-	} else {
-		f.ContentType = c.ContentType
+	if f.ContentType = c.ContentType; f.ContentType == "" {
+		if f.ContentType = contentTypeByName(f.Key); f.ContentType == "" {
+			if err = openContent(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if f.ContentType, err = sniffContentType(content); err != nil {
+				return nil, errors.Annotatef(err, "s3 upload failed, cannot detect content type")
+			}
+		}
 	}
 
 	// check if file already exists
@@ -193,28 +299,78 @@ func Write(conn s3iface.S3API, c Config) (*File, error) {
 		if awsErr, ok := err.(awserr.RequestFailure); !ok || awsErr.StatusCode() != 404 {
 			return nil, errors.Annotatef(err, "s3 upload failed, cannot read previous file")
 		}
-	} else if aws.StringValue(prev.ContentType) == f.ContentType && aws.StringValue(prev.ETag) == fmt.Sprintf(`"%s"`, f.Hash) {
+	} else if attributesUnchanged(prev, c, f.ContentType) && isUnchanged(prev, f.Hash, multipartETag) {
 		// file is not changed
-		f.VersionID = aws.StringValue(prev.VersionId)
+		f.VersionID = versionID(prev.VersionId)
+		f.ETag = aws.StringValue(prev.ETag)
+		f.setURL()
+		return f, nil
+	}
+
+	if err = openContent(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "s3 upload failed, cannot seek")
+	}
+	if threshold > 0 && size >= threshold {
+		out, err := putMultipart(conn, f, c, content, partSize)
+		if err != nil {
+			return nil, errors.Annotatef(err, "s3 upload failed")
+		}
+		f.VersionID = versionID(out.VersionId)
+		f.ETag = aws.StringValue(out.ETag)
 		f.setURL()
-		return f, err
+		return f, nil
 	}
 
 	out, err := conn.PutObject(&s3.PutObjectInput{
-		Bucket:      aws.String(f.Bucket),
-		Key:         aws.String(f.Key),
-		ContentType: aws.String(f.ContentType),
-		ContentMD5:  aws.String(base64.StdEncoding.EncodeToString(h)),
-		Body:        content,
+		Bucket:               aws.String(f.Bucket),
+		Key:                  aws.String(f.Key),
+		ContentType:          aws.String(f.ContentType),
+		ContentMD5:           aws.String(base64.StdEncoding.EncodeToString(h)),
+		CacheControl:         optionalString(c.CacheControl),
+		ContentEncoding:      optionalString(c.ContentEncoding),
+		ServerSideEncryption: optionalString(c.serverSideEncryption()),
+		SSEKMSKeyId:          optionalString(c.SSEKMSKeyID),
+		ACL:                  optionalString(c.ACL),
+		Tagging:              c.tagging(),
+		Metadata:             c.metadata(f.Hash),
+		Body:                 content,
 	})
 	if err != nil {
 		return nil, errors.Annotatef(err, "s3 upload failed")
 	}
-	f.VersionID = aws.StringValue(out.VersionId)
+	f.VersionID = versionID(out.VersionId)
+	f.ETag = aws.StringValue(out.ETag)
 	f.setURL()
 	return f, err
 }
 
+// Known returns the File of object, which is known to be
+// up-to-date with given version and hash, without calling S3.
+func Known(c Config, versionID, hash string) (*File, error) {
+	f := &File{
+		VersionID:   versionID,
+		Hash:        hash,
+		ContentType: c.ContentType,
+	}
+	if err := f.setLocation(c); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if f.ContentType == "" {
+		if f.ContentType = contentTypeByName(f.Key); f.ContentType == "" {
+			f.ContentType = defaultContentType
+		}
+	}
+	f.setURL()
+	return f, nil
+}
+
 // Read will read file specified by Config from S3.
 func Read(conn s3iface.S3API, c Config) (*File, error) {
 	var (
@@ -243,12 +399,17 @@ func Read(conn s3iface.S3API, c Config) (*File, error) {
 		return nil, errors.NotFoundf("file '%s/%s' not found", f.Bucket, f.Key)
 	}
 
-	f.VersionID = aws.StringValue(out.VersionId)
-	h := aws.StringValue(out.ETag)
+	f.VersionID = versionID(out.VersionId)
+	f.ETag = aws.StringValue(out.ETag)
+	h := f.ETag
 	if len(h) > 2 {
 		// remove quotes
 		f.Hash = h[1 : len(h)-1]
 	}
+	// ETag of multipart objects is not md5 of content
+	if h, ok := metadataValue(out.Metadata, HashMetadataKey); ok {
+		f.Hash = h
+	}
 	f.Body = out.Body
 	f.setURL()
 	return f, nil
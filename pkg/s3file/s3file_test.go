@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -132,6 +133,27 @@ type mockS3Client struct {
 	putObject  func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
 	headObject func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
 	getObject  func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+
+	createMultipartUpload   func(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	uploadPart              func(*s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	completeMultipartUpload func(*s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	abortMultipartUpload    func(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+}
+
+func (m *mockS3Client) CreateMultipartUpload(in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return m.createMultipartUpload(in)
+}
+
+func (m *mockS3Client) UploadPart(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	return m.uploadPart(in)
+}
+
+func (m *mockS3Client) CompleteMultipartUpload(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	return m.completeMultipartUpload(in)
+}
+
+func (m *mockS3Client) AbortMultipartUpload(in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	return m.abortMultipartUpload(in)
 }
 
 func (m *mockS3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
@@ -404,7 +426,10 @@ func TestWrite_basic(t *testing.T) {
 				url = "https://s3." + config.Region + ".amazonaws.com/" + config.Bucket + "/" + config.Key
 			}
 			require.Equal(url, file.URL)
-			if config.ContentType == "" {
+			if config.ContentType == "" && config.Source != "" {
+				// detected from content
+				require.Equal("text/plain; charset=utf-8", file.ContentType)
+			} else if config.ContentType == "" {
 				require.Equal("application/octet-stream", file.ContentType)
 			} else {
 				require.Equal(config.ContentType, file.ContentType)
@@ -648,3 +673,366 @@ func TestRead_basic(t *testing.T) {
 		input.check(input.config, file, err)
 	}
 }
+
+func TestWrite_hash(t *testing.T) {
+	require := require.New(t)
+
+	content := []byte("content")
+	h := md5.Sum(content)
+	hash := hex.EncodeToString(h[:])
+	etag := fmt.Sprintf(`"%s"`, hash)
+
+	// content is not opened, if precomputed hash is not changed
+	f, err := Write(&mockS3Client{
+		headObject: func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{
+				ETag:        aws.String(etag),
+				ContentType: aws.String("text/plain"),
+				VersionId:   aws.String("v1"),
+			}, nil
+		},
+	}, Config{Bucket: "bucket", Source: "testdata/missing.txt", Hash: hash, ContentType: "text/plain"})
+	require.Nil(err)
+	require.Equal("v1", f.VersionID)
+	require.Equal(hash, f.Hash)
+
+	// content is uploaded, if hash is changed
+	f, err = Write(&mockS3Client{
+		headObject: mockS3ClientHeadObjectNoSuchKey(t),
+		putObject:  mockS3ClientPutObjectNoop(t, "v2"),
+	}, Config{Bucket: "bucket", Key: "key", Content: newTestReadSeeker(content), Hash: hash})
+	require.Nil(err)
+	require.Equal("v2", f.VersionID)
+
+	_, err = Write(&mockS3Client{}, Config{Bucket: "bucket", Key: "key", Content: newTestReadSeeker(content), Hash: "invalid"})
+	require.NotNil(err)
+}
+
+func TestKnown(t *testing.T) {
+	require := require.New(t)
+
+	f, err := Known(Config{Bucket: "bucket", Prefix: "p/", Key: "key", Region: "eu-central-1"}, "v1", "hash")
+	require.Nil(err)
+	require.Equal(&File{
+		Bucket:      "bucket",
+		Key:         "p/key",
+		VersionID:   "v1",
+		Hash:        "hash",
+		ContentType: "application/octet-stream",
+		Region:      "eu-central-1",
+		URL:         "https://s3.eu-central-1.amazonaws.com/bucket/p/key?versionId=v1",
+	}, f)
+
+	_, err = Known(Config{}, "v1", "hash")
+	require.NotNil(err)
+}
+
+func TestContentHash(t *testing.T) {
+	require := require.New(t)
+
+	content := randomBytes(2*MinPartSize + 1000)
+	var parts []byte
+	for _, p := range [][]byte{content[:MinPartSize], content[MinPartSize : 2*MinPartSize], content[2*MinPartSize:]} {
+		sum := md5.Sum(p)
+		parts = append(parts, sum[:]...)
+	}
+	sum := md5.Sum(parts)
+	whole := md5.Sum(content)
+
+	h := newContentHash(MinPartSize)
+	// write in chunks not aligned with parts
+	for i := 0; i < len(content); i += 3000 {
+		end := i + 3000
+		if end > len(content) {
+			end = len(content)
+		}
+		h.Write(content[i:end])
+	}
+	require.Equal(whole[:], h.Sum())
+	require.Equal(fmt.Sprintf(`"%s-3"`, hex.EncodeToString(sum[:])), h.MultipartETag())
+
+	// content aligned with part size
+	h = newContentHash(MinPartSize)
+	h.Write(content[:2*MinPartSize])
+	s1, s2 := md5.Sum(content[:MinPartSize]), md5.Sum(content[MinPartSize:2*MinPartSize])
+	sum = md5.Sum(append(s1[:], s2[:]...))
+	require.Equal(fmt.Sprintf(`"%s-2"`, hex.EncodeToString(sum[:])), h.MultipartETag())
+}
+
+func TestWrite_multipart(t *testing.T) {
+	require := require.New(t)
+
+	content := randomBytes(2*MinPartSize + 1000)
+	whole := md5.Sum(content)
+	wholeHex := hex.EncodeToString(whole[:])
+	h := newContentHash(MinPartSize)
+	h.Write(content)
+	multipartETag := h.MultipartETag()
+	config := Config{
+		Bucket:             "bucket",
+		Key:                "key",
+		Content:            newTestReadSeeker(content),
+		MultipartThreshold: MinPartSize,
+		PartSize:           MinPartSize,
+	}
+
+	var uploaded []byte
+	var completed *s3.CompleteMultipartUploadInput
+	var aborted bool
+	client := &mockS3Client{
+		headObject: mockS3ClientHeadObjectNoSuchKey(t),
+		createMultipartUpload: func(in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+			require.Equal(wholeHex, aws.StringValue(in.Metadata[HashMetadataKey]))
+			return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+		},
+		uploadPart: func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+			body, err := ioutil.ReadAll(in.Body)
+			require.Nil(err)
+			sum := md5.Sum(body)
+			require.Equal(base64.StdEncoding.EncodeToString(sum[:]), aws.StringValue(in.ContentMD5))
+			uploaded = append(uploaded, body...)
+			return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])))}, nil
+		},
+		completeMultipartUpload: func(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+			completed = in
+			return &s3.CompleteMultipartUploadOutput{VersionId: aws.String("v1"), ETag: aws.String(multipartETag)}, nil
+		},
+		abortMultipartUpload: func(in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+			aborted = true
+			return &s3.AbortMultipartUploadOutput{}, nil
+		},
+	}
+
+	f, err := Write(client, config)
+	require.Nil(err)
+	require.Equal("v1", f.VersionID)
+	require.Equal(wholeHex, f.Hash)
+	require.Equal(content, uploaded)
+	require.Len(completed.MultipartUpload.Parts, 3)
+	require.Equal(int64(3), aws.Int64Value(completed.MultipartUpload.Parts[2].PartNumber))
+	require.False(aborted)
+
+	// unchanged multipart object is detected by ETag
+	config.Content = newTestReadSeeker(content)
+	client.headObject = func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		return &s3.HeadObjectOutput{
+			ETag:        aws.String(multipartETag),
+			ContentType: aws.String("application/octet-stream"),
+			VersionId:   aws.String("v1"),
+		}, nil
+	}
+	f, err = Write(client, config)
+	require.Nil(err)
+	require.Equal("v1", f.VersionID)
+
+	// unchanged object is detected by hash metadata with precomputed hash
+	config.Hash = wholeHex
+	client.headObject = func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		return &s3.HeadObjectOutput{
+			ETag:        aws.String(`"other-2"`),
+			ContentType: aws.String("application/octet-stream"),
+			Metadata:    map[string]*string{"Clon-Content-Md5": aws.String(wholeHex)},
+			VersionId:   aws.String("v1"),
+		}, nil
+	}
+	f, err = Write(client, config)
+	require.Nil(err)
+	require.Equal("v1", f.VersionID)
+
+	// upload is aborted on failure
+	config.Hash = ""
+	config.Content = newTestReadSeeker(content)
+	client.headObject = mockS3ClientHeadObjectNoSuchKey(t)
+	client.uploadPart = func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+		return nil, errors.New("failed")
+	}
+	_, err = Write(client, config)
+	require.NotNil(err)
+	require.True(aborted)
+
+	// part size is validated
+	config.PartSize = 1
+	_, err = Write(client, config)
+	require.NotNil(err)
+}
+
+func TestRead_hashMetadata(t *testing.T) {
+	require := require.New(t)
+
+	f, err := Read(&mockS3Client{
+		getObject: func(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body:      &testReadCloser{},
+				ETag:      aws.String(`"multipart-2"`),
+				Metadata:  map[string]*string{"Clon-Content-Md5": aws.String("hash")},
+				VersionId: aws.String("v1"),
+			}, nil
+		},
+	}, Config{Bucket: "bucket", Key: "key"})
+	require.Nil(err)
+	require.Equal("hash", f.Hash)
+}
+
+func TestWrite_objectAttributes(t *testing.T) {
+	require := require.New(t)
+
+	content := []byte(`{"a": 1}`)
+	config := Config{
+		Bucket:          "bucket",
+		Key:             "config.json",
+		Content:         newTestReadSeeker(content),
+		CacheControl:    "max-age=60",
+		ContentEncoding: "identity",
+		SSEKMSKeyID:     "alias/clon",
+		ACL:             s3.ObjectCannedACLBucketOwnerFullControl,
+		Tags:            map[string]string{"team": "a b", "env": "prod"},
+		Metadata:        map[string]string{"Owner": "team-a"},
+	}
+
+	var put *s3.PutObjectInput
+	client := &mockS3Client{
+		headObject: mockS3ClientHeadObjectNoSuchKey(t),
+		putObject: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			put = in
+			return &s3.PutObjectOutput{VersionId: aws.String("v1")}, nil
+		},
+	}
+	f, err := Write(client, config)
+	require.Nil(err)
+	require.Equal("application/json", f.ContentType)
+	require.Equal("application/json", aws.StringValue(put.ContentType))
+	require.Equal("max-age=60", aws.StringValue(put.CacheControl))
+	require.Equal("identity", aws.StringValue(put.ContentEncoding))
+	require.Equal(s3.ServerSideEncryptionAwsKms, aws.StringValue(put.ServerSideEncryption))
+	require.Equal("alias/clon", aws.StringValue(put.SSEKMSKeyId))
+	require.Equal(s3.ObjectCannedACLBucketOwnerFullControl, aws.StringValue(put.ACL))
+	require.Equal("env=prod&team=a+b", aws.StringValue(put.Tagging))
+	require.Equal("team-a", aws.StringValue(put.Metadata["Owner"]))
+	require.Equal(config.AttributesHash(), aws.StringValue(put.Metadata[AttributesMetadataKey]))
+	require.Equal(f.Hash, aws.StringValue(put.Metadata[HashMetadataKey]))
+
+	// unchanged object with same attributes is not uploaded
+	head := &s3.HeadObjectOutput{
+		ETag:        aws.String(fmt.Sprintf(`"%s"`, f.Hash)),
+		ContentType: put.ContentType,
+		Metadata: map[string]*string{
+			"Owner":               aws.String("team-a"),
+			"Clon-Content-Md5":    aws.String(f.Hash),
+			"Clon-Attributes-Md5": aws.String(config.AttributesHash()),
+		},
+		VersionId: aws.String("v1"),
+	}
+	client.headObject = func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		return head, nil
+	}
+	put = nil
+	config.Content = newTestReadSeeker(content)
+	_, err = Write(client, config)
+	require.Nil(err)
+	require.Nil(put)
+
+	// changed attributes are uploaded
+	config.Content = newTestReadSeeker(content)
+	config.Tags = map[string]string{"team": "b"}
+	_, err = Write(client, config)
+	require.Nil(err)
+	require.NotNil(put)
+
+	// attributes hash is empty without attributes
+	require.Equal("", Config{Bucket: "bucket"}.AttributesHash())
+	require.NotEqual("", Config{ServerSideEncryption: "AES256"}.AttributesHash())
+}
+
+// testReader hides Seek method of underlying reader.
+type testReader struct {
+	io.Reader
+}
+
+func TestWrite_stream(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "s3file")
+	require.Nil(err)
+	defer os.RemoveAll(tmpDir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmpDir)
+
+	content := randomBytes(2*MinPartSize + 1000)
+	whole := md5.Sum(content)
+	wholeHex := hex.EncodeToString(whole[:])
+	requireNoTempFiles := func() {
+		files, err := ioutil.ReadDir(tmpDir)
+		require.Nil(err)
+		require.Empty(files)
+	}
+
+	var uploaded []byte
+	client := &mockS3Client{
+		headObject: mockS3ClientHeadObjectNoSuchKey(t),
+		putObject: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			uploaded, err = ioutil.ReadAll(in.Body)
+			require.Nil(err)
+			return &s3.PutObjectOutput{VersionId: aws.String("v1")}, nil
+		},
+	}
+
+	// non-seekable content is uploaded
+	f, err := Write(client, Config{Bucket: "bucket", Key: "key", Content: &testReader{bytes.NewReader(content)}})
+	require.Nil(err)
+	require.Equal(wholeHex, f.Hash)
+	require.Equal(content, uploaded)
+	requireNoTempFiles()
+
+	// unchanged content is not uploaded
+	uploaded = nil
+	client.headObject = func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		return &s3.HeadObjectOutput{
+			ETag:        aws.String(fmt.Sprintf(`"%s"`, wholeHex)),
+			ContentType: aws.String("application/octet-stream"),
+			VersionId:   aws.String("v1"),
+		}, nil
+	}
+	f, err = Write(client, Config{Bucket: "bucket", Key: "key", Content: &testReader{bytes.NewReader(content)}})
+	require.Nil(err)
+	require.Equal("v1", f.VersionID)
+	require.Nil(uploaded)
+	requireNoTempFiles()
+
+	// MaxSize is checked
+	_, err = Write(client, Config{Bucket: "bucket", Key: "key", Content: &testReader{bytes.NewReader(content)}, MaxSize: 100})
+	require.NotNil(err)
+	require.Contains(err.Error(), "file is too big")
+	requireNoTempFiles()
+
+	// read errors are reported
+	_, err = Write(client, Config{Bucket: "bucket", Key: "key", Content: &testReader{newTestCustomReadSeeker(nil, func(p []byte) (int, error) {
+		return 0, fmt.Errorf("some error")
+	}, nil)}})
+	require.NotNil(err)
+	requireNoTempFiles()
+
+	// large content is streamed using multipart upload
+	var parts int
+	client.headObject = mockS3ClientHeadObjectNoSuchKey(t)
+	client.createMultipartUpload = func(in *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+		return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+	}
+	client.uploadPart = func(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+		parts++
+		return &s3.UploadPartOutput{ETag: aws.String(`"etag"`)}, nil
+	}
+	client.completeMultipartUpload = func(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+		return &s3.CompleteMultipartUploadOutput{VersionId: aws.String("v2")}, nil
+	}
+	f, err = Write(client, Config{
+		Bucket:             "bucket",
+		Key:                "key",
+		Content:            &testReader{bytes.NewReader(content)},
+		MultipartThreshold: MinPartSize,
+		PartSize:           MinPartSize,
+	})
+	require.Nil(err)
+	require.Equal("v2", f.VersionID)
+	require.Equal(3, parts)
+	requireNoTempFiles()
+}
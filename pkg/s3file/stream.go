@@ -0,0 +1,40 @@
+package s3file
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/juju/errors"
+)
+
+// spool copies the content of r into temporary file, writing it
+// also into h. The returned file is positioned at start and must
+// be removed by caller.
+func spool(r io.Reader, h io.Writer, maxSize int64) (_ *os.File, resErr error) {
+	tmp, err := ioutil.TempFile("", "s3file-")
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot create temporary file")
+	}
+	defer func() {
+		if resErr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	src := r
+	if maxSize != 0 {
+		src = io.LimitReader(r, maxSize+1)
+	}
+	n, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot spool content")
+	}
+	if maxSize != 0 && n > maxSize {
+		return nil, errors.Errorf("file is too big, size is > %d", maxSize)
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Annotatef(err, "cannot seek temporary file")
+	}
+	return tmp, nil
+}